@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"item-archive-d/internal/blob"
+	"item-archive-d/internal/db"
+	"maps"
+	"os"
+	"slices"
+)
+
+const (
+	// a resource references an image which is not in the blob store
+	fsckMissingBlob = "missing_blob"
+	// an attachment references a blob which is not in the blob store
+	fsckMissingAttachment = "missing_attachment"
+	// the contents of a blob do not hash to its id anymore
+	fsckCorruptBlob = "corrupt_blob"
+	// resource_fts does not match the resource table
+	fsckSearchIndex = "search_index_out_of_sync"
+	// the parents of a set of resources loop back on themselves
+	fsckParentCycle = "parent_cycle"
+	// multiple siblings share the same name, only one of them can be resolved
+	fsckNameCollision = "name_collision"
+)
+
+type fsckReport_Issue struct {
+	Kind        string  `json:"kind"`
+	Detail      string  `json:"detail"`
+	ResourceIDs []int64 `json:"resource_ids,omitempty"`
+	Blob        string  `json:"blob,omitempty"`
+	Repaired    bool    `json:"repaired"`
+}
+
+type fsckReport struct {
+	Issues []fsckReport_Issue `json:"issues"`
+}
+
+func (r fsckReport) unrepaired() (count int) {
+	for _, issue := range r.Issues {
+		if !issue.Repaired {
+			count++
+		}
+	}
+	return
+}
+
+// checkBlobs reports missing and corrupt blobs, references to missing blobs
+// are cleared when repairing since the image is lost either way
+func checkBlobs(ctx context.Context, txqry *db.Queries, blobs blob.Store, repair bool) (issues []fsckReport_Issue, err error) {
+	references, err := txqry.ListImageReferences(ctx)
+	if err != nil {
+		return
+	}
+	missing := map[string][]db.ListImageReferencesRow{}
+	present := map[string]struct{}{}
+	for _, ref := range references {
+		name, err := blob.CanonicalID(ref.Image.String)
+		if err != nil {
+			// an invalid id can never be opened, so it is as good as missing
+			name = ref.Image.String
+		}
+		if _, ok := present[name]; ok {
+			continue
+		}
+		if _, ok := missing[name]; !ok {
+			f, err := blobs.Open(name)
+			if err == nil {
+				f.Close()
+				present[name] = struct{}{}
+				continue
+			}
+		}
+		missing[name] = append(missing[name], ref)
+	}
+
+	names := slices.Sorted(maps.Keys(missing))
+	for _, name := range names {
+		refs := missing[name]
+		var ids []int64
+		for _, ref := range refs {
+			// legacy ids may be referenced both as an integer and as text
+			if !slices.Contains(ids, ref.ID) {
+				ids = append(ids, ref.ID)
+			}
+		}
+		issue := fsckReport_Issue{
+			Kind:        fsckMissingBlob,
+			Detail:      fmt.Sprintf("blob %s is referenced by %d resource(s) but does not exist", name, len(ids)),
+			ResourceIDs: ids,
+			Blob:        name,
+		}
+		if repair {
+			// removing a primary image from a gallery makes the next image
+			// primary, or clears the image of the resource if it was the last
+			for _, ref := range refs {
+				err = txqry.DeleteResourceImageByBlob(ctx, db.DeleteResourceImageByBlobParams{
+					ResourceID: ref.ID,
+					Image:      ref.Image.String,
+				})
+				if err != nil {
+					return
+				}
+			}
+			issue.Repaired = true
+		}
+		issues = append(issues, issue)
+	}
+
+	files, err := blobs.List()
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if f.Temporary {
+			continue
+		}
+		var ok bool
+		ok, err = blobs.Verify(f.Name)
+		if os.IsNotExist(err) {
+			// removed since listing
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if ok {
+			continue
+		}
+		issues = append(issues, fsckReport_Issue{
+			Kind:   fsckCorruptBlob,
+			Detail: fmt.Sprintf("contents of blob %s do not match its hash", f.Name),
+			Blob:   f.Name,
+		})
+	}
+	return
+}
+
+// checkAttachments reports attachments whose blob is missing, they are deleted
+// when repairing since their contents are lost either way
+func checkAttachments(ctx context.Context, txqry *db.Queries, blobs blob.Store, repair bool) (issues []fsckReport_Issue, err error) {
+	attachments, err := txqry.ListAllAttachments(ctx)
+	if err != nil {
+		return
+	}
+	for _, a := range attachments {
+		f, openErr := blobs.Open(a.BlobID)
+		if openErr == nil {
+			f.Close()
+			continue
+		}
+		issue := fsckReport_Issue{
+			Kind:        fsckMissingAttachment,
+			Detail:      fmt.Sprintf("attachment %d (%s) references blob %s which does not exist", a.ID, a.Filename, a.BlobID),
+			ResourceIDs: []int64{a.ResourceID},
+			Blob:        a.BlobID,
+		}
+		if repair {
+			err = txqry.DeleteAttachment(ctx, a.ID)
+			if err != nil {
+				return
+			}
+			issue.Repaired = true
+		}
+		issues = append(issues, issue)
+	}
+	return
+}
+
+func checkSearchIndex(ctx context.Context, txqry *db.Queries, repair bool) (issues []fsckReport_Issue, err error) {
+	ok, err := txqry.CheckSearchIndex(ctx)
+	if err != nil || ok {
+		return
+	}
+	issue := fsckReport_Issue{
+		Kind:   fsckSearchIndex,
+		Detail: "resource_fts does not match the resource table",
+	}
+	if repair {
+		err = txqry.RebuildSearchIndex(ctx)
+		if err != nil {
+			return
+		}
+		issue.Repaired = true
+	}
+	issues = append(issues, issue)
+	return
+}
+
+// checkParentCycles reports every cycle of parents, cycles are broken when
+// repairing by moving the member with the smallest id to the root
+func checkParentCycles(ctx context.Context, txqry *db.Queries, repair bool) (issues []fsckReport_Issue, err error) {
+	unreachable, err := txqry.GetUnreachable(ctx)
+	if err != nil {
+		return
+	}
+	parents := make(map[int64]int64, len(unreachable))
+	for _, r := range unreachable {
+		// unreachable resources always have a parent, otherwise they would
+		// be a root
+		parents[r.ID] = r.ParentID.Int64
+	}
+
+	// every unreachable resource leads into exactly one cycle when following
+	// its parents
+	seen := map[int64]struct{}{}
+	for _, r := range unreachable {
+		var walk []int64
+		id := r.ID
+		for {
+			if _, ok := seen[id]; ok {
+				break
+			}
+			seen[id] = struct{}{}
+			walk = append(walk, id)
+			id = parents[id]
+		}
+		start := slices.Index(walk, id)
+		if start < 0 {
+			// joined a path which has already been walked
+			continue
+		}
+		cycle := slices.Clone(walk[start:])
+		slices.Sort(cycle)
+
+		issue := fsckReport_Issue{
+			Kind:        fsckParentCycle,
+			Detail:      fmt.Sprintf("the parents of resources %v form a cycle", cycle),
+			ResourceIDs: cycle,
+		}
+		if repair {
+			var renamed string
+			renamed, err = moveToRoot(ctx, txqry, cycle[0])
+			if err != nil {
+				return
+			}
+			if renamed != "" {
+				issue.Detail += fmt.Sprintf(", resource %d was renamed to %q since its name is taken at the root", cycle[0], renamed)
+			}
+			issue.Repaired = true
+		}
+		issues = append(issues, issue)
+	}
+	return
+}
+
+// moveToRoot moves a resource to the root. if its name is taken there it is
+// renamed the same way as by the 0006_unique_names migration, the new name is
+// returned in that case.
+func moveToRoot(ctx context.Context, txqry *db.Queries, id int64) (renamed string, err error) {
+	resource, err := txqry.GetResource(ctx, id)
+	if err != nil {
+		return
+	}
+	name := resource.Name
+	for n := 1; ; n++ {
+		_, err = txqry.Resolve(ctx, "/"+name)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+		if n == 1 {
+			name = fmt.Sprintf("%s (%d)", resource.Name, id)
+		} else {
+			name = fmt.Sprintf("%s (%d-%d)", resource.Name, id, n)
+		}
+	}
+	if name != resource.Name {
+		renamed = name
+	}
+	// renaming and moving at once, so the new name cannot collide with the
+	// siblings it is leaving behind
+	_, err = txqry.RevertResource(ctx, db.RevertResourceParams{
+		ID:       id,
+		ParentID: sql.NullInt64{},
+		Name:     name,
+		Type:     resource.Type,
+		Comments: resource.Comments,
+		Image:    resource.Image,
+	})
+	return
+}
+
+func checkNameCollisions(ctx context.Context, txqry *db.Queries) (issues []fsckReport_Issue, err error) {
+	collisions, err := txqry.GetNameCollisions(ctx)
+	if err != nil {
+		return
+	}
+	for _, c := range collisions {
+		parent := "the root"
+		if c.ParentID.Valid {
+			parent = fmt.Sprintf("resource %d", c.ParentID.Int64)
+		}
+		issues = append(issues, fsckReport_Issue{
+			Kind:        fsckNameCollision,
+			Detail:      fmt.Sprintf("%d children of %s are named %q", len(c.IDs), parent, c.Name),
+			ResourceIDs: c.IDs,
+		})
+	}
+	return
+}
+
+// fsckCommand checks the database and blob store for inconsistencies, printing
+// them as a JSON report
+func fsckCommand(ctx context.Context, c Context, args []string) (err error) {
+	flags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "Repair the issues which can be fixed without losing data.")
+	err = flags.Parse(args)
+	if err != nil {
+		return
+	}
+
+	tx, err := c.driver.BeginTx(ctx, &sql.TxOptions{
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
+	})
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	txqry := c.qry.WithTx(tx)
+	err = txqry.SetChangeContext(ctx, db.SetChangeContextParams{Actor: "fsck"})
+	if err != nil {
+		return
+	}
+
+	report := fsckReport{Issues: []fsckReport_Issue{}}
+	checks := []func() ([]fsckReport_Issue, error){
+		func() ([]fsckReport_Issue, error) { return checkBlobs(ctx, txqry, c.blobs, *repair) },
+		func() ([]fsckReport_Issue, error) { return checkAttachments(ctx, txqry, c.blobs, *repair) },
+		func() ([]fsckReport_Issue, error) { return checkSearchIndex(ctx, txqry, *repair) },
+		func() ([]fsckReport_Issue, error) { return checkParentCycles(ctx, txqry, *repair) },
+		func() ([]fsckReport_Issue, error) { return checkNameCollisions(ctx, txqry) },
+	}
+	for _, check := range checks {
+		var issues []fsckReport_Issue
+		issues, err = check()
+		if err != nil {
+			return
+		}
+		report.Issues = append(report.Issues, issues...)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	err = enc.Encode(report)
+	if err != nil {
+		return
+	}
+	if n := report.unrepaired(); n > 0 {
+		err = fmt.Errorf("fsck: %d issue(s) remain", n)
+	}
+	return
+}
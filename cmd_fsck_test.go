@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"item-archive-d/internal/db"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckParentCycles(t *testing.T) {
+	ctx := t.Context()
+	router := newTestContext(t)
+
+	create := func(name string) int64 {
+		id, err := router.qry.CreateResource(ctx, db.CreateResourceParams{Name: name, Type: "container"})
+		require.NoError(t, err)
+		return id
+	}
+	create("a")
+	b := create("b")
+	c := create("c")
+	// the names the member of the cycle would be given at the root are taken
+	create(fmt.Sprintf("a (%d)", b))
+
+	// b is renamed to the name taken at the root once it is in the cycle
+	_, err := router.driver.ExecContext(ctx, "update resource set parent_id = ?, name = 'a' where id = ?", c, b)
+	require.NoError(t, err)
+	_, err = router.driver.ExecContext(ctx, "update resource set parent_id = ? where id = ?", b, c)
+	require.NoError(t, err)
+
+	issues, err := checkParentCycles(ctx, router.qry, false)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	require.Equal(t, []int64{b, c}, issues[0].ResourceIDs)
+	require.False(t, issues[0].Repaired)
+
+	issues, err = checkParentCycles(ctx, router.qry, true)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	require.True(t, issues[0].Repaired)
+	found, err := router.qry.Resolve(ctx, fmt.Sprintf("/a (%d-2)/c", b))
+	require.NoError(t, err)
+	require.Equal(t, c, found.Int64)
+
+	issues, err = checkParentCycles(ctx, router.qry, false)
+	require.NoError(t, err)
+	require.Empty(t, issues)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"item-archive-d/internal/blob"
+	"log"
+	"os"
+	"time"
+)
+
+type gcReport_Blob struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+	Temporary bool      `json:"temporary"`
+	Deleted   bool      `json:"deleted"`
+}
+
+type gcReport struct {
+	Blobs        []gcReport_Blob `json:"blobs"`
+	TotalSize    int64           `json:"total_size"`
+	DeletedSize  int64           `json:"deleted_size"`
+	DeletedCount int             `json:"deleted_count"`
+}
+
+// collectGarbage finds blobs which are not referenced by any resource, those
+// that are older than the grace period are deleted unless dryRun is set.
+//
+// uploads store their blob while holding the write transaction that references
+// it and every transaction is started with "begin immediate", so holding a
+// transaction here ensures no upload can be between storing and referencing
+// its blob. the grace period additionally protects anything which stores
+// blobs outside of a transaction.
+func collectGarbage(ctx context.Context, c Context, grace time.Duration, dryRun bool) (report gcReport, err error) {
+	tx, err := c.driver.BeginTx(ctx, &sql.TxOptions{
+		// single read, the transaction is only held to block uploads
+		Isolation: sql.LevelReadCommitted,
+	})
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	txqry := c.qry.WithTx(tx)
+
+	images, err := txqry.ListImages(ctx)
+	if err != nil {
+		return
+	}
+	referenced := make(map[string]struct{}, len(images))
+	for _, image := range images {
+		id, err := blob.CanonicalID(image.String)
+		if err != nil {
+			log.Println("gc: invalid image reference:", image.String)
+			continue
+		}
+		referenced[id] = struct{}{}
+	}
+	attachments, err := txqry.ListAttachmentBlobs(ctx)
+	if err != nil {
+		return
+	}
+	for _, id := range attachments {
+		referenced[id] = struct{}{}
+	}
+
+	files, err := c.blobs.List()
+	if err != nil {
+		return
+	}
+	report.Blobs = []gcReport_Blob{}
+	cutoff := time.Now().Add(-grace)
+	for _, f := range files {
+		if _, ok := referenced[f.Name]; ok && !f.Temporary {
+			continue
+		}
+		entry := gcReport_Blob{
+			Name:      f.Name,
+			Size:      f.Size,
+			ModTime:   f.ModTime,
+			Temporary: f.Temporary,
+		}
+		report.TotalSize += f.Size
+		if !dryRun && f.ModTime.Before(cutoff) {
+			err = c.blobs.Remove(f)
+			if err != nil && !os.IsNotExist(err) {
+				return
+			}
+			err = nil
+			if !f.Temporary {
+				err = c.thumbs.Remove(f.Name)
+				if err != nil {
+					return
+				}
+			}
+			entry.Deleted = true
+			report.DeletedSize += f.Size
+			report.DeletedCount++
+		}
+		report.Blobs = append(report.Blobs, entry)
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// gcCommand prints a JSON report of all unreferenced blobs and deletes those
+// older than the grace period
+func gcCommand(ctx context.Context, c Context, args []string) (err error) {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	grace := flags.Duration("grace", 7*24*time.Hour, "Only delete unreferenced blobs that have not been modified for this long.")
+	dryRun := flags.Bool("dry-run", false, "Report unreferenced blobs without deleting them.")
+	err = flags.Parse(args)
+	if err != nil {
+		return
+	}
+
+	report, err := collectGarbage(ctx, c, *grace, *dryRun)
+	if err != nil {
+		return
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	err = enc.Encode(report)
+	return
+}
+
+// runGarbageCollector periodically collects garbage until the context is
+// cancelled
+func runGarbageCollector(ctx context.Context, c Context, interval, grace time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := collectGarbage(ctx, c, grace, false)
+			if err != nil {
+				log.Println("gc:", err)
+				continue
+			}
+			log.Printf(
+				"gc: deleted %d unreferenced blobs (%d bytes), %d bytes unreferenced in total",
+				report.DeletedCount, report.DeletedSize, report.TotalSize,
+			)
+		}
+	}
+}
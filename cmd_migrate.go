@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"item-archive-d/internal/db"
+	"log"
+	"path"
+)
+
+// pendingRenames describes the renames the unique names migration will make,
+// there are none if it has already been applied
+func pendingRenames(ctx context.Context, c Context) (out []string, err error) {
+	uniqueNamesVersion, err := db.MigrationVersion("unique_names")
+	if err != nil {
+		return
+	}
+	trashVersion, err := db.MigrationVersion("trash")
+	if err != nil {
+		return
+	}
+	version, err := db.Version(ctx, c.driver)
+	if err != nil || version == 0 || version >= uniqueNamesVersion {
+		return
+	}
+	renames, err := c.qry.GetNameRenames(ctx, version >= trashVersion)
+	if err != nil {
+		return
+	}
+	for _, r := range renames {
+		parent := "/"
+		if r.ParentID.Valid {
+			var segments []string
+			segments, err = c.qry.GetPath(ctx, r.ParentID.Int64)
+			if err != nil {
+				return
+			}
+			parent = trailingPath(path.Join(append([]string{"/"}, segments...)...))
+		}
+		out = append(out, fmt.Sprintf("resource %d in %s from %q to %q", r.ID, parent, r.OldName, r.NewName))
+	}
+	return
+}
+
+// migrateCommand either prints the schema version of the database and which
+// migrations are pending ("status") or applies the pending migrations ("up")
+func migrateCommand(ctx context.Context, c Context, args []string) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: migrate status|up")
+	}
+
+	switch args[0] {
+	case "status":
+		var migrations []db.Migration
+		migrations, err = db.Migrations()
+		if err != nil {
+			return
+		}
+		var version int
+		version, err = db.Version(ctx, c.driver)
+		if err != nil {
+			return
+		}
+		fmt.Printf("schema version: %d (latest: %d)\n", version, len(migrations))
+		for _, m := range migrations {
+			state := "pending"
+			if m.Version <= version {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, state)
+		}
+		var renames []string
+		renames, err = pendingRenames(ctx, c)
+		if err != nil {
+			return
+		}
+		for _, rename := range renames {
+			fmt.Println("will rename", rename)
+		}
+		return
+	case "up":
+		var applied []db.Migration
+		applied, err = migrate(ctx, c)
+		if err != nil {
+			return
+		}
+		if len(applied) == 0 {
+			log.Println("already up to date")
+		}
+		return
+	default:
+		return fmt.Errorf("unknown migrate subcommand: %s", args[0])
+	}
+}
+
+// migrate applies all pending migrations, logging them along with the renames
+// made to existing resources
+func migrate(ctx context.Context, c Context) (applied []db.Migration, err error) {
+	renames, err := pendingRenames(ctx, c)
+	if err != nil {
+		return
+	}
+	applied, err = db.Migrate(ctx, c.driver)
+	if err != nil {
+		return
+	}
+	for _, m := range applied {
+		log.Printf("applied migration %04d_%s", m.Version, m.Name)
+	}
+	for _, rename := range renames {
+		log.Println("renamed", rename)
+	}
+	return
+}
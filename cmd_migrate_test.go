@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"item-archive-d/internal/db"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateRenames(t *testing.T) {
+	ctx := t.Context()
+	driver, qry, err := db.Open(ctx, filepath.Join(t.TempDir(), "state.db"))
+	require.NoError(t, err)
+	defer driver.Close()
+	router := Context{driver: driver, qry: qry}
+
+	// databases which exist from before the migrations only have the initial
+	// schema, without the trash
+	migrations, err := db.Migrations()
+	require.NoError(t, err)
+	_, err = driver.ExecContext(ctx, migrations[0].SQL)
+	require.NoError(t, err)
+	insert := func(parentID any, name string) (id int64) {
+		err := driver.QueryRowContext(ctx,
+			"insert into resource (parent_id, name, type, comments) values (?, ?, 'item', '') returning id",
+			parentID, name,
+		).Scan(&id)
+		require.NoError(t, err)
+		return
+	}
+	box := insert(nil, "box")
+	duplicateBox := insert(nil, "box")
+	slash := insert(nil, "a/b")
+	dash := insert(nil, "a-b")
+	insert(box, "x")
+	duplicateX := insert(box, "x")
+
+	want := []string{
+		fmt.Sprintf(`resource %d in / from "box" to "box (%d)"`, duplicateBox, duplicateBox),
+		fmt.Sprintf(`resource %d in / from "a/b" to "a-b"`, slash),
+		fmt.Sprintf(`resource %d in / from "a-b" to "a-b (%d)"`, dash, dash),
+		fmt.Sprintf(`resource %d in /box/ from "x" to "x (%d)"`, duplicateX, duplicateX),
+	}
+	renames, err := pendingRenames(ctx, router)
+	require.NoError(t, err)
+	require.Equal(t, want, renames)
+
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	_, err = migrate(ctx, router)
+	require.NoError(t, err)
+	for _, rename := range want {
+		require.Contains(t, out.String(), "renamed "+rename+"\n")
+	}
+	for id, name := range map[int64]string{
+		duplicateBox: fmt.Sprintf("box (%d)", duplicateBox),
+		slash:        "a-b",
+		dash:         fmt.Sprintf("a-b (%d)", dash),
+		duplicateX:   fmt.Sprintf("x (%d)", duplicateX),
+	} {
+		var r db.Resource
+		r, err = qry.GetResource(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, name, r.Name)
+	}
+
+	// there is nothing left to rename once migrated
+	renames, err = pendingRenames(ctx, router)
+	require.NoError(t, err)
+	require.Empty(t, renames)
+}
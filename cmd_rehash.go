@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"item-archive-d/internal/blob"
+	"item-archive-d/internal/db"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// rehashCommand moves every blob still named by its legacy xxh3 id to its
+// sha256 id and rewrites all references to it.
+//
+// the new blobs are written and referenced before any of the legacy blobs are
+// removed, so an interrupted run can simply be run again.
+func rehashCommand(ctx context.Context, c Context, args []string) (err error) {
+	entries, err := os.ReadDir(c.blobs.Dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	replaced := map[string]string{}
+	for _, e := range entries {
+		if e.IsDir() || !blob.IsLegacyID(e.Name()) {
+			continue
+		}
+		var f *os.File
+		f, err = c.blobs.Open(e.Name())
+		if err != nil {
+			return
+		}
+		var id string
+		id, err = c.blobs.Store(f)
+		f.Close()
+		if err != nil {
+			return
+		}
+		replaced[e.Name()] = id
+	}
+
+	tx, err := c.driver.BeginTx(ctx, &sql.TxOptions{
+		// no reads happen in this transaction
+		Isolation: sql.LevelReadUncommitted,
+	})
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	txqry := c.qry.WithTx(tx)
+	err = txqry.SetChangeContext(ctx, db.SetChangeContextParams{Actor: "rehash"})
+	if err != nil {
+		return
+	}
+	for legacy, id := range replaced {
+		var u uint64
+		u, err = strconv.ParseUint(legacy, 10, 64)
+		if err != nil {
+			return
+		}
+		// legacy ids are stored as signed integers in the database
+		old := strconv.FormatInt(db.ToInt(u), 10)
+		// the gallery is updated first so that the new id of a primary image
+		// is not appended to the gallery as another image
+		err = txqry.ReplaceResourceImage(ctx, db.ReplaceResourceImageParams{
+			OldImage: old,
+			NewImage: id,
+		})
+		if err != nil {
+			return
+		}
+		err = txqry.ReplaceImage(ctx, db.ReplaceImageParams{
+			OldImage: sql.NullString{String: old, Valid: true},
+			NewImage: sql.NullString{String: id, Valid: true},
+		})
+		if err != nil {
+			return
+		}
+		// the history keeps referring to the image so that earlier versions
+		// can still be reverted to once the legacy blob is removed
+		err = txqry.ReplaceHistoryImage(ctx, db.ReplaceHistoryImageParams{
+			OldImage: old,
+			NewImage: id,
+		})
+		if err != nil {
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+
+	for legacy, id := range replaced {
+		err = os.Remove(filepath.Join(c.blobs.Dir, legacy))
+		if err != nil {
+			return
+		}
+		log.Println("rehashed:", legacy, "->", id)
+	}
+	log.Printf("rehashed %d blobs", len(replaced))
+	return
+}
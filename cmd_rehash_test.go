@@ -0,0 +1,60 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"item-archive-d/internal/db"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zeebo/xxh3"
+)
+
+func TestRehash(t *testing.T) {
+	ctx := t.Context()
+	router := newTestContext(t)
+
+	content := []byte("front of the box")
+	legacy := xxh3.Hash(content)
+	require.NoError(t, os.MkdirAll(router.blobs.Dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(router.blobs.Dir, strconv.FormatUint(legacy, 10)), content, 0o644))
+
+	// legacy ids are stored as signed integers
+	id, err := router.qry.CreateResource(ctx, db.CreateResourceParams{
+		Name:  "box",
+		Type:  "container",
+		Image: sql.NullString{String: strconv.FormatInt(db.ToInt(legacy), 10), Valid: true},
+	})
+	require.NoError(t, err)
+	_, err = router.driver.ExecContext(ctx, "update resource set image = null where id = ?", id)
+	require.NoError(t, err)
+
+	require.NoError(t, rehashCommand(ctx, router, nil))
+	r, err := router.qry.GetResource(ctx, id)
+	require.NoError(t, err)
+	require.False(t, r.Image.Valid)
+
+	// the version with the image can be reverted to after the legacy blob is
+	// gone
+	var created int64
+	err = router.driver.QueryRowContext(ctx, "select min(id) from history where resource_id = ?", id).Scan(&created)
+	require.NoError(t, err)
+	h, err := router.qry.GetHistory(ctx, created)
+	require.NoError(t, err)
+	_, version, err := h.Snapshots()
+	require.NoError(t, err)
+	require.NotNil(t, version.Image)
+	f, err := router.blobs.Open(*version.Image)
+	require.NoError(t, err)
+	f.Close()
+
+	w := serve(router.routes(), httptest.NewRequest("POST", fmt.Sprintf("/_revert/%d", created), nil))
+	require.Equal(t, 303, w.Code, w.Body.String())
+	r, err = router.qry.GetResource(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, *version.Image, r.Image.String)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+)
+
+// reindexCommand rebuilds the search index of resources and extracts the text
+// of every attachment again, attachments uploaded before their text was
+// extracted become searchable this way
+func reindexCommand(ctx context.Context, c Context, args []string) (err error) {
+	tx, err := c.driver.BeginTx(ctx, &sql.TxOptions{
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
+	})
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	txqry := c.qry.WithTx(tx)
+
+	err = txqry.RebuildSearchIndex(ctx)
+	if err != nil {
+		return
+	}
+	err = txqry.ClearAttachmentIndex(ctx)
+	if err != nil {
+		return
+	}
+	attachments, err := txqry.ListAllAttachments(ctx)
+	if err != nil {
+		return
+	}
+	for _, a := range attachments {
+		var f *os.File
+		f, err = c.blobs.Open(a.BlobID)
+		if os.IsNotExist(err) {
+			log.Printf("reindex: the contents of attachment %d (%s) are missing", a.ID, a.Filename)
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		err = indexAttachment(ctx, txqry, a, f)
+		f.Close()
+		if err != nil {
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	log.Printf("reindexed %d attachments", len(attachments))
+	return
+}
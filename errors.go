@@ -0,0 +1,207 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"item-archive-d/internal/db"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+type errorKind int
+
+const (
+	kindInternal errorKind = iota
+	// the requested resource (or route) does not exist
+	kindNotFound
+	// the request itself is malformed, ex. a missing form value
+	kindValidation
+	// the request is valid but conflicts with the current state of the
+	// archive, ex. a name which is already taken
+	kindConflict
+	// the route does not support the method of the request
+	kindMethodNotAllowed
+)
+
+func (k errorKind) status() int {
+	switch k {
+	case kindNotFound:
+		return http.StatusNotFound
+	case kindValidation:
+		return http.StatusBadRequest
+	case kindConflict:
+		return http.StatusConflict
+	case kindMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// httpError is an error which determines the status code it is responded with
+type httpError struct {
+	kind errorKind
+	msg  string
+	// the methods the route supports, for kindMethodNotAllowed
+	allow []string
+}
+
+func (e httpError) Error() string {
+	return e.msg
+}
+
+func notFoundf(format string, args ...any) error {
+	return httpError{kind: kindNotFound, msg: fmt.Sprintf(format, args...)}
+}
+
+func validationf(format string, args ...any) error {
+	return httpError{kind: kindValidation, msg: fmt.Sprintf(format, args...)}
+}
+
+func conflictf(format string, args ...any) error {
+	return httpError{kind: kindConflict, msg: fmt.Sprintf(format, args...)}
+}
+
+// methodNotAllowed rejects the method of the request, allow lists the methods
+// the route supports
+func methodNotAllowed(r *http.Request, allow ...string) error {
+	return httpError{
+		kind:  kindMethodNotAllowed,
+		msg:   fmt.Sprintf("unsupported method: %s", r.Method),
+		allow: allow,
+	}
+}
+
+// invalidForm describes the failure to parse the form of a request as a
+// validation error, the form was malformed by the client
+func invalidForm(err error) error {
+	if err == nil {
+		return nil
+	}
+	return validationf("invalid form: %v", err)
+}
+
+// nameConflict describes a resource being given the name of one of its
+// siblings as a conflict, other errors are returned unchanged
+func nameConflict(err error, format string, args ...any) error {
+	if db.IsNameTaken(err) {
+		return conflictf(format, args...)
+	}
+	return err
+}
+
+// errorKindOf determines the kind of any error, errors which are not an
+// httpError are internal unless they are a well known error of the database
+func errorKindOf(err error) errorKind {
+	var httpErr httpError
+	if errors.As(err, &httpErr) {
+		return httpErr.kind
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return kindNotFound
+	}
+	// other constraints are enforced by the routes before writing, so their
+	// failures are bugs rather than mistakes of the client
+	if db.IsNameTaken(err) {
+		return kindConflict
+	}
+	return kindInternal
+}
+
+// wantsJSON reports whether the error response should be JSON instead of an
+// HTML page, this is the case for the JSON API and for clients that prefer
+// JSON over HTML
+func wantsJSON(r *http.Request) bool {
+	if isApiRequest(r) {
+		return true
+	}
+	for accept := range strings.SplitSeq(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html", "*/*":
+			return false
+		}
+	}
+	return false
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+type ErrorProps struct {
+	Status     int
+	StatusText string
+	Message    string
+}
+
+const error_template = `<!DOCTYPE html>
+<html>
+<head>
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Item Archive: {{.StatusText}}</title>
+	<style>
+	h1, h2, h3, h4, h5, h6 {
+		margin: 0.75rem 0rem;
+	}
+	* {
+		box-sizing: border-box;
+	}
+	body {
+		margin: 0;
+		padding: 0.5rem;
+	}
+	</style>
+</head>
+
+<body>
+	<a href="/">&lt;&lt; Home</a>
+
+	<hr>
+
+	<h4>{{.Status}} {{.StatusText}}</h4>
+	<p>{{.Message}}</p>
+</body>
+</html>`
+
+var errorTemplate = template.Must(template.New("error").Parse(error_template))
+
+// writeError responds with the status code of the error's kind, as an HTML
+// page or as JSON depending on the client
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Println(err)
+	status := errorKindOf(err).status()
+	var httpErr httpError
+	if errors.As(err, &httpErr) && httpErr.allow != nil {
+		w.Header().Set("Allow", strings.Join(httpErr.allow, ", "))
+	}
+	message := err.Error()
+	if db.IsNameTaken(err) {
+		// routes describe the conflict with nameConflict where they can,
+		// this only hides the message of the database
+		message = "a resource with the same name already exists in this location"
+	}
+	if wantsJSON(r) {
+		writeJSON(w, status, errorBody{Error: message})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err = errorTemplate.Execute(w, ErrorProps{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Message:    message,
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
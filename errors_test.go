@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"item-archive-d/internal/db"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorKindOf(t *testing.T) {
+	ctx := t.Context()
+	router := newTestContext(t)
+
+	require.Equal(t, kindValidation, errorKindOf(validationf("invalid")))
+	require.Equal(t, kindNotFound, errorKindOf(fmt.Errorf("get: %w", sql.ErrNoRows)))
+
+	_, err := router.qry.CreateResource(ctx, db.CreateResourceParams{Name: "a", Type: "item"})
+	require.NoError(t, err)
+	_, err = router.qry.CreateResource(ctx, db.CreateResourceParams{Name: "a", Type: "item"})
+	require.Error(t, err)
+	require.Equal(t, kindConflict, errorKindOf(err))
+
+	// only the names of siblings are conflicts, other constraints are
+	// checked by the routes before writing
+	_, err = router.qry.CreateResource(ctx, db.CreateResourceParams{
+		ParentID: sql.NullInt64{Int64: 999, Valid: true},
+		Name:     "b",
+		Type:     "item",
+	})
+	require.ErrorContains(t, err, "FOREIGN KEY constraint failed")
+	require.Equal(t, kindInternal, errorKindOf(err))
+}
+
+func TestWriteError(t *testing.T) {
+	router := newTestContext(t)
+	h := router.routes()
+	_, err := router.qry.CreateResource(t.Context(), db.CreateResourceParams{Name: "a", Type: "item"})
+	require.NoError(t, err)
+	_, err = router.qry.CreateResource(t.Context(), db.CreateResourceParams{Name: "b", Type: "item"})
+	require.NoError(t, err)
+
+	t.Run("html", func(t *testing.T) {
+		w := serve(h, httptest.NewRequest("GET", "/missing", nil))
+		require.Equal(t, 404, w.Code)
+		require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		require.Contains(t, w.Body.String(), "404 Not Found")
+
+		r := httptest.NewRequest("GET", "/missing", nil)
+		r.Header.Set("Accept", "text/html,application/json;q=0.9")
+		w = serve(h, r)
+		require.Equal(t, 404, w.Code)
+		require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/missing", nil)
+		r.Header.Set("Accept", "application/json")
+		w := serve(h, r)
+		require.Equal(t, 404, w.Code)
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var body errorBody
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		require.NotEmpty(t, body.Error)
+
+		// the JSON API answers with JSON whatever the client accepts
+		r = httptest.NewRequest("GET", "/api/v1/resources/999", nil)
+		r.Header.Set("Accept", "text/html")
+		w = serve(h, r)
+		require.Equal(t, 404, w.Code)
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	})
+
+	t.Run("status", func(t *testing.T) {
+		// the body ends before the closing boundary
+		malformed := func(target string) *http.Request {
+			r := httptest.NewRequest("POST", target, strings.NewReader("--x\r\nContent-Disposition: form-data; name=\"name\"\r\n\r\nc"))
+			r.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+			return r
+		}
+		for _, tc := range []struct {
+			name   string
+			r      *http.Request
+			status int
+		}{
+			{"unsupported method", httptest.NewRequest("GET", "/_update/a", nil), 405},
+			{"malformed form", malformed("/_update/a"), 400},
+			{"malformed form of a list", malformed("/"), 400},
+			{"form which is not multipart", httptest.NewRequest("POST", "/_update/a", strings.NewReader("name=c")), 400},
+			{"invalid name", formRequest(t, "/_update/a", map[string]string{"name": "x/y", "type": "item"}), 400},
+			{"invalid type", formRequest(t, "/_update/a", map[string]string{"name": "a", "type": "thing"}), 400},
+			{"unknown resource", formRequest(t, "/_update/missing", map[string]string{"name": "c", "type": "item"}), 404},
+			{"name taken", formRequest(t, "/_update/a", map[string]string{"name": "b", "type": "item"}), 409},
+			{"unknown operation", formRequest(t, "/_undo/999", nil), 404},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				tc.r.Header.Set("Accept", "application/json")
+				w := serve(h, tc.r)
+				require.Equal(t, tc.status, w.Code, w.Body.String())
+				var body errorBody
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				require.NotEmpty(t, body.Error)
+			})
+		}
+
+		for _, r := range []*http.Request{malformed("/_update/a"), malformed("/")} {
+			r.Header.Set("Accept", "application/json")
+			var body errorBody
+			require.NoError(t, json.Unmarshal(serve(h, r).Body.Bytes(), &body))
+			require.Contains(t, body.Error, "invalid form: ")
+		}
+
+		// the methods the route supports are listed
+		w := serve(h, httptest.NewRequest("DELETE", "/_update/a", nil))
+		require.Equal(t, 405, w.Code)
+		require.Equal(t, "POST", w.Header().Get("Allow"))
+		w = serve(h, httptest.NewRequest("PUT", "/_aliases", nil))
+		require.Equal(t, 405, w.Code)
+		require.Equal(t, "GET, POST", w.Header().Get("Allow"))
+
+		// the conflict names the location instead of the database constraint
+		r := formRequest(t, "/_update/a", map[string]string{"name": "b", "type": "item"})
+		r.Header.Set("Accept", "application/json")
+		var body errorBody
+		require.NoError(t, json.Unmarshal(serve(h, r).Body.Bytes(), &body))
+		require.Equal(t, `a resource named "b" already exists in /`, body.Error)
+	})
+}
@@ -16,6 +16,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -129,11 +130,12 @@ func (c tagContext) infer(content []*genai.Content) (res *genai.GenerateContentR
 
 func (c tagContext) tag(r db.Resource) (err error) {
 	if !r.Image.Valid {
-		return
+		// there is nothing to name a queued resource after
+		return c.qry.DequeueTag(c.ctx, r.ID)
 	}
 	fmt.Println("tagging:", r.ID)
 
-	f, err := c.blobs.Open(db.ToUint(r.Image.Int64))
+	f, err := c.blobs.Open(r.Image.String)
 	if err != nil {
 		return
 	}
@@ -153,30 +155,100 @@ func (c tagContext) tag(r db.Resource) (err error) {
 	if err != nil {
 		return
 	}
-	name := res.Text()
+	// titles are used as path segments
+	name := strings.ReplaceAll(strings.TrimSpace(res.Text()), "/", "-")
+	if !db.ValidName(name) {
+		err = fmt.Errorf("invalid title for resource %d: %q", r.ID, res.Text())
+		return
+	}
 
 	fmt.Println("tagged:", name, r.ID)
 
-	updates, err := c.qry.UpdateResource(c.ctx, db.UpdateResourceParams{
-		ID:       r.ID,
-		Name:     name,
-		Type:     r.Type,
-		Comments: r.Comments,
+	tx, err := c.driver.BeginTx(c.ctx, &sql.TxOptions{
+		// no reads happen in this transaction
+		Isolation: sql.LevelReadUncommitted,
 	})
 	if err != nil {
 		return
 	}
+	defer tx.Rollback()
+	txqry := c.qry.WithTx(tx)
+	// the actor is set in the same transaction so that it cannot be replaced
+	// by another writer before the update
+	err = txqry.SetChangeContext(c.ctx, db.SetChangeContextParams{Actor: "ai-tagger"})
+	if err != nil {
+		return
+	}
+	// siblings may already have been given the same title, later ones are
+	// numbered like "Untitled N"
+	var updates []int64
+	for i := 1; ; i++ {
+		candidate := name
+		if i > 1 {
+			candidate = fmt.Sprintf("%s %d", name, i)
+		}
+		updates, err = txqry.UpdateResource(c.ctx, db.UpdateResourceParams{
+			ID:       r.ID,
+			Name:     candidate,
+			Type:     r.Type,
+			Comments: r.Comments,
+		})
+		if !db.IsNameTaken(err) {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
 	if len(updates) != 1 {
 		err = fmt.Errorf("failed to update resource: %d", r.ID)
+		return
+	}
+	err = txqry.DequeueTag(c.ctx, r.ID)
+	if err != nil {
+		return
 	}
+	err = tx.Commit()
 	return
 }
 
+// tagAll names the queued resources followed by all other untitled resources
 func (c tagContext) tagAll() (err error) {
-	resources, err := c.qry.Search(c.ctx, "Untitled*")
+	resources, err := c.qry.ListTagQueue(c.ctx)
+	if err != nil {
+		return
+	}
+	queued := map[int64]struct{}{}
+	for _, r := range resources {
+		queued[r.ID] = struct{}{}
+	}
+	untitled, _, err := c.qry.Search(c.ctx, db.SearchQuery{Terms: []string{"Untitled"}}, -1, 0)
 	if err != nil {
 		return
 	}
+	for _, r := range untitled {
+		// only the name of a resource says whether it is untitled, not the
+		// text of its attachments
+		if r.AttachmentID.Valid {
+			continue
+		}
+		if _, ok := queued[r.ID]; !ok {
+			resources = append(resources, r.Resource)
+		}
+	}
+	return c.tagResources(resources)
+}
+
+// tagQueue names the queued resources
+func (c tagContext) tagQueue() (err error) {
+	resources, err := c.qry.ListTagQueue(c.ctx)
+	if err != nil {
+		return
+	}
+	return c.tagResources(resources)
+}
+
+func (c tagContext) tagResources(resources []db.Resource) (err error) {
 	jobs := make(chan db.Resource)
 	var errs []error
 	var errMutex sync.Mutex
@@ -220,16 +292,21 @@ func (c tagContext) tagAll() (err error) {
 
 func main() {
 	dataPath := flag.String("data", ".", "The directory in which to store item-archive data.")
+	watch := flag.Duration("watch", 0, "How often to check the tag queue for new resources after tagging, 0 exits after tagging once.")
 	flag.Parse()
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
-	driver, qry, err := db.Open(ctx, filepath.Join(*dataPath, "state.db"), "")
+	driver, qry, err := db.Open(ctx, filepath.Join(*dataPath, "state.db"))
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer driver.Close()
+	_, err = db.Migrate(ctx, driver)
+	if err != nil {
+		log.Fatal(err)
+	}
 	client, err := genai.NewClient(ctx, nil)
 	if err != nil {
 		log.Fatal(err)
@@ -246,6 +323,22 @@ func main() {
 	err = tagctx.tagAll()
 	if err != nil {
 		log.Println(err)
+	}
+	if *watch == 0 {
 		return
 	}
+
+	ticker := time.NewTicker(*watch)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err = tagctx.tagQueue()
+		if err != nil {
+			log.Println(err)
+		}
+	}
 }
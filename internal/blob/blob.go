@@ -1,46 +1,209 @@
 package blob
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/zeebo/xxh3"
 )
 
+// IDs of blobs are "sha256-" followed by the hex encoded SHA-256 digest of
+// their contents.
+//
+// Blobs stored before the switch to SHA-256 are identified by their 64-bit
+// xxh3 digest in decimal, these are referred to as legacy IDs. Legacy IDs may
+// be given either as unsigned or as signed integers (the form they are stored
+// in the database).
+const sha256Prefix = "sha256-"
+
+// the prefix ensures temporary files can never be mistaken for blobs
+const tmpPrefix = ".tmp-"
+
+var ErrInvalidID = errors.New("invalid blob id")
+
+// IsLegacyID reports whether the given ID was produced by the old xxh3 based
+// store
+func IsLegacyID(id string) bool {
+	_, err := strconv.ParseUint(id, 10, 64)
+	if err == nil {
+		return true
+	}
+	_, err = strconv.ParseInt(id, 10, 64)
+	return err == nil
+}
+
+// CanonicalID validates the given id and returns it in the form used to name
+// the file it is stored in
+func CanonicalID(id string) (string, error) {
+	if hash, ok := strings.CutPrefix(id, sha256Prefix); ok {
+		if len(hash) != sha256.Size*2 || strings.ToLower(hash) != hash {
+			return "", ErrInvalidID
+		}
+		_, err := hex.DecodeString(hash)
+		if err != nil {
+			return "", ErrInvalidID
+		}
+		return id, nil
+	}
+	u, err := strconv.ParseUint(id, 10, 64)
+	if err == nil {
+		return strconv.FormatUint(u, 10), nil
+	}
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err == nil {
+		return strconv.FormatUint(uint64(i), 10), nil
+	}
+	return "", ErrInvalidID
+}
+
 type Store struct {
 	Dir string
 }
 
-func (s Store) Open(id uint64) (*os.File, error) {
-	return os.Open(filepath.Join(s.Dir, strconv.FormatUint(id, 10)))
+// Info describes a file in the store
+type Info struct {
+	// Name is the name of the file, for blobs this is their canonical id
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// Temporary files belong to uploads which are in progress or have been
+	// interrupted
+	Temporary bool
+}
+
+// List returns every blob and temporary file in the store
+func (s Store) List() (out []Info, err error) {
+	entries, err := os.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		var info os.FileInfo
+		info, err = e.Info()
+		if os.IsNotExist(err) {
+			// removed since listing the directory
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		temporary := strings.HasPrefix(e.Name(), tmpPrefix)
+		if !temporary {
+			if _, err := CanonicalID(e.Name()); err != nil {
+				continue
+			}
+		}
+		out = append(out, Info{
+			Name:      e.Name(),
+			Size:      info.Size(),
+			ModTime:   info.ModTime(),
+			Temporary: temporary,
+		})
+	}
+	return
+}
+
+// Remove deletes a file returned by List
+func (s Store) Remove(info Info) error {
+	return os.Remove(filepath.Join(s.Dir, info.Name))
 }
 
-func (s Store) Store(blob io.Reader) (id uint64, err error) {
+func (s Store) Open(id string) (*os.File, error) {
+	name, err := CanonicalID(id)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(s.Dir, name))
+}
+
+// Verify reports whether the contents of a blob still hash to its id
+func (s Store) Verify(id string) (ok bool, err error) {
+	name, err := CanonicalID(id)
+	if err != nil {
+		return
+	}
+	f, err := os.Open(filepath.Join(s.Dir, name))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if hash, isSha256 := strings.CutPrefix(name, sha256Prefix); isSha256 {
+		hasher := sha256.New()
+		_, err = io.Copy(hasher, f)
+		if err != nil {
+			return
+		}
+		ok = hex.EncodeToString(hasher.Sum(nil)) == hash
+		return
+	}
+
+	hasher := xxh3.New()
+	_, err = io.Copy(hasher, f)
+	if err != nil {
+		return
+	}
+	ok = strconv.FormatUint(hasher.Sum64(), 10) == name
+	return
+}
+
+func (s Store) Store(blob io.Reader) (id string, err error) {
 	err = os.MkdirAll(s.Dir, 0777)
 	if err != nil {
 		return
 	}
-	tmpFilename := filepath.Join(s.Dir, strconv.FormatUint(rand.Uint64(), 10))
+	tmpFilename := filepath.Join(s.Dir, tmpPrefix+strconv.FormatUint(rand.Uint64(), 10))
 	f, err := os.Create(tmpFilename)
 	if err != nil {
 		return
 	}
 	defer f.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(tmpFilename)
+		}
+	}()
 
-	hasher := xxh3.New()
+	hasher := sha256.New()
 	tee := io.TeeReader(blob, hasher)
 
 	_, err = io.Copy(f, tee)
 	if err != nil {
 		return
 	}
-	id = hasher.Sum64()
+	id = sha256Prefix + hex.EncodeToString(hasher.Sum(nil))
 	f.Close()
 
-	filename := filepath.Join(s.Dir, strconv.FormatUint(id, 10))
+	filename := filepath.Join(s.Dir, id)
+	// blobs with the same id have the same contents, so an existing blob can
+	// be kept as is. it is touched so that it is not garbage collected before
+	// the caller gets to reference it.
+	_, err = os.Stat(filename)
+	if err == nil {
+		now := time.Now()
+		err = os.Chtimes(filename, now, now)
+		if err != nil {
+			return
+		}
+		err = os.Remove(tmpFilename)
+		return
+	}
 	err = os.Rename(tmpFilename, filename)
 	if err != nil {
 		return
@@ -4,17 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"strings"
-
-	_ "embed"
 
 	_ "modernc.org/sqlite"
 )
 
-//go:embed schema.sql
-var schema string
-
-func Open(ctx context.Context, path, migrations string) (driver *sql.DB, qry *Queries, err error) {
+// Open connects to the database at the given path, the schema is not touched
+// until Migrate is called
+func Open(ctx context.Context, path string) (driver *sql.DB, qry *Queries, err error) {
 	driver, err = sql.Open("sqlite", fmt.Sprintf(
 		"file:%s?"+
 			"_pragma=foreign_keys(1)&"+
@@ -31,33 +27,5 @@ func Open(ctx context.Context, path, migrations string) (driver *sql.DB, qry *Qu
 		return
 	}
 	qry = New(driver)
-
-	if migrations != "" {
-		_, err = driver.ExecContext(ctx, migrations)
-		return
-	}
-
-	tx, err := driver.BeginTx(ctx, &sql.TxOptions{
-		// no reads occur in the only statement executed
-		Isolation: sql.LevelReadUncommitted,
-	})
-	if err != nil {
-		return
-	}
-	defer tx.Rollback()
-
-	_, err = tx.ExecContext(ctx, schema)
-	if err == nil {
-		err = tx.Commit()
-		return
-	}
-
-	// if already setup
-	if strings.Contains(err.Error(), "already exists") {
-		err = nil
-		return
-	}
-
-	// if some other error
 	return
 }
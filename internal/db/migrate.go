@@ -0,0 +1,214 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// migrations are named "<version>_<name>.sql", versions start at 1 and must
+// not have gaps. a migration must never be changed once it has been released,
+// schema changes are made by adding a new migration instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a single step of the schema, the schema version of a database
+// is stored in "PRAGMA user_version" and is the version of the last migration
+// applied to it
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations returns all embedded migrations ordered by version
+func Migrations() (out []Migration, err error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		version, label, ok := strings.Cut(name, "_")
+		if !ok {
+			err = fmt.Errorf("invalid migration name: %s", e.Name())
+			return
+		}
+		var m Migration
+		m.Version, err = strconv.Atoi(version)
+		if err != nil {
+			err = fmt.Errorf("invalid migration name: %s", e.Name())
+			return
+		}
+		m.Name = label
+		var contents []byte
+		contents, err = migrationFiles.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return
+		}
+		m.SQL = string(contents)
+		out = append(out, m)
+	}
+	slices.SortFunc(out, func(a, b Migration) int {
+		return a.Version - b.Version
+	})
+	for i, m := range out {
+		if m.Version != i+1 {
+			err = fmt.Errorf("missing migration version %d", i+1)
+			return
+		}
+	}
+	return
+}
+
+// MigrationVersion returns the version of the migration with the given name,
+// for code which depends on the schema of a version before the latest
+func MigrationVersion(name string) (version int, err error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return
+	}
+	for _, m := range migrations {
+		if m.Name == name {
+			return m.Version, nil
+		}
+	}
+	err = fmt.Errorf("unknown migration: %s", name)
+	return
+}
+
+// Version returns the schema version of the database.
+//
+// databases created before migrations were versioned have a user_version of
+// 0 even though their schema is that of the first migration, these are
+// reported as version 1.
+func Version(ctx context.Context, driver DBTX) (version int, err error) {
+	err = driver.QueryRowContext(ctx, "pragma user_version").Scan(&version)
+	if err != nil || version != 0 {
+		return
+	}
+	var exists bool
+	err = driver.QueryRowContext(
+		ctx,
+		"select exists (select 1 from sqlite_master where type = 'table' and name = 'resource')",
+	).Scan(&exists)
+	if err != nil {
+		return
+	}
+	if exists {
+		version = 1
+	}
+	return
+}
+
+// Migrate applies all migrations newer than the version of the database, each
+// migration is applied in its own transaction together with the version bump
+// so a failing migration leaves the database at the previous version
+func Migrate(ctx context.Context, driver *sql.DB) (applied []Migration, err error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return
+	}
+	for {
+		var done bool
+		var m Migration
+		done, m, err = migrateNext(ctx, driver, migrations)
+		if err != nil || done {
+			return
+		}
+		applied = append(applied, m)
+	}
+}
+
+func migrateNext(ctx context.Context, driver *sql.DB, migrations []Migration) (done bool, m Migration, err error) {
+	// foreign keys are disabled while migrating so that migrations can rebuild
+	// a table without cascading the drop of the old table to its children.
+	// the pragma has no effect inside a transaction and is per connection, so
+	// the migration runs on a connection of its own.
+	conn, err := driver.Conn(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, "pragma foreign_keys = off")
+	if err != nil {
+		return
+	}
+	defer func() {
+		_, enableErr := conn.ExecContext(ctx, "pragma foreign_keys = on")
+		if err == nil {
+			err = enableErr
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{
+		// the version must not change between reading it and migrating
+		Isolation: sql.LevelSerializable,
+	})
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	version, err := Version(ctx, tx)
+	if err != nil {
+		return
+	}
+	if version > len(migrations) {
+		err = fmt.Errorf(
+			"database schema version %d is newer than the latest known version %d",
+			version, len(migrations),
+		)
+		return
+	}
+	if version == len(migrations) {
+		done = true
+		return
+	}
+
+	m = migrations[version]
+	_, err = tx.ExecContext(ctx, m.SQL)
+	if err != nil {
+		err = fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		return
+	}
+	err = checkForeignKeys(ctx, tx)
+	if err != nil {
+		err = fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		return
+	}
+	// pragmas cannot take parameters, the version is always an integer
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("pragma user_version = %d", m.Version))
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// checkForeignKeys fails if any row references a row that does not exist,
+// which is not prevented while foreign keys are disabled
+func checkForeignKeys(ctx context.Context, tx *sql.Tx) (err error) {
+	rows, err := tx.QueryContext(ctx, "pragma foreign_key_check")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	if rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int64
+		err = rows.Scan(&table, &rowid, &parent, &fkid)
+		if err != nil {
+			return
+		}
+		return fmt.Errorf("row %d of %s references a missing row of %s", rowid.Int64, table, parent)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,304 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	migrations, err := Migrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	t.Run("version by name", func(t *testing.T) {
+		version, err := MigrationVersion("trash")
+		require.NoError(t, err)
+		require.Equal(t, 3, version)
+		_, err = MigrationVersion("unknown")
+		require.Error(t, err)
+	})
+
+	t.Run("fresh", func(t *testing.T) {
+		driver, _, err := Open(t.Context(), filepath.Join(t.TempDir(), "state.db"))
+		require.NoError(t, err)
+		defer driver.Close()
+
+		version, err := Version(t.Context(), driver)
+		require.NoError(t, err)
+		require.Equal(t, 0, version)
+
+		applied, err := Migrate(t.Context(), driver)
+		require.NoError(t, err)
+		require.Equal(t, migrations, applied)
+
+		version, err = Version(t.Context(), driver)
+		require.NoError(t, err)
+		require.Equal(t, len(migrations), version)
+
+		// migrating an up to date database does nothing
+		applied, err = Migrate(t.Context(), driver)
+		require.NoError(t, err)
+		require.Empty(t, applied)
+	})
+
+	t.Run("unversioned", func(t *testing.T) {
+		driver, _, err := Open(t.Context(), filepath.Join(t.TempDir(), "state.db"))
+		require.NoError(t, err)
+		defer driver.Close()
+
+		// databases created before migrations were versioned only have the
+		// initial schema and a user_version of 0
+		_, err = driver.ExecContext(t.Context(), migrations[0].SQL)
+		require.NoError(t, err)
+
+		version, err := Version(t.Context(), driver)
+		require.NoError(t, err)
+		require.Equal(t, 1, version)
+
+		applied, err := Migrate(t.Context(), driver)
+		require.NoError(t, err)
+		require.Len(t, applied, len(migrations)-1)
+	})
+
+	t.Run("newer", func(t *testing.T) {
+		driver, _, err := Open(t.Context(), filepath.Join(t.TempDir(), "state.db"))
+		require.NoError(t, err)
+		defer driver.Close()
+
+		_, err = driver.ExecContext(t.Context(), "pragma user_version = 1000000")
+		require.NoError(t, err)
+		_, err = Migrate(t.Context(), driver)
+		require.Error(t, err)
+	})
+
+	t.Run("unique names", func(t *testing.T) {
+		driver, qry, err := Open(t.Context(), filepath.Join(t.TempDir(), "state.db"))
+		require.NoError(t, err)
+		defer driver.Close()
+
+		// the schema just before names were made unique
+		for _, m := range migrations[:5] {
+			_, err = driver.ExecContext(t.Context(), m.SQL)
+			require.NoError(t, err)
+		}
+		_, err = driver.ExecContext(t.Context(), "pragma user_version = 5")
+		require.NoError(t, err)
+
+		var ids []int64
+		for _, name := range []string{"a", "a", "a/b", "a-b", ".."} {
+			var id int64
+			id, err = qry.CreateResource(t.Context(), CreateResourceParams{Name: name, Type: "item"})
+			require.NoError(t, err)
+			ids = append(ids, id)
+		}
+
+		renames, err := qry.GetNameRenames(t.Context(), true)
+		require.NoError(t, err)
+		require.Equal(t, []GetNameRenamesRow{
+			{ID: ids[1], OldName: "a", NewName: fmt.Sprintf("a (%d)", ids[1])},
+			{ID: ids[2], OldName: "a/b", NewName: "a-b"},
+			{ID: ids[3], OldName: "a-b", NewName: fmt.Sprintf("a-b (%d)", ids[3])},
+			{ID: ids[4], OldName: "..", NewName: fmt.Sprintf("Untitled (%d)", ids[4])},
+		}, renames)
+
+		_, err = Migrate(t.Context(), driver)
+		require.NoError(t, err)
+		for _, rename := range renames {
+			var r Resource
+			r, err = qry.GetResource(t.Context(), rename.ID)
+			require.NoError(t, err)
+			require.Equal(t, rename.NewName, r.Name)
+		}
+		_, err = qry.CreateResource(t.Context(), CreateResourceParams{Name: "a", Type: "item"})
+		require.True(t, IsNameTaken(err), "unexpected error: %v", err)
+	})
+	t.Run("unique names taken", func(t *testing.T) {
+		driver, qry, err := Open(t.Context(), filepath.Join(t.TempDir(), "state.db"))
+		require.NoError(t, err)
+		defer driver.Close()
+
+		for _, m := range migrations[:5] {
+			_, err = driver.ExecContext(t.Context(), m.SQL)
+			require.NoError(t, err)
+		}
+		_, err = driver.ExecContext(t.Context(), "pragma user_version = 5")
+		require.NoError(t, err)
+
+		// the names the duplicate would be given already exist
+		first, err := qry.CreateResource(t.Context(), CreateResourceParams{Name: "a", Type: "item"})
+		require.NoError(t, err)
+		duplicate := first + 1
+		for _, name := range []string{
+			"a",
+			fmt.Sprintf("a (%d)", duplicate),
+			fmt.Sprintf("a (%d-2)", duplicate),
+		} {
+			_, err = qry.CreateResource(t.Context(), CreateResourceParams{Name: name, Type: "item"})
+			require.NoError(t, err)
+		}
+
+		renames, err := qry.GetNameRenames(t.Context(), true)
+		require.NoError(t, err)
+		require.Equal(t, []GetNameRenamesRow{
+			{ID: duplicate, OldName: "a", NewName: fmt.Sprintf("a (%d-3)", duplicate)},
+		}, renames)
+
+		_, err = Migrate(t.Context(), driver)
+		require.NoError(t, err)
+		r, err := qry.GetResource(t.Context(), duplicate)
+		require.NoError(t, err)
+		require.Equal(t, renames[0].NewName, r.Name)
+	})
+	t.Run("resource images", func(t *testing.T) {
+		driver, qry, err := Open(t.Context(), filepath.Join(t.TempDir(), "state.db"))
+		require.NoError(t, err)
+		defer driver.Close()
+
+		// the schema just before resources could have multiple images
+		for _, m := range migrations[:9] {
+			_, err = driver.ExecContext(t.Context(), m.SQL)
+			require.NoError(t, err)
+		}
+		_, err = driver.ExecContext(t.Context(), "pragma user_version = 9")
+		require.NoError(t, err)
+
+		withImage, err := qry.CreateResource(t.Context(), CreateResourceParams{
+			Name:  "a",
+			Type:  "item",
+			Image: sql.NullString{String: "front", Valid: true},
+		})
+		require.NoError(t, err)
+		withoutImage, err := qry.CreateResource(t.Context(), CreateResourceParams{Name: "b", Type: "item"})
+		require.NoError(t, err)
+
+		_, err = Migrate(t.Context(), driver)
+		require.NoError(t, err)
+		images, err := qry.ListResourceImages(t.Context(), withImage)
+		require.NoError(t, err)
+		require.Len(t, images, 1)
+		require.Equal(t, "front", images[0].Image)
+		require.True(t, images[0].IsPrimary)
+		images, err = qry.ListResourceImages(t.Context(), withoutImage)
+		require.NoError(t, err)
+		require.Empty(t, images)
+	})
+
+	t.Run("image text", func(t *testing.T) {
+		driver, qry, err := Open(t.Context(), filepath.Join(t.TempDir(), "state.db"))
+		require.NoError(t, err)
+		defer driver.Close()
+
+		// the schema just before resource.image was declared as text
+		for _, m := range migrations[:13] {
+			_, err = driver.ExecContext(t.Context(), m.SQL)
+			require.NoError(t, err)
+		}
+		_, err = driver.ExecContext(t.Context(), "pragma user_version = 13")
+		require.NoError(t, err)
+
+		// the integer affinity turns a hash made only of digits into a number
+		parent, err := qry.CreateResource(t.Context(), CreateResourceParams{
+			Name:  "a",
+			Type:  "container",
+			Image: sql.NullString{String: "0123", Valid: true},
+		})
+		require.NoError(t, err)
+		child, err := qry.CreateResource(t.Context(), CreateResourceParams{
+			ParentID: sql.NullInt64{Int64: parent, Valid: true},
+			Name:     "b",
+			Type:     "item",
+		})
+		require.NoError(t, err)
+		err = qry.CreatePathAlias(t.Context(), CreatePathAliasParams{Path: "/old", ResourceID: child})
+		require.NoError(t, err)
+		deleted, err := qry.CreateResource(t.Context(), CreateResourceParams{Name: "c", Type: "item"})
+		require.NoError(t, err)
+		err = qry.DeleteResource(t.Context(), deleted)
+		require.NoError(t, err)
+
+		_, err = Migrate(t.Context(), driver)
+		require.NoError(t, err)
+
+		var typ string
+		err = driver.QueryRowContext(t.Context(), "select typeof(image) from resource where id = ?", parent).Scan(&typ)
+		require.NoError(t, err)
+		require.Equal(t, "text", typ)
+		r, err := qry.GetResource(t.Context(), parent)
+		require.NoError(t, err)
+		require.Equal(t, "123", r.Image.String)
+
+		// the rows referencing resources are kept
+		r, err = qry.GetResource(t.Context(), child)
+		require.NoError(t, err)
+		require.Equal(t, parent, r.ParentID.Int64)
+		images, err := qry.ListResourceImages(t.Context(), parent)
+		require.NoError(t, err)
+		require.Len(t, images, 1)
+		alias, err := qry.GetPathAlias(t.Context(), "/old")
+		require.NoError(t, err)
+		require.Equal(t, child, alias.ResourceID)
+
+		// ids are not reused and the triggers work on the new table
+		id, err := qry.CreateResource(t.Context(), CreateResourceParams{
+			Name:  "d",
+			Type:  "item",
+			Image: sql.NullString{String: "0456", Valid: true},
+		})
+		require.NoError(t, err)
+		require.Greater(t, id, deleted)
+		r, err = qry.GetResource(t.Context(), id)
+		require.NoError(t, err)
+		require.Equal(t, "0456", r.Image.String)
+		images, err = qry.ListResourceImages(t.Context(), id)
+		require.NoError(t, err)
+		require.Len(t, images, 1)
+		history, err := qry.ListHistory(t.Context(), id)
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		_, err = qry.CreateResource(t.Context(), CreateResourceParams{Name: "d", Type: "item"})
+		require.True(t, IsNameTaken(err), "unexpected error: %v", err)
+	})
+
+	t.Run("untrashed words", func(t *testing.T) {
+		driver, qry, err := Open(t.Context(), filepath.Join(t.TempDir(), "state.db"))
+		require.NoError(t, err)
+		defer driver.Close()
+
+		// the schema just before trashed resources were left out of the
+		// vocabulary
+		for _, m := range migrations[:14] {
+			_, err = driver.ExecContext(t.Context(), m.SQL)
+			require.NoError(t, err)
+		}
+		_, err = driver.ExecContext(t.Context(), "pragma user_version = 14")
+		require.NoError(t, err)
+
+		kept, err := qry.CreateResource(t.Context(), CreateResourceParams{Name: "hammer", Type: "item"})
+		require.NoError(t, err)
+		trashed, err := qry.CreateResource(t.Context(), CreateResourceParams{Name: "spanner", Type: "item"})
+		require.NoError(t, err)
+		trashID, err := qry.CreateTrash(t.Context(), CreateTrashParams{ResourceID: trashed, OriginalPath: "/spanner"})
+		require.NoError(t, err)
+		_, err = qry.TrashResource(t.Context(), TrashResourceParams{ID: trashed, TrashID: trashID})
+		require.NoError(t, err)
+
+		_, err = Migrate(t.Context(), driver)
+		require.NoError(t, err)
+
+		var words []int64
+		rows, err := driver.QueryContext(t.Context(), "select rowid from resource_words where resource_words match 'hammer OR spanner'")
+		require.NoError(t, err)
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			require.NoError(t, rows.Scan(&id))
+			words = append(words, id)
+		}
+		require.NoError(t, rows.Err())
+		require.Equal(t, []int64{kept}, words)
+	})
+}
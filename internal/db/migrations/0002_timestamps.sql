@@ -0,0 +1,4 @@
+-- timestamps are unix seconds, resources which existed before this migration
+-- have unknown timestamps which are left as 0
+alter table resource add column created_at integer not null default 0;
+alter table resource add column updated_at integer not null default 0;
@@ -0,0 +1,17 @@
+-- deleted subtrees are moved into the trash instead of being deleted, the root
+-- of a trashed subtree is detached from its parent and every resource in it
+-- references the trash entry. deleting the entry deletes the subtree.
+create table trash (
+	id integer primary key autoincrement,
+	resource_id integer not null,
+
+	-- where the subtree was deleted from, the parent may no longer exist
+	original_parent_id integer,
+	original_path text not null,
+	deleted_at integer not null
+);
+
+alter table resource add column trash_id integer
+	references trash(id) on delete cascade;
+
+create index resource_trash_id on resource(trash_id);
@@ -0,0 +1,94 @@
+-- change_context holds a single row describing who is making the changes of
+-- the current transaction, writers set it with SetActor before changing
+-- resources. every transaction holds the write lock ("begin immediate") so
+-- it cannot be overwritten by another writer in the meantime.
+create table change_context (
+	id integer primary key check (id = 1),
+	actor text not null
+);
+
+-- history is append-only, resource_id has no foreign key so that the history
+-- of a resource outlives it. old and new are JSON snapshots of the row before
+-- and after the change, old is null when the resource was created and new is
+-- null when it was deleted.
+create table history (
+	id integer primary key autoincrement,
+	resource_id integer not null,
+	changed_at integer not null,
+	actor text,
+	old text,
+	new text
+);
+
+create index history_resource_id on history(resource_id);
+
+create trigger history_ai after insert on resource begin
+	insert into history (resource_id, changed_at, actor, old, new)
+	values (
+		new.id,
+		unixepoch(),
+		(select actor from change_context where id = 1),
+		null,
+		json_object(
+			'parent_id', new.parent_id,
+			'name', new.name,
+			'type', new.type,
+			'comments', new.comments,
+			'image', cast(new.image as text),
+			'trash_id', new.trash_id
+		)
+	);
+end;
+
+-- only changes to the contents of a resource are recorded, not changes of the
+-- timestamps alone
+create trigger history_au after update on resource
+when
+	old.parent_id is not new.parent_id or
+	old.name is not new.name or
+	old.type is not new.type or
+	old.comments is not new.comments or
+	old.image is not new.image or
+	old.trash_id is not new.trash_id
+begin
+	insert into history (resource_id, changed_at, actor, old, new)
+	values (
+		new.id,
+		unixepoch(),
+		(select actor from change_context where id = 1),
+		json_object(
+			'parent_id', old.parent_id,
+			'name', old.name,
+			'type', old.type,
+			'comments', old.comments,
+			'image', cast(old.image as text),
+			'trash_id', old.trash_id
+		),
+		json_object(
+			'parent_id', new.parent_id,
+			'name', new.name,
+			'type', new.type,
+			'comments', new.comments,
+			'image', cast(new.image as text),
+			'trash_id', new.trash_id
+		)
+	);
+end;
+
+create trigger history_ad after delete on resource begin
+	insert into history (resource_id, changed_at, actor, old, new)
+	values (
+		old.id,
+		unixepoch(),
+		(select actor from change_context where id = 1),
+		json_object(
+			'parent_id', old.parent_id,
+			'name', old.name,
+			'type', old.type,
+			'comments', old.comments,
+			'image', cast(old.image as text),
+			'trash_id', old.trash_id
+		),
+		null
+	);
+end;
@@ -0,0 +1,93 @@
+-- an operation groups the changes made by a single request so that they can
+-- be undone together
+create table operation (
+	id integer primary key autoincrement,
+	description text not null,
+	created_at integer not null,
+	undone_at integer
+);
+
+alter table change_context add column operation_id integer;
+
+alter table history add column operation_id integer
+	references operation(id);
+
+create index history_operation_id on history(operation_id);
+
+drop trigger history_ai;
+drop trigger history_au;
+drop trigger history_ad;
+
+create trigger history_ai after insert on resource begin
+	insert into history (resource_id, changed_at, actor, operation_id, old, new)
+	values (
+		new.id,
+		unixepoch(),
+		(select actor from change_context where id = 1),
+		(select operation_id from change_context where id = 1),
+		null,
+		json_object(
+			'parent_id', new.parent_id,
+			'name', new.name,
+			'type', new.type,
+			'comments', new.comments,
+			'image', cast(new.image as text),
+			'trash_id', new.trash_id
+		)
+	);
+end;
+
+-- only changes to the contents of a resource are recorded, not changes of the
+-- timestamps alone
+create trigger history_au after update on resource
+when
+	old.parent_id is not new.parent_id or
+	old.name is not new.name or
+	old.type is not new.type or
+	old.comments is not new.comments or
+	old.image is not new.image or
+	old.trash_id is not new.trash_id
+begin
+	insert into history (resource_id, changed_at, actor, operation_id, old, new)
+	values (
+		new.id,
+		unixepoch(),
+		(select actor from change_context where id = 1),
+		(select operation_id from change_context where id = 1),
+		json_object(
+			'parent_id', old.parent_id,
+			'name', old.name,
+			'type', old.type,
+			'comments', old.comments,
+			'image', cast(old.image as text),
+			'trash_id', old.trash_id
+		),
+		json_object(
+			'parent_id', new.parent_id,
+			'name', new.name,
+			'type', new.type,
+			'comments', new.comments,
+			'image', cast(new.image as text),
+			'trash_id', new.trash_id
+		)
+	);
+end;
+
+create trigger history_ad after delete on resource begin
+	insert into history (resource_id, changed_at, actor, operation_id, old, new)
+	values (
+		old.id,
+		unixepoch(),
+		(select actor from change_context where id = 1),
+		(select operation_id from change_context where id = 1),
+		json_object(
+			'parent_id', old.parent_id,
+			'name', old.name,
+			'type', old.type,
+			'comments', old.comments,
+			'image', cast(old.image as text),
+			'trash_id', old.trash_id
+		),
+		null
+	);
+end;
@@ -0,0 +1,75 @@
+-- names are path segments, so they must not be empty, "." or "..", must not
+-- contain '/' and must be unique among the siblings of a resource. existing
+-- names which break this are renamed, "migrate status" lists the renames
+-- before they are applied (see GetNameRenames).
+
+insert into change_context (id, actor, operation_id)
+values (1, 'migration', null)
+on conflict (id) do update set
+	actor = excluded.actor,
+	operation_id = excluded.operation_id;
+
+update resource
+set
+	name = iif(
+		name in ('', '.', '..'),
+		'Untitled (' || id || ')',
+		replace(name, '/', '-')
+	),
+	updated_at = unixepoch()
+where name in ('', '.', '..') or instr(name, '/') > 0;
+
+-- the oldest resource keeps its name and the others get their id appended.
+-- if a sibling already has that name a counter is added to the id until the
+-- name is free. a renamed resource is the only one whose name ends with its
+-- id, so the new names only need to be checked against the names which are
+-- kept.
+create temp table name_rename as
+with recursive
+duplicate as (
+	select id, parent_id, name from resource
+	where trash_id is null and exists (
+		select 1 from resource as sibling
+		where
+			sibling.trash_id is null and
+			ifnull(sibling.parent_id, 0) = ifnull(resource.parent_id, 0) and
+			sibling.name = resource.name and
+			sibling.id < resource.id
+	)
+),
+kept as (
+	select parent_id, name from resource
+	where trash_id is null and id not in (select id from duplicate)
+),
+candidate (id, parent_id, base, n, name) as (
+	select id, parent_id, name, 1, name || ' (' || id || ')' from duplicate
+	union all
+	select id, parent_id, base, n + 1, base || ' (' || id || '-' || (n + 1) || ')'
+	from candidate
+	where exists (
+		select 1 from kept
+		where
+			ifnull(kept.parent_id, 0) = ifnull(candidate.parent_id, 0) and
+			kept.name = candidate.name
+	)
+)
+select id, name from candidate
+where not exists (
+	select 1 from kept
+	where
+		ifnull(kept.parent_id, 0) = ifnull(candidate.parent_id, 0) and
+		kept.name = candidate.name
+);
+
+update resource
+set
+	name = (select name from temp.name_rename where id = resource.id),
+	updated_at = unixepoch()
+where id in (select id from temp.name_rename);
+
+drop table temp.name_rename;
+
+-- trashed resources are not considered, restoring them fails if their name
+-- has been taken in the meantime
+create unique index resource_sibling_name on resource(ifnull(parent_id, 0), name)
+	where trash_id is null;
@@ -0,0 +1,11 @@
+-- the former paths of renamed and moved resources, requests for a path which
+-- no longer resolves are redirected to the current path of the resource with
+-- the longest matching alias
+create table path_alias (
+	path text primary key,
+	resource_id integer not null
+		references resource(id) on delete cascade,
+	created_at integer not null
+);
+
+create index path_alias_resource_id on path_alias(resource_id);
@@ -0,0 +1,10 @@
+-- the contents of barcodes and QR codes which were already on something when
+-- it was added to the archive, scanning one of them leads to the resource
+create table resource_code (
+	code text primary key,
+	resource_id integer not null
+		references resource(id) on delete cascade,
+	created_at integer not null
+);
+
+create index resource_code_resource_id on resource_code(resource_id);
@@ -0,0 +1,7 @@
+-- resources waiting to be named by the ai-tagger, the tagger names them before
+-- any other untitled resources and removes them from the queue afterwards
+create table tag_queue (
+	resource_id integer primary key
+		references resource(id) on delete cascade,
+	queued_at integer not null
+);
@@ -0,0 +1,58 @@
+-- the images of a resource in the order they are shown in its gallery. the
+-- primary image is the one shown in listings and search results, it stays in
+-- resource.image so that listings, the history and undo keep working without
+-- a join. the triggers below keep is_primary in sync with resource.image, the
+-- primary image is changed by changing resource.image.
+create table resource_image (
+	id integer primary key autoincrement,
+	resource_id integer not null
+		references resource(id) on delete cascade,
+	image text not null,
+	position integer not null,
+	is_primary boolean not null default false,
+
+	unique (resource_id, image)
+);
+
+create index resource_image_position on resource_image(resource_id, position);
+
+insert into resource_image (resource_id, image, position, is_primary)
+select id, cast(image as text), 0, true from resource
+where image is not null;
+
+create trigger resource_image_ai after insert on resource
+when new.image is not null
+begin
+	insert into resource_image (resource_id, image, position, is_primary)
+	values (new.id, new.image, 0, true);
+end;
+
+-- a new primary image is added to the end of the gallery if it is not already
+-- part of it
+create trigger resource_image_au after update of image on resource begin
+	insert into resource_image (resource_id, image, position)
+	select
+		new.id,
+		new.image,
+		ifnull((select max(position) + 1 from resource_image where resource_id = new.id), 0)
+	where new.image is not null
+	on conflict (resource_id, image) do nothing;
+
+	update resource_image
+	set is_primary = (image is new.image)
+	where resource_id = new.id;
+end;
+
+-- removing the primary image makes the first remaining image primary
+create trigger resource_image_ad after delete on resource_image
+when old.is_primary
+begin
+	update resource
+	set image = (
+		select image from resource_image
+		where resource_id = old.resource_id
+		order by position
+		limit 1
+	)
+	where id = old.resource_id;
+end;
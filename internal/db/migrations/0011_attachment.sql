@@ -0,0 +1,16 @@
+-- files attached to a resource which are not shown as images, ex. receipts,
+-- manuals and datasheets. their contents are kept in the blob store like
+-- images, the name and type of the uploaded file are kept here so that it can
+-- be downloaded as it was uploaded.
+create table attachment (
+	id integer primary key autoincrement,
+	resource_id integer not null
+		references resource(id) on delete cascade,
+	blob_id text not null,
+	filename text not null,
+	mime_type text not null,
+	size integer not null,
+	created_at integer not null
+);
+
+create index attachment_resource_id on attachment(resource_id);
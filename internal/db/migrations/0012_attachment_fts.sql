@@ -0,0 +1,11 @@
+-- the text of attachments, extracted when they are uploaded. the rowid is the
+-- id of the attachment, attachments without text (ex. images or scans without
+-- a text layer) have no row.
+create virtual table attachment_fts using fts5(
+	content,
+	tokenize='trigram'
+);
+
+create trigger attachment_fts_ad after delete on attachment begin
+	delete from attachment_fts where rowid = old.id;
+end;
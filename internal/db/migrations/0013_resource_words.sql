@@ -0,0 +1,29 @@
+-- the words of resource names, used to suggest corrections for misspelled
+-- searches. resource_fts cannot be used for this since its trigram tokenizer
+-- does not produce whole words.
+create virtual table resource_words using fts5(
+	name,
+	content=resource,
+	content_rowid=id,
+	tokenize='unicode61 remove_diacritics 0'
+);
+
+insert into resource_words(resource_words) values ('rebuild');
+
+create trigger resource_words_ai after insert on resource begin
+	insert into resource_words(rowid, name)
+	values (new.id, new.name);
+end;
+create trigger resource_words_ad after delete on resource begin
+	insert into resource_words(resource_words, rowid, name)
+	values ('delete', old.id, old.name);
+end;
+create trigger resource_words_au after update of name on resource begin
+	insert into resource_words(resource_words, rowid, name)
+	values ('delete', old.id, old.name);
+	insert into resource_words(rowid, name)
+	values (new.id, new.name);
+end;
+
+-- every word of resource_words with the amount of names containing it
+create virtual table resource_vocabulary using fts5vocab(resource_words, row);
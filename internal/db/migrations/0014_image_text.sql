@@ -0,0 +1,176 @@
+-- resource.image holds the SHA-256 of a blob since blobs are content
+-- addressed, but the column was declared as an integer when blobs were
+-- numbered. SQLite cannot change the type of a column, so the table is
+-- rebuilt with the same rows and the triggers and indexes on it are created
+-- again. foreign keys are disabled while migrating, so dropping the old table
+-- does not delete the rows referencing it.
+
+-- dropping the table forgets the last id handed out, it is restored below so
+-- that ids of deleted resources are not reused by new ones
+create temp table resource_sequence as
+select seq from sqlite_sequence where name = 'resource';
+
+create temp table resource_old as
+select * from resource;
+
+drop table resource;
+
+create table resource (
+	id integer primary key autoincrement,
+	parent_id integer,
+
+	name text not null,
+	type text not null,
+	comments text not null,
+	image text,
+
+	created_at integer not null default 0,
+	updated_at integer not null default 0,
+	trash_id integer references trash(id) on delete cascade,
+
+	foreign key(parent_id) references resource(id)
+		on update cascade
+		on delete cascade
+);
+
+insert into resource (id, parent_id, name, type, comments, image, created_at, updated_at, trash_id)
+select id, parent_id, name, type, comments, cast(image as text), created_at, updated_at, trash_id
+from temp.resource_old;
+
+update sqlite_sequence
+set seq = max(seq, (select seq from temp.resource_sequence))
+where name = 'resource' and exists (select 1 from temp.resource_sequence);
+
+drop table temp.resource_old;
+drop table temp.resource_sequence;
+
+create index resource_trash_id on resource(trash_id);
+create unique index resource_sibling_name on resource(ifnull(parent_id, 0), name)
+	where trash_id is null;
+
+create trigger resource_ai after insert on resource begin
+	insert into resource_fts(rowid, name, comments)
+	values (new.id, new.name, new.comments);
+end;
+create trigger resource_ad after delete on resource begin
+	insert into resource_fts(resource_fts, rowid, name, comments)
+	values ('delete', old.id, old.name, old.comments);
+end;
+create trigger resource_au after update on resource begin
+	insert into resource_fts(resource_fts, rowid, name, comments)
+	values ('delete', old.id, old.name, old.comments);
+	insert into resource_fts(rowid, name, comments)
+	values (new.id, new.name, new.comments);
+end;
+
+create trigger history_ai after insert on resource begin
+	insert into history (resource_id, changed_at, actor, operation_id, old, new)
+	values (
+		new.id,
+		unixepoch(),
+		(select actor from change_context where id = 1),
+		(select operation_id from change_context where id = 1),
+		null,
+		json_object(
+			'parent_id', new.parent_id,
+			'name', new.name,
+			'type', new.type,
+			'comments', new.comments,
+			'image', cast(new.image as text),
+			'trash_id', new.trash_id
+		)
+	);
+end;
+
+-- only changes to the contents of a resource are recorded, not changes of the
+-- timestamps alone
+create trigger history_au after update on resource
+when
+	old.parent_id is not new.parent_id or
+	old.name is not new.name or
+	old.type is not new.type or
+	old.comments is not new.comments or
+	old.image is not new.image or
+	old.trash_id is not new.trash_id
+begin
+	insert into history (resource_id, changed_at, actor, operation_id, old, new)
+	values (
+		new.id,
+		unixepoch(),
+		(select actor from change_context where id = 1),
+		(select operation_id from change_context where id = 1),
+		json_object(
+			'parent_id', old.parent_id,
+			'name', old.name,
+			'type', old.type,
+			'comments', old.comments,
+			'image', cast(old.image as text),
+			'trash_id', old.trash_id
+		),
+		json_object(
+			'parent_id', new.parent_id,
+			'name', new.name,
+			'type', new.type,
+			'comments', new.comments,
+			'image', cast(new.image as text),
+			'trash_id', new.trash_id
+		)
+	);
+end;
+
+create trigger history_ad after delete on resource begin
+	insert into history (resource_id, changed_at, actor, operation_id, old, new)
+	values (
+		old.id,
+		unixepoch(),
+		(select actor from change_context where id = 1),
+		(select operation_id from change_context where id = 1),
+		json_object(
+			'parent_id', old.parent_id,
+			'name', old.name,
+			'type', old.type,
+			'comments', old.comments,
+			'image', cast(old.image as text),
+			'trash_id', old.trash_id
+		),
+		null
+	);
+end;
+
+create trigger resource_image_ai after insert on resource
+when new.image is not null
+begin
+	insert into resource_image (resource_id, image, position, is_primary)
+	values (new.id, new.image, 0, true);
+end;
+
+-- a new primary image is added to the end of the gallery if it is not already
+-- part of it
+create trigger resource_image_au after update of image on resource begin
+	insert into resource_image (resource_id, image, position)
+	select
+		new.id,
+		new.image,
+		ifnull((select max(position) + 1 from resource_image where resource_id = new.id), 0)
+	where new.image is not null
+	on conflict (resource_id, image) do nothing;
+
+	update resource_image
+	set is_primary = (image is new.image)
+	where resource_id = new.id;
+end;
+
+create trigger resource_words_ai after insert on resource begin
+	insert into resource_words(rowid, name)
+	values (new.id, new.name);
+end;
+create trigger resource_words_ad after delete on resource begin
+	insert into resource_words(resource_words, rowid, name)
+	values ('delete', old.id, old.name);
+end;
+create trigger resource_words_au after update of name on resource begin
+	insert into resource_words(resource_words, rowid, name)
+	values ('delete', old.id, old.name);
+	insert into resource_words(rowid, name)
+	values (new.id, new.name);
+end;
@@ -0,0 +1,32 @@
+-- trashed resources are not searched, so the words of their names must not be
+-- suggested either. only resources outside of the trash are in resource_words,
+-- a resource is added and removed as it is restored and trashed.
+
+drop trigger resource_words_ai;
+drop trigger resource_words_ad;
+drop trigger resource_words_au;
+
+create trigger resource_words_ai after insert on resource
+when new.trash_id is null begin
+	insert into resource_words(rowid, name)
+	values (new.id, new.name);
+end;
+create trigger resource_words_ad after delete on resource
+when old.trash_id is null begin
+	insert into resource_words(resource_words, rowid, name)
+	values ('delete', old.id, old.name);
+end;
+create trigger resource_words_au after update of name, trash_id on resource begin
+	insert into resource_words(resource_words, rowid, name)
+	select 'delete', old.id, old.name
+	where old.trash_id is null;
+	insert into resource_words(rowid, name)
+	select new.id, new.name
+	where new.trash_id is null;
+end;
+
+-- 'rebuild' would add every row of the content table
+insert into resource_words(resource_words) values ('delete-all');
+insert into resource_words(rowid, name)
+select id, name from resource
+where trash_id is null;
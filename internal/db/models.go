@@ -8,16 +8,93 @@ import (
 	"database/sql"
 )
 
+type Attachment struct {
+	ID         int64
+	ResourceID int64
+	BlobID     string
+	Filename   string
+	MimeType   string
+	Size       int64
+	CreatedAt  int64
+}
+
+type AttachmentFt struct {
+	Content string
+}
+
+type ChangeContext struct {
+	ID          int64
+	Actor       string
+	OperationID sql.NullInt64
+}
+
+type History struct {
+	ID          int64
+	ResourceID  int64
+	ChangedAt   int64
+	Actor       sql.NullString
+	Old         sql.NullString
+	New         sql.NullString
+	OperationID sql.NullInt64
+}
+
+type Operation struct {
+	ID          int64
+	Description string
+	CreatedAt   int64
+	UndoneAt    sql.NullInt64
+}
+
+type PathAlias struct {
+	Path       string
+	ResourceID int64
+	CreatedAt  int64
+}
+
 type Resource struct {
-	ID       int64
-	ParentID sql.NullInt64
-	Name     string
-	Type     string
-	Comments string
-	Image    sql.NullInt64
+	ID        int64
+	ParentID  sql.NullInt64
+	Name      string
+	Type      string
+	Comments  string
+	Image     sql.NullString
+	CreatedAt int64
+	UpdatedAt int64
+	TrashID   sql.NullInt64
+}
+
+type ResourceCode struct {
+	Code       string
+	ResourceID int64
+	CreatedAt  int64
 }
 
 type ResourceFt struct {
 	Name     string
 	Comments string
 }
+
+type ResourceImage struct {
+	ID         int64
+	ResourceID int64
+	Image      string
+	Position   int64
+	IsPrimary  bool
+}
+
+type ResourceWord struct {
+	Name string
+}
+
+type TagQueue struct {
+	ResourceID int64
+	QueuedAt   int64
+}
+
+type Trash struct {
+	ID               int64
+	ResourceID       int64
+	OriginalParentID sql.NullInt64
+	OriginalPath     string
+	DeletedAt        int64
+}
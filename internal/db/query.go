@@ -3,6 +3,9 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"unsafe"
@@ -59,6 +62,7 @@ const resolve = `with recursive
 			paths.name = resource.name
 		where
 			resource.parent_id is null and
+			resource.trash_id is null and
 			paths.step = 1
 
 		union all
@@ -132,61 +136,110 @@ const getPath = `with recursive
 		from resource
 		join found
 		where
-			resource.id = found.parent_id
+			resource.id = found.parent_id and
+			-- a path can never be longer than the amount of resources, this
+			-- stops the recursion if the parents form a cycle
+			found.step < (select count(*) from resource)
 	)
 
-select name from found
+select parent_id, name from found
 order by step desc`
 
+// ErrCycle is returned when the ancestors of a resource loop back on
+// themselves instead of ending at a root resource
+var ErrCycle = errors.New("resource is part of a parent cycle")
+
 func (q *Queries) GetPath(ctx context.Context, id int64) (path []string, err error) {
 	rows, err := q.db.QueryContext(ctx, getPath, id)
 	if err != nil {
 		return
 	}
+	defer rows.Close()
 	for rows.Next() {
+		var parentID sql.NullInt64
 		var name string
-		err = rows.Scan(&name)
+		err = rows.Scan(&parentID, &name)
 		if err != nil {
 			return
 		}
+		// the topmost ancestor must be a root resource
+		if path == nil && parentID.Valid {
+			err = ErrCycle
+			return
+		}
 		path = append(path, name)
 	}
+	err = rows.Err()
 	return
 }
 
-const search = `select resource.* from resource
-join resource_fts on resource.id = resource_fts.rowid
-where resource_fts match ?
-order by rank`
+// getPaths walks up from all resources at once like getPath, the path is
+// built while walking so only the row which reached a root is needed
+const getPaths = `with recursive
+	found as (
+		select
+			id,
+			parent_id,
+			name as path,
+			1 as step
+		from resource
+		where id in (/*SLICE:ids*/?)
+
+		union all
 
-func (q *Queries) Search(ctx context.Context, query string) ([]Resource, error) {
-	rows, err := q.db.QueryContext(ctx, search, query)
+		select
+			found.id,
+			resource.parent_id,
+			resource.name || '/' || found.path,
+			1 + found.step
+		from resource
+		join found
+		where
+			resource.id = found.parent_id and
+			found.step < (select count(*) from resource)
+	)
+
+select id, path from found
+where parent_id is null`
+
+// GetPaths returns the path segments of every resource like GetPath but with
+// a single query
+func (q *Queries) GetPaths(ctx context.Context, ids []int64) (paths map[int64][]string, err error) {
+	paths = make(map[int64][]string, len(ids))
+	if len(ids) == 0 {
+		return
+	}
+	var args []any
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	query := strings.Replace(getPaths, "/*SLICE:ids*/?", strings.Repeat(",?", len(ids))[1:], 1)
+	rows, err := q.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return
 	}
 	defer rows.Close()
-	var items []Resource
 	for rows.Next() {
-		var i Resource
-		if err := rows.Scan(
-			&i.ID,
-			&i.ParentID,
-			&i.Name,
-			&i.Type,
-			&i.Comments,
-			&i.Image,
-		); err != nil {
-			return nil, err
+		var id int64
+		var p string
+		err = rows.Scan(&id, &p)
+		if err != nil {
+			return
 		}
-		items = append(items, i)
+		// names never contain '/'
+		paths[id] = strings.Split(p, "/")
 	}
-	if err := rows.Close(); err != nil {
-		return nil, err
+	err = rows.Err()
+	if err != nil {
+		return
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+	for _, id := range ids {
+		if _, ok := paths[id]; !ok {
+			err = ErrCycle
+			return
+		}
 	}
-	return items, nil
+	return
 }
 
 /*
@@ -239,7 +292,7 @@ const getFullTree = `with recursive
 			resource.id,
 			'/' || resource.name || '/' as path
 		from resource
-		where parent_id is null
+		where parent_id is null and trash_id is null
 
 		union all
 
@@ -276,7 +329,7 @@ const getAllContainers = `with recursive
 			resource.type,
 			'/' || resource.name || '/' as path
 		from resource
-		where parent_id is null
+		where parent_id is null and trash_id is null
 
 		union all
 
@@ -307,3 +360,347 @@ func (q *Queries) GetAllContainers(ctx context.Context) (out []string, err error
 	}
 	return
 }
+
+const getContainers = `with recursive
+	found as (
+		select
+			resource.id,
+			resource.type,
+			resource.name as path
+		from resource
+		where parent_id is ? and trash_id is null
+
+		union all
+
+		select
+			resource.id,
+			resource.type,
+			found.path || '/' || resource.name
+		from resource
+		join found on
+			resource.parent_id = found.id
+	)
+select id, path from found
+where type = 'container'
+order by path`
+
+type GetContainersRow struct {
+	ID   int64
+	Path string
+}
+
+// GetContainers returns every container below the parent with their path
+// relative to it
+func (q *Queries) GetContainers(ctx context.Context, parentID sql.NullInt64) (out []GetContainersRow, err error) {
+	rows, err := q.db.QueryContext(ctx, getContainers, parentID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r GetContainersRow
+		err = rows.Scan(&r.ID, &r.Path)
+		if err != nil {
+			return
+		}
+		out = append(out, r)
+	}
+	err = rows.Err()
+	return
+}
+
+// trashResource marks the resource and all its descendants as part of the
+// trash entry and detaches the resource from its parent. "union" discards rows
+// which have already been found so cycles cannot recurse forever.
+const trashResource = `with recursive
+	subtree(id) as (
+		select ?1
+
+		union
+
+		select resource.id from resource
+		join subtree on
+			resource.parent_id = subtree.id
+		where not ?3
+	)
+update resource
+set
+	trash_id = ?2,
+	parent_id = iif(id = ?1, null, parent_id)
+where id in (select id from subtree)`
+
+type TrashResourceParams struct {
+	ID      int64
+	TrashID int64
+	// Shallow only trashes the resource itself, its children stay where
+	// they are and have to be moved elsewhere
+	Shallow bool
+}
+
+// TrashResource moves the subtree of a resource into a trash entry created
+// with CreateTrash, it returns the amount of resources in the subtree
+func (q *Queries) TrashResource(ctx context.Context, arg TrashResourceParams) (n int64, err error) {
+	res, err := q.db.ExecContext(ctx, trashResource, arg.ID, arg.TrashID, arg.Shallow)
+	if err != nil {
+		return
+	}
+	return res.RowsAffected()
+}
+
+// CheckSearchIndex reports whether resource_fts matches the contents of the
+// resource table
+func (q *Queries) CheckSearchIndex(ctx context.Context) (ok bool, err error) {
+	// with rank = 1 the index is also compared against the content table
+	_, err = q.db.ExecContext(ctx, `insert into resource_fts(resource_fts, rank) values ('integrity-check', 1)`)
+	if err != nil && strings.Contains(err.Error(), "malformed") {
+		return false, nil
+	}
+	if err != nil {
+		return
+	}
+	return true, nil
+}
+
+// RebuildSearchIndex recreates resource_fts and resource_words from the
+// resource table, resource_words only holds the resources outside of the trash
+func (q *Queries) RebuildSearchIndex(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, `insert into resource_fts(resource_fts) values ('rebuild')`)
+	if err != nil {
+		return err
+	}
+	_, err = q.db.ExecContext(ctx, `insert into resource_words(resource_words) values ('delete-all')`)
+	if err != nil {
+		return err
+	}
+	_, err = q.db.ExecContext(ctx, `insert into resource_words(rowid, name)
+select id, name from resource
+where trash_id is null`)
+	return err
+}
+
+/*
+resources which cannot be reached by walking down from the root resources are
+either part of a parent cycle or descendants of one.
+
+"union" instead of "union all" discards rows which have already been found, so
+the recursion terminates even though the parents may form cycles.
+*/
+const getUnreachable = `with recursive
+	reachable(id) as (
+		select id from resource
+		where parent_id is null
+
+		union
+
+		select resource.id from resource
+		join reachable on
+			resource.parent_id = reachable.id
+	)
+select id, parent_id from resource
+where id not in (select id from reachable)`
+
+type GetUnreachableRow struct {
+	ID       int64
+	ParentID sql.NullInt64
+}
+
+func (q *Queries) GetUnreachable(ctx context.Context) (out []GetUnreachableRow, err error) {
+	rows, err := q.db.QueryContext(ctx, getUnreachable)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r GetUnreachableRow
+		err = rows.Scan(&r.ID, &r.ParentID)
+		if err != nil {
+			return
+		}
+		out = append(out, r)
+	}
+	err = rows.Err()
+	return
+}
+
+const getNameCollisions = `select parent_id, name, group_concat(id) from resource
+where trash_id is null
+group by parent_id, name
+having count(*) > 1`
+
+type GetNameCollisionsRow struct {
+	ParentID sql.NullInt64
+	Name     string
+	IDs      []int64
+}
+
+// GetNameCollisions returns all groups of siblings which share the same name,
+// only one resource of each group can be reached through Resolve
+func (q *Queries) GetNameCollisions(ctx context.Context) (out []GetNameCollisionsRow, err error) {
+	rows, err := q.db.QueryContext(ctx, getNameCollisions)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r GetNameCollisionsRow
+		var ids string
+		err = rows.Scan(&r.ParentID, &r.Name, &ids)
+		if err != nil {
+			return
+		}
+		for s := range strings.SplitSeq(ids, ",") {
+			var id int64
+			id, err = strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return
+			}
+			r.IDs = append(r.IDs, id)
+		}
+		out = append(out, r)
+	}
+	err = rows.Err()
+	return
+}
+
+// ValidName reports whether a name can be given to a resource, names are
+// segments of a path so they must not be empty, "." or ".." and must not
+// contain '/'
+func ValidName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// IsNameTaken reports whether the error is caused by a resource being given
+// the same name as one of its siblings
+func IsNameTaken(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "UNIQUE constraint failed") && strings.Contains(msg, "resource_sibling_name")
+}
+
+// getNameRenames mirrors the renames made by the 0006_unique_names migration,
+// the resources are selected by one of the sources below depending on whether
+// the 0003_trash migration has been applied
+const getNameRenames = `with recursive
+source as (%s),
+fixed as (
+	select
+		id,
+		parent_id,
+		trash_id,
+		name as old_name,
+		iif(
+			name in ('', '.', '..'),
+			'Untitled (' || id || ')',
+			replace(name, '/', '-')
+		) as name
+	from source
+),
+duplicate as (
+	select id, parent_id, name from fixed
+	where trash_id is null and exists (
+		select 1 from fixed as sibling
+		where
+			sibling.trash_id is null and
+			ifnull(sibling.parent_id, 0) = ifnull(fixed.parent_id, 0) and
+			sibling.name = fixed.name and
+			sibling.id < fixed.id
+	)
+),
+kept as (
+	select parent_id, name from fixed
+	where trash_id is null and id not in (select id from duplicate)
+),
+candidate (id, parent_id, base, n, name) as (
+	select id, parent_id, name, 1, name || ' (' || id || ')' from duplicate
+	union all
+	select id, parent_id, base, n + 1, base || ' (' || id || '-' || (n + 1) || ')'
+	from candidate
+	where exists (
+		select 1 from kept
+		where
+			ifnull(kept.parent_id, 0) = ifnull(candidate.parent_id, 0) and
+			kept.name = candidate.name
+	)
+),
+deduplicated as (
+	select id, name from candidate
+	where not exists (
+		select 1 from kept
+		where
+			ifnull(kept.parent_id, 0) = ifnull(candidate.parent_id, 0) and
+			kept.name = candidate.name
+	)
+)
+select fixed.id, fixed.parent_id, fixed.old_name, ifnull(deduplicated.name, fixed.name) as new_name
+from fixed
+left join deduplicated on deduplicated.id = fixed.id
+where new_name != fixed.old_name
+order by fixed.id`
+
+type GetNameRenamesRow struct {
+	ID       int64
+	ParentID sql.NullInt64
+	OldName  string
+	NewName  string
+}
+
+const (
+	nameRenamesSource         = `select id, parent_id, trash_id, name from resource`
+	nameRenamesPreTrashSource = `select id, parent_id, null as trash_id, name from resource`
+)
+
+// GetNameRenames returns the resources which are renamed by the migration that
+// makes names unique among siblings, along with their new name. hasTrash is
+// false for databases older than the trash, where no resource is trashed.
+func (q *Queries) GetNameRenames(ctx context.Context, hasTrash bool) (out []GetNameRenamesRow, err error) {
+	source := nameRenamesPreTrashSource
+	if hasTrash {
+		source = nameRenamesSource
+	}
+	rows, err := q.db.QueryContext(ctx, fmt.Sprintf(getNameRenames, source))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r GetNameRenamesRow
+		err = rows.Scan(&r.ID, &r.ParentID, &r.OldName, &r.NewName)
+		if err != nil {
+			return
+		}
+		out = append(out, r)
+	}
+	err = rows.Err()
+	return
+}
+
+// HistorySnapshot is the state of a resource as recorded in the history by the
+// history_* triggers
+type HistorySnapshot struct {
+	ParentID *int64  `json:"parent_id"`
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Comments string  `json:"comments"`
+	Image    *string `json:"image"`
+	TrashID  *int64  `json:"trash_id"`
+}
+
+// Snapshots decodes the state of the resource before and after the change,
+// before is nil if the change created the resource and after is nil if it
+// deleted the resource
+func (h History) Snapshots() (before, after *HistorySnapshot, err error) {
+	if h.Old.Valid {
+		before = &HistorySnapshot{}
+		err = json.Unmarshal([]byte(h.Old.String), before)
+		if err != nil {
+			return
+		}
+	}
+	if h.New.Valid {
+		after = &HistorySnapshot{}
+		err = json.Unmarshal([]byte(h.New.String), after)
+	}
+	return
+}
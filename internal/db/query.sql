@@ -1,6 +1,6 @@
 -- name: CreateResource :one
-insert into resource (parent_id, name, type, comments, image)
-values (?, ?, ?, ?, ?)
+insert into resource (parent_id, name, type, comments, image, created_at, updated_at)
+values (?, ?, ?, ?, ?, unixepoch(), unixepoch())
 returning id;
 
 -- name: UpdateResource :many
@@ -8,36 +8,305 @@ update resource
 set
 	name = ?,
 	type = ?,
-	comments = ?
-where id = ?
+	comments = ?,
+	updated_at = unixepoch()
+where id = ? and trash_id is null
 returning id;
 
 -- name: UpdateResourceImage :many
 update resource
-set image = ?
-where id = ?
+set image = ?, updated_at = unixepoch()
+where id = ? and trash_id is null
 returning id;
 
+-- name: ListImages :many
+select image from resource
+where image is not null
+union
+select image from resource_image;
+
+-- name: ListImageReferences :many
+select id, image from resource
+where image is not null
+union
+select resource_id, image from resource_image;
+
+-- name: ReplaceImage :exec
+update resource
+set image = @new_image
+where image = @old_image;
+
+-- name: ReplaceHistoryImage :exec
+update history
+set
+	old = iif(old ->> 'image' = @old_image, json_set(old, '$.image', @new_image), old),
+	new = iif(new ->> 'image' = @old_image, json_set(new, '$.image', @new_image), new)
+where old ->> 'image' = @old_image or new ->> 'image' = @old_image;
+
+-- name: ReplaceResourceImage :exec
+update resource_image
+set image = @new_image
+where image = @old_image;
+
+-- name: TouchResource :exec
+update resource
+set updated_at = unixepoch()
+where id = ?;
+
+-- name: ListResourceImages :many
+select * from resource_image
+where resource_id = ?
+order by position;
+
+-- name: CreateResourceImage :exec
+insert into resource_image (resource_id, image, position)
+values (
+	@resource_id,
+	@image,
+	ifnull((select max(position) + 1 from resource_image where resource_id = @resource_id), 0)
+)
+on conflict (resource_id, image) do nothing;
+
+-- name: SetResourceImagePosition :exec
+update resource_image
+set position = ?
+where id = ?;
+
+-- name: DeleteResourceImage :exec
+delete from resource_image
+where id = ?;
+
+-- name: DeleteResourceImageByBlob :exec
+delete from resource_image
+where resource_id = ? and image = ?;
+
 -- name: ChangeParent :exec
 update resource
-set parent_id = @new_parent
-where parent_id is @old_parent;
+set parent_id = @new_parent, updated_at = unixepoch()
+where parent_id is @old_parent and trash_id is null;
 
 -- name: MoveResources :many
 update resource
-set parent_id = @new_parent
-where id in (sqlc.slice('ids'))
+set parent_id = @new_parent, updated_at = unixepoch()
+where id in (sqlc.slice('ids')) and trash_id is null
 returning id;
 
 -- name: ListResources :many
 select * from resource
-where parent_id is ?;
+where parent_id is ? and trash_id is null;
 
 -- name: GetResource :one
 select * from resource
-where id = ?;
+where id = ? and trash_id is null;
 
 -- name: DeleteResource :exec
 delete from resource
 where id = ?;
 
+
+-- name: CreateTrash :one
+insert into trash (resource_id, original_parent_id, original_path, deleted_at)
+values (?, ?, ?, unixepoch())
+returning id;
+
+-- name: GetTrash :one
+select * from trash
+where id = ?;
+
+-- name: ListTrash :many
+select
+	trash.*,
+	resource.name,
+	resource.type,
+	(select count(*) from resource r where r.trash_id = trash.id) as size
+from trash
+join resource on resource.id = trash.resource_id
+-- restored entries are kept until they are purged so that restores can be
+-- undone
+where exists (select 1 from resource r where r.trash_id = trash.id)
+order by trash.deleted_at desc;
+
+-- name: RestoreTrash :execrows
+update resource
+set
+	trash_id = null,
+	parent_id = iif(id = @resource_id, @new_parent, parent_id),
+	updated_at = iif(id = @resource_id, unixepoch(), updated_at)
+where trash_id = @trash_id;
+
+-- name: PurgeTrash :many
+delete from trash
+where deleted_at < @before
+returning id;
+
+-- name: SetChangeContext :exec
+insert into change_context (id, actor, operation_id)
+values (1, ?, ?)
+on conflict (id) do update set
+	actor = excluded.actor,
+	operation_id = excluded.operation_id;
+
+-- name: GetHistory :one
+select * from history
+where id = ?;
+
+-- name: ListHistory :many
+select * from history
+where resource_id = ?
+order by id desc;
+
+-- name: ListRecentHistory :many
+select * from history
+order by id desc
+limit ?;
+
+-- name: RevertResource :many
+update resource
+set
+	parent_id = ?,
+	name = ?,
+	type = ?,
+	comments = ?,
+	image = ?,
+	updated_at = unixepoch()
+where id = ? and trash_id is null
+returning id;
+
+-- name: CreateOperation :one
+insert into operation (description, created_at)
+values (?, unixepoch())
+returning id;
+
+-- name: GetOperation :one
+select * from operation
+where id = ?;
+
+-- name: ClearChangeOperation :exec
+update change_context
+set operation_id = null
+where id = 1;
+
+-- name: DeleteUnusedOperation :exec
+delete from operation
+where id = @id and not exists (
+	select 1 from history where operation_id = @id
+);
+
+-- name: ListOperationHistory :many
+select * from history
+where operation_id = ?
+order by id desc;
+
+-- name: CountChangesAfterOperation :one
+select count(*) from history
+join (
+	select resource_id, max(id) as last_id from history
+	where operation_id = @operation_id
+	group by resource_id
+) as changed on changed.resource_id = history.resource_id
+where history.id > changed.last_id;
+
+-- name: MarkOperationUndone :exec
+update operation
+set undone_at = unixepoch()
+where id = ?;
+
+-- name: HasChildren :one
+select exists (
+	select 1 from resource where parent_id = ?
+);
+
+-- name: UndoResource :exec
+update resource
+set
+	parent_id = ?,
+	name = ?,
+	type = ?,
+	comments = ?,
+	image = ?,
+	trash_id = ?,
+	updated_at = unixepoch()
+where id = ?;
+
+-- name: CreatePathAlias :exec
+insert into path_alias (path, resource_id, created_at)
+values (?, ?, unixepoch())
+on conflict (path) do update set
+	resource_id = excluded.resource_id,
+	created_at = excluded.created_at;
+
+-- name: GetPathAlias :one
+select path_alias.path, path_alias.resource_id, path_alias.created_at from path_alias
+join resource on resource.id = path_alias.resource_id
+where
+	resource.trash_id is null and
+	(path_alias.path = @path or substr(@path, 1, length(path_alias.path) + 1) = path_alias.path || '/')
+order by length(path_alias.path) desc
+limit 1;
+
+-- name: ListPathAliases :many
+select * from path_alias
+order by path;
+
+-- name: DeletePathAlias :exec
+delete from path_alias
+where path = ?;
+
+-- name: CreateResourceCode :exec
+insert into resource_code (code, resource_id, created_at)
+values (?, ?, unixepoch())
+on conflict (code) do update set
+	resource_id = excluded.resource_id,
+	created_at = excluded.created_at;
+
+-- name: GetResourceByCode :one
+select resource_code.resource_id from resource_code
+join resource on resource.id = resource_code.resource_id
+where resource_code.code = ? and resource.trash_id is null;
+
+-- name: EnqueueTag :exec
+insert into tag_queue (resource_id, queued_at)
+values (?, unixepoch())
+on conflict (resource_id) do nothing;
+
+-- name: ListTagQueue :many
+select resource.* from tag_queue
+join resource on resource.id = tag_queue.resource_id
+where resource.trash_id is null
+order by tag_queue.queued_at, tag_queue.resource_id;
+
+-- name: DequeueTag :exec
+delete from tag_queue
+where resource_id = ?;
+
+-- name: CreateAttachment :one
+insert into attachment (resource_id, blob_id, filename, mime_type, size, created_at)
+values (?, ?, ?, ?, ?, unixepoch())
+returning id;
+
+-- name: GetAttachment :one
+select * from attachment
+where id = ?;
+
+-- name: ListAttachments :many
+select * from attachment
+where resource_id = ?
+order by id;
+
+-- name: ListAllAttachments :many
+select * from attachment
+order by id;
+
+-- name: ListAttachmentBlobs :many
+select distinct blob_id from attachment;
+
+-- name: DeleteAttachment :exec
+delete from attachment
+where id = ?;
+
+-- name: IndexAttachment :exec
+insert into attachment_fts (rowid, content)
+values (?, ?);
+
+-- name: ClearAttachmentIndex :exec
+delete from attachment_fts;
@@ -13,8 +13,8 @@ import (
 
 const changeParent = `-- name: ChangeParent :exec
 update resource
-set parent_id = ?1
-where parent_id is ?2
+set parent_id = ?1, updated_at = unixepoch()
+where parent_id is ?2 and trash_id is null
 `
 
 type ChangeParentParams struct {
@@ -27,9 +27,104 @@ func (q *Queries) ChangeParent(ctx context.Context, arg ChangeParentParams) erro
 	return err
 }
 
+const clearAttachmentIndex = `-- name: ClearAttachmentIndex :exec
+delete from attachment_fts
+`
+
+func (q *Queries) ClearAttachmentIndex(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, clearAttachmentIndex)
+	return err
+}
+
+const clearChangeOperation = `-- name: ClearChangeOperation :exec
+update change_context
+set operation_id = null
+where id = 1
+`
+
+func (q *Queries) ClearChangeOperation(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, clearChangeOperation)
+	return err
+}
+
+const countChangesAfterOperation = `-- name: CountChangesAfterOperation :one
+select count(*) from history
+join (
+	select resource_id, max(id) as last_id from history
+	where operation_id = ?1
+	group by resource_id
+) as changed on changed.resource_id = history.resource_id
+where history.id > changed.last_id
+`
+
+func (q *Queries) CountChangesAfterOperation(ctx context.Context, operationID sql.NullInt64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChangesAfterOperation, operationID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAttachment = `-- name: CreateAttachment :one
+insert into attachment (resource_id, blob_id, filename, mime_type, size, created_at)
+values (?, ?, ?, ?, ?, unixepoch())
+returning id
+`
+
+type CreateAttachmentParams struct {
+	ResourceID int64
+	BlobID     string
+	Filename   string
+	MimeType   string
+	Size       int64
+}
+
+func (q *Queries) CreateAttachment(ctx context.Context, arg CreateAttachmentParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, createAttachment,
+		arg.ResourceID,
+		arg.BlobID,
+		arg.Filename,
+		arg.MimeType,
+		arg.Size,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createOperation = `-- name: CreateOperation :one
+insert into operation (description, created_at)
+values (?, unixepoch())
+returning id
+`
+
+func (q *Queries) CreateOperation(ctx context.Context, description string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, createOperation, description)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createPathAlias = `-- name: CreatePathAlias :exec
+insert into path_alias (path, resource_id, created_at)
+values (?, ?, unixepoch())
+on conflict (path) do update set
+	resource_id = excluded.resource_id,
+	created_at = excluded.created_at
+`
+
+type CreatePathAliasParams struct {
+	Path       string
+	ResourceID int64
+}
+
+func (q *Queries) CreatePathAlias(ctx context.Context, arg CreatePathAliasParams) error {
+	_, err := q.db.ExecContext(ctx, createPathAlias, arg.Path, arg.ResourceID)
+	return err
+}
+
 const createResource = `-- name: CreateResource :one
-insert into resource (parent_id, name, type, comments, image)
-values (?, ?, ?, ?, ?)
+insert into resource (parent_id, name, type, comments, image, created_at, updated_at)
+values (?, ?, ?, ?, ?, unixepoch(), unixepoch())
 returning id
 `
 
@@ -38,7 +133,7 @@ type CreateResourceParams struct {
 	Name     string
 	Type     string
 	Comments string
-	Image    sql.NullInt64
+	Image    sql.NullString
 }
 
 func (q *Queries) CreateResource(ctx context.Context, arg CreateResourceParams) (int64, error) {
@@ -54,42 +149,692 @@ func (q *Queries) CreateResource(ctx context.Context, arg CreateResourceParams)
 	return id, err
 }
 
-const deleteResource = `-- name: DeleteResource :exec
-delete from resource
-where id = ?
+const createResourceCode = `-- name: CreateResourceCode :exec
+insert into resource_code (code, resource_id, created_at)
+values (?, ?, unixepoch())
+on conflict (code) do update set
+	resource_id = excluded.resource_id,
+	created_at = excluded.created_at
+`
+
+type CreateResourceCodeParams struct {
+	Code       string
+	ResourceID int64
+}
+
+func (q *Queries) CreateResourceCode(ctx context.Context, arg CreateResourceCodeParams) error {
+	_, err := q.db.ExecContext(ctx, createResourceCode, arg.Code, arg.ResourceID)
+	return err
+}
+
+const createResourceImage = `-- name: CreateResourceImage :exec
+insert into resource_image (resource_id, image, position)
+values (
+	?1,
+	?2,
+	ifnull((select max(position) + 1 from resource_image where resource_id = ?1), 0)
+)
+on conflict (resource_id, image) do nothing
+`
+
+type CreateResourceImageParams struct {
+	ResourceID int64
+	Image      string
+}
+
+func (q *Queries) CreateResourceImage(ctx context.Context, arg CreateResourceImageParams) error {
+	_, err := q.db.ExecContext(ctx, createResourceImage, arg.ResourceID, arg.Image)
+	return err
+}
+
+const createTrash = `-- name: CreateTrash :one
+insert into trash (resource_id, original_parent_id, original_path, deleted_at)
+values (?, ?, ?, unixepoch())
+returning id
+`
+
+type CreateTrashParams struct {
+	ResourceID       int64
+	OriginalParentID sql.NullInt64
+	OriginalPath     string
+}
+
+func (q *Queries) CreateTrash(ctx context.Context, arg CreateTrashParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, createTrash, arg.ResourceID, arg.OriginalParentID, arg.OriginalPath)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const deleteAttachment = `-- name: DeleteAttachment :exec
+delete from attachment
+where id = ?
+`
+
+func (q *Queries) DeleteAttachment(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteAttachment, id)
+	return err
+}
+
+const deletePathAlias = `-- name: DeletePathAlias :exec
+delete from path_alias
+where path = ?
+`
+
+func (q *Queries) DeletePathAlias(ctx context.Context, path string) error {
+	_, err := q.db.ExecContext(ctx, deletePathAlias, path)
+	return err
+}
+
+const deleteResource = `-- name: DeleteResource :exec
+delete from resource
+where id = ?
+`
+
+func (q *Queries) DeleteResource(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteResource, id)
+	return err
+}
+
+const deleteResourceImage = `-- name: DeleteResourceImage :exec
+delete from resource_image
+where id = ?
+`
+
+func (q *Queries) DeleteResourceImage(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteResourceImage, id)
+	return err
+}
+
+const deleteResourceImageByBlob = `-- name: DeleteResourceImageByBlob :exec
+delete from resource_image
+where resource_id = ? and image = ?
+`
+
+type DeleteResourceImageByBlobParams struct {
+	ResourceID int64
+	Image      string
+}
+
+func (q *Queries) DeleteResourceImageByBlob(ctx context.Context, arg DeleteResourceImageByBlobParams) error {
+	_, err := q.db.ExecContext(ctx, deleteResourceImageByBlob, arg.ResourceID, arg.Image)
+	return err
+}
+
+const deleteUnusedOperation = `-- name: DeleteUnusedOperation :exec
+delete from operation
+where id = ?1 and not exists (
+	select 1 from history where operation_id = ?1
+)
+`
+
+func (q *Queries) DeleteUnusedOperation(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUnusedOperation, id)
+	return err
+}
+
+const dequeueTag = `-- name: DequeueTag :exec
+delete from tag_queue
+where resource_id = ?
+`
+
+func (q *Queries) DequeueTag(ctx context.Context, resourceID int64) error {
+	_, err := q.db.ExecContext(ctx, dequeueTag, resourceID)
+	return err
+}
+
+const enqueueTag = `-- name: EnqueueTag :exec
+insert into tag_queue (resource_id, queued_at)
+values (?, unixepoch())
+on conflict (resource_id) do nothing
+`
+
+func (q *Queries) EnqueueTag(ctx context.Context, resourceID int64) error {
+	_, err := q.db.ExecContext(ctx, enqueueTag, resourceID)
+	return err
+}
+
+const getAttachment = `-- name: GetAttachment :one
+select id, resource_id, blob_id, filename, mime_type, size, created_at from attachment
+where id = ?
+`
+
+func (q *Queries) GetAttachment(ctx context.Context, id int64) (Attachment, error) {
+	row := q.db.QueryRowContext(ctx, getAttachment, id)
+	var i Attachment
+	err := row.Scan(
+		&i.ID,
+		&i.ResourceID,
+		&i.BlobID,
+		&i.Filename,
+		&i.MimeType,
+		&i.Size,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getHistory = `-- name: GetHistory :one
+select id, resource_id, changed_at, actor, old, new, operation_id from history
+where id = ?
+`
+
+func (q *Queries) GetHistory(ctx context.Context, id int64) (History, error) {
+	row := q.db.QueryRowContext(ctx, getHistory, id)
+	var i History
+	err := row.Scan(
+		&i.ID,
+		&i.ResourceID,
+		&i.ChangedAt,
+		&i.Actor,
+		&i.Old,
+		&i.New,
+		&i.OperationID,
+	)
+	return i, err
+}
+
+const getOperation = `-- name: GetOperation :one
+select id, description, created_at, undone_at from operation
+where id = ?
+`
+
+func (q *Queries) GetOperation(ctx context.Context, id int64) (Operation, error) {
+	row := q.db.QueryRowContext(ctx, getOperation, id)
+	var i Operation
+	err := row.Scan(
+		&i.ID,
+		&i.Description,
+		&i.CreatedAt,
+		&i.UndoneAt,
+	)
+	return i, err
+}
+
+const getPathAlias = `-- name: GetPathAlias :one
+select path_alias.path, path_alias.resource_id, path_alias.created_at from path_alias
+join resource on resource.id = path_alias.resource_id
+where
+	resource.trash_id is null and
+	(path_alias.path = ?1 or substr(?1, 1, length(path_alias.path) + 1) = path_alias.path || '/')
+order by length(path_alias.path) desc
+limit 1
+`
+
+func (q *Queries) GetPathAlias(ctx context.Context, path string) (PathAlias, error) {
+	row := q.db.QueryRowContext(ctx, getPathAlias, path)
+	var i PathAlias
+	err := row.Scan(&i.Path, &i.ResourceID, &i.CreatedAt)
+	return i, err
+}
+
+const getResource = `-- name: GetResource :one
+select id, parent_id, name, type, comments, image, created_at, updated_at, trash_id from resource
+where id = ? and trash_id is null
+`
+
+func (q *Queries) GetResource(ctx context.Context, id int64) (Resource, error) {
+	row := q.db.QueryRowContext(ctx, getResource, id)
+	var i Resource
+	err := row.Scan(
+		&i.ID,
+		&i.ParentID,
+		&i.Name,
+		&i.Type,
+		&i.Comments,
+		&i.Image,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TrashID,
+	)
+	return i, err
+}
+
+const getResourceByCode = `-- name: GetResourceByCode :one
+select resource_code.resource_id from resource_code
+join resource on resource.id = resource_code.resource_id
+where resource_code.code = ? and resource.trash_id is null
+`
+
+func (q *Queries) GetResourceByCode(ctx context.Context, code string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getResourceByCode, code)
+	var resource_id int64
+	err := row.Scan(&resource_id)
+	return resource_id, err
+}
+
+const getTrash = `-- name: GetTrash :one
+select id, resource_id, original_parent_id, original_path, deleted_at from trash
+where id = ?
+`
+
+func (q *Queries) GetTrash(ctx context.Context, id int64) (Trash, error) {
+	row := q.db.QueryRowContext(ctx, getTrash, id)
+	var i Trash
+	err := row.Scan(
+		&i.ID,
+		&i.ResourceID,
+		&i.OriginalParentID,
+		&i.OriginalPath,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const hasChildren = `-- name: HasChildren :one
+select exists (
+	select 1 from resource where parent_id = ?
+)
+`
+
+func (q *Queries) HasChildren(ctx context.Context, parentID sql.NullInt64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, hasChildren, parentID)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const indexAttachment = `-- name: IndexAttachment :exec
+insert into attachment_fts (rowid, content)
+values (?, ?)
+`
+
+type IndexAttachmentParams struct {
+	Rowid   int64
+	Content string
+}
+
+func (q *Queries) IndexAttachment(ctx context.Context, arg IndexAttachmentParams) error {
+	_, err := q.db.ExecContext(ctx, indexAttachment, arg.Rowid, arg.Content)
+	return err
+}
+
+const listAllAttachments = `-- name: ListAllAttachments :many
+select id, resource_id, blob_id, filename, mime_type, size, created_at from attachment
+order by id
+`
+
+func (q *Queries) ListAllAttachments(ctx context.Context) ([]Attachment, error) {
+	rows, err := q.db.QueryContext(ctx, listAllAttachments)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Attachment
+	for rows.Next() {
+		var i Attachment
+		if err := rows.Scan(
+			&i.ID,
+			&i.ResourceID,
+			&i.BlobID,
+			&i.Filename,
+			&i.MimeType,
+			&i.Size,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAttachmentBlobs = `-- name: ListAttachmentBlobs :many
+select distinct blob_id from attachment
+`
+
+func (q *Queries) ListAttachmentBlobs(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listAttachmentBlobs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var blob_id string
+		if err := rows.Scan(&blob_id); err != nil {
+			return nil, err
+		}
+		items = append(items, blob_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAttachments = `-- name: ListAttachments :many
+select id, resource_id, blob_id, filename, mime_type, size, created_at from attachment
+where resource_id = ?
+order by id
+`
+
+func (q *Queries) ListAttachments(ctx context.Context, resourceID int64) ([]Attachment, error) {
+	rows, err := q.db.QueryContext(ctx, listAttachments, resourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Attachment
+	for rows.Next() {
+		var i Attachment
+		if err := rows.Scan(
+			&i.ID,
+			&i.ResourceID,
+			&i.BlobID,
+			&i.Filename,
+			&i.MimeType,
+			&i.Size,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listHistory = `-- name: ListHistory :many
+select id, resource_id, changed_at, actor, old, new, operation_id from history
+where resource_id = ?
+order by id desc
+`
+
+func (q *Queries) ListHistory(ctx context.Context, resourceID int64) ([]History, error) {
+	rows, err := q.db.QueryContext(ctx, listHistory, resourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []History
+	for rows.Next() {
+		var i History
+		if err := rows.Scan(
+			&i.ID,
+			&i.ResourceID,
+			&i.ChangedAt,
+			&i.Actor,
+			&i.Old,
+			&i.New,
+			&i.OperationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listImageReferences = `-- name: ListImageReferences :many
+select id, image from resource
+where image is not null
+union
+select resource_id, image from resource_image
+`
+
+type ListImageReferencesRow struct {
+	ID    int64
+	Image sql.NullString
+}
+
+func (q *Queries) ListImageReferences(ctx context.Context) ([]ListImageReferencesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listImageReferences)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListImageReferencesRow
+	for rows.Next() {
+		var i ListImageReferencesRow
+		if err := rows.Scan(&i.ID, &i.Image); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listImages = `-- name: ListImages :many
+select image from resource
+where image is not null
+union
+select image from resource_image
+`
+
+func (q *Queries) ListImages(ctx context.Context) ([]sql.NullString, error) {
+	rows, err := q.db.QueryContext(ctx, listImages)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sql.NullString
+	for rows.Next() {
+		var image sql.NullString
+		if err := rows.Scan(&image); err != nil {
+			return nil, err
+		}
+		items = append(items, image)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOperationHistory = `-- name: ListOperationHistory :many
+select id, resource_id, changed_at, actor, old, new, operation_id from history
+where operation_id = ?
+order by id desc
+`
+
+func (q *Queries) ListOperationHistory(ctx context.Context, operationID sql.NullInt64) ([]History, error) {
+	rows, err := q.db.QueryContext(ctx, listOperationHistory, operationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []History
+	for rows.Next() {
+		var i History
+		if err := rows.Scan(
+			&i.ID,
+			&i.ResourceID,
+			&i.ChangedAt,
+			&i.Actor,
+			&i.Old,
+			&i.New,
+			&i.OperationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPathAliases = `-- name: ListPathAliases :many
+select path, resource_id, created_at from path_alias
+order by path
+`
+
+func (q *Queries) ListPathAliases(ctx context.Context) ([]PathAlias, error) {
+	rows, err := q.db.QueryContext(ctx, listPathAliases)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PathAlias
+	for rows.Next() {
+		var i PathAlias
+		if err := rows.Scan(&i.Path, &i.ResourceID, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRecentHistory = `-- name: ListRecentHistory :many
+select id, resource_id, changed_at, actor, old, new, operation_id from history
+order by id desc
+limit ?
+`
+
+func (q *Queries) ListRecentHistory(ctx context.Context, limit int64) ([]History, error) {
+	rows, err := q.db.QueryContext(ctx, listRecentHistory, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []History
+	for rows.Next() {
+		var i History
+		if err := rows.Scan(
+			&i.ID,
+			&i.ResourceID,
+			&i.ChangedAt,
+			&i.Actor,
+			&i.Old,
+			&i.New,
+			&i.OperationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listResourceImages = `-- name: ListResourceImages :many
+select id, resource_id, image, position, is_primary from resource_image
+where resource_id = ?
+order by position
 `
 
-func (q *Queries) DeleteResource(ctx context.Context, id int64) error {
-	_, err := q.db.ExecContext(ctx, deleteResource, id)
-	return err
+func (q *Queries) ListResourceImages(ctx context.Context, resourceID int64) ([]ResourceImage, error) {
+	rows, err := q.db.QueryContext(ctx, listResourceImages, resourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ResourceImage
+	for rows.Next() {
+		var i ResourceImage
+		if err := rows.Scan(
+			&i.ID,
+			&i.ResourceID,
+			&i.Image,
+			&i.Position,
+			&i.IsPrimary,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getResource = `-- name: GetResource :one
-select id, parent_id, name, type, comments, image from resource
-where id = ?
+const listResources = `-- name: ListResources :many
+select id, parent_id, name, type, comments, image, created_at, updated_at, trash_id from resource
+where parent_id is ? and trash_id is null
 `
 
-func (q *Queries) GetResource(ctx context.Context, id int64) (Resource, error) {
-	row := q.db.QueryRowContext(ctx, getResource, id)
-	var i Resource
-	err := row.Scan(
-		&i.ID,
-		&i.ParentID,
-		&i.Name,
-		&i.Type,
-		&i.Comments,
-		&i.Image,
-	)
-	return i, err
+func (q *Queries) ListResources(ctx context.Context, parentID sql.NullInt64) ([]Resource, error) {
+	rows, err := q.db.QueryContext(ctx, listResources, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Resource
+	for rows.Next() {
+		var i Resource
+		if err := rows.Scan(
+			&i.ID,
+			&i.ParentID,
+			&i.Name,
+			&i.Type,
+			&i.Comments,
+			&i.Image,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TrashID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const listResources = `-- name: ListResources :many
-select id, parent_id, name, type, comments, image from resource
-where parent_id is ?
+const listTagQueue = `-- name: ListTagQueue :many
+select resource.id, resource.parent_id, resource.name, resource.type, resource.comments, resource.image, resource.created_at, resource.updated_at, resource.trash_id from tag_queue
+join resource on resource.id = tag_queue.resource_id
+where resource.trash_id is null
+order by tag_queue.queued_at, tag_queue.resource_id
 `
 
-func (q *Queries) ListResources(ctx context.Context, parentID sql.NullInt64) ([]Resource, error) {
-	rows, err := q.db.QueryContext(ctx, listResources, parentID)
+func (q *Queries) ListTagQueue(ctx context.Context) ([]Resource, error) {
+	rows, err := q.db.QueryContext(ctx, listTagQueue)
 	if err != nil {
 		return nil, err
 	}
@@ -104,6 +849,64 @@ func (q *Queries) ListResources(ctx context.Context, parentID sql.NullInt64) ([]
 			&i.Type,
 			&i.Comments,
 			&i.Image,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TrashID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTrash = `-- name: ListTrash :many
+select
+	trash.id, trash.resource_id, trash.original_parent_id, trash.original_path, trash.deleted_at,
+	resource.name,
+	resource.type,
+	(select count(*) from resource r where r.trash_id = trash.id) as size
+from trash
+join resource on resource.id = trash.resource_id
+where exists (select 1 from resource r where r.trash_id = trash.id)
+order by trash.deleted_at desc
+`
+
+type ListTrashRow struct {
+	ID               int64
+	ResourceID       int64
+	OriginalParentID sql.NullInt64
+	OriginalPath     string
+	DeletedAt        int64
+	Name             string
+	Type             string
+	Size             int64
+}
+
+func (q *Queries) ListTrash(ctx context.Context) ([]ListTrashRow, error) {
+	rows, err := q.db.QueryContext(ctx, listTrash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTrashRow
+	for rows.Next() {
+		var i ListTrashRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.ResourceID,
+			&i.OriginalParentID,
+			&i.OriginalPath,
+			&i.DeletedAt,
+			&i.Name,
+			&i.Type,
+			&i.Size,
 		); err != nil {
 			return nil, err
 		}
@@ -118,10 +921,21 @@ func (q *Queries) ListResources(ctx context.Context, parentID sql.NullInt64) ([]
 	return items, nil
 }
 
+const markOperationUndone = `-- name: MarkOperationUndone :exec
+update operation
+set undone_at = unixepoch()
+where id = ?
+`
+
+func (q *Queries) MarkOperationUndone(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, markOperationUndone, id)
+	return err
+}
+
 const moveResources = `-- name: MoveResources :many
 update resource
-set parent_id = ?1
-where id in (/*SLICE:ids*/?)
+set parent_id = ?1, updated_at = unixepoch()
+where id in (/*SLICE:ids*/?) and trash_id is null
 returning id
 `
 
@@ -164,13 +978,249 @@ func (q *Queries) MoveResources(ctx context.Context, arg MoveResourcesParams) ([
 	return items, nil
 }
 
-const updateResource = `-- name: UpdateResource :many
+const purgeTrash = `-- name: PurgeTrash :many
+delete from trash
+where deleted_at < ?1
+returning id
+`
+
+func (q *Queries) PurgeTrash(ctx context.Context, before int64) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, purgeTrash, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const replaceHistoryImage = `-- name: ReplaceHistoryImage :exec
+update history
+set
+	old = iif(old ->> 'image' = ?1, json_set(old, '$.image', ?2), old),
+	new = iif(new ->> 'image' = ?1, json_set(new, '$.image', ?2), new)
+where old ->> 'image' = ?1 or new ->> 'image' = ?1
+`
+
+type ReplaceHistoryImageParams struct {
+	OldImage string
+	NewImage string
+}
+
+func (q *Queries) ReplaceHistoryImage(ctx context.Context, arg ReplaceHistoryImageParams) error {
+	_, err := q.db.ExecContext(ctx, replaceHistoryImage, arg.OldImage, arg.NewImage)
+	return err
+}
+
+const replaceImage = `-- name: ReplaceImage :exec
+update resource
+set image = ?1
+where image = ?2
+`
+
+type ReplaceImageParams struct {
+	NewImage sql.NullString
+	OldImage sql.NullString
+}
+
+func (q *Queries) ReplaceImage(ctx context.Context, arg ReplaceImageParams) error {
+	_, err := q.db.ExecContext(ctx, replaceImage, arg.NewImage, arg.OldImage)
+	return err
+}
+
+const replaceResourceImage = `-- name: ReplaceResourceImage :exec
+update resource_image
+set image = ?1
+where image = ?2
+`
+
+type ReplaceResourceImageParams struct {
+	NewImage string
+	OldImage string
+}
+
+func (q *Queries) ReplaceResourceImage(ctx context.Context, arg ReplaceResourceImageParams) error {
+	_, err := q.db.ExecContext(ctx, replaceResourceImage, arg.NewImage, arg.OldImage)
+	return err
+}
+
+const restoreTrash = `-- name: RestoreTrash :execrows
+update resource
+set
+	trash_id = null,
+	parent_id = iif(id = ?1, ?2, parent_id),
+	updated_at = iif(id = ?1, unixepoch(), updated_at)
+where trash_id = ?3
+`
+
+type RestoreTrashParams struct {
+	ResourceID int64
+	NewParent  sql.NullInt64
+	TrashID    sql.NullInt64
+}
+
+func (q *Queries) RestoreTrash(ctx context.Context, arg RestoreTrashParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, restoreTrash, arg.ResourceID, arg.NewParent, arg.TrashID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revertResource = `-- name: RevertResource :many
+update resource
+set
+	parent_id = ?,
+	name = ?,
+	type = ?,
+	comments = ?,
+	image = ?,
+	updated_at = unixepoch()
+where id = ? and trash_id is null
+returning id
+`
+
+type RevertResourceParams struct {
+	ParentID sql.NullInt64
+	Name     string
+	Type     string
+	Comments string
+	Image    sql.NullString
+	ID       int64
+}
+
+func (q *Queries) RevertResource(ctx context.Context, arg RevertResourceParams) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, revertResource,
+		arg.ParentID,
+		arg.Name,
+		arg.Type,
+		arg.Comments,
+		arg.Image,
+		arg.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setChangeContext = `-- name: SetChangeContext :exec
+insert into change_context (id, actor, operation_id)
+values (1, ?, ?)
+on conflict (id) do update set
+	actor = excluded.actor,
+	operation_id = excluded.operation_id
+`
+
+type SetChangeContextParams struct {
+	Actor       string
+	OperationID sql.NullInt64
+}
+
+func (q *Queries) SetChangeContext(ctx context.Context, arg SetChangeContextParams) error {
+	_, err := q.db.ExecContext(ctx, setChangeContext, arg.Actor, arg.OperationID)
+	return err
+}
+
+const setResourceImagePosition = `-- name: SetResourceImagePosition :exec
+update resource_image
+set position = ?
+where id = ?
+`
+
+type SetResourceImagePositionParams struct {
+	Position int64
+	ID       int64
+}
+
+func (q *Queries) SetResourceImagePosition(ctx context.Context, arg SetResourceImagePositionParams) error {
+	_, err := q.db.ExecContext(ctx, setResourceImagePosition, arg.Position, arg.ID)
+	return err
+}
+
+const touchResource = `-- name: TouchResource :exec
+update resource
+set updated_at = unixepoch()
+where id = ?
+`
+
+func (q *Queries) TouchResource(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, touchResource, id)
+	return err
+}
+
+const undoResource = `-- name: UndoResource :exec
 update resource
 set
+	parent_id = ?,
 	name = ?,
 	type = ?,
-	comments = ?
+	comments = ?,
+	image = ?,
+	trash_id = ?,
+	updated_at = unixepoch()
 where id = ?
+`
+
+type UndoResourceParams struct {
+	ParentID sql.NullInt64
+	Name     string
+	Type     string
+	Comments string
+	Image    sql.NullString
+	TrashID  sql.NullInt64
+	ID       int64
+}
+
+func (q *Queries) UndoResource(ctx context.Context, arg UndoResourceParams) error {
+	_, err := q.db.ExecContext(ctx, undoResource,
+		arg.ParentID,
+		arg.Name,
+		arg.Type,
+		arg.Comments,
+		arg.Image,
+		arg.TrashID,
+		arg.ID,
+	)
+	return err
+}
+
+const updateResource = `-- name: UpdateResource :many
+update resource
+set
+	name = ?,
+	type = ?,
+	comments = ?,
+	updated_at = unixepoch()
+where id = ? and trash_id is null
 returning id
 `
 
@@ -211,13 +1261,13 @@ func (q *Queries) UpdateResource(ctx context.Context, arg UpdateResourceParams)
 
 const updateResourceImage = `-- name: UpdateResourceImage :many
 update resource
-set image = ?
-where id = ?
+set image = ?, updated_at = unixepoch()
+where id = ? and trash_id is null
 returning id
 `
 
 type UpdateResourceImageParams struct {
-	Image sql.NullInt64
+	Image sql.NullString
 	ID    int64
 }
 
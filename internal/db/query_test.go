@@ -4,9 +4,11 @@ import (
 	"database/sql"
 	"errors"
 	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"pgregory.net/rapid"
@@ -43,12 +45,24 @@ func (doesntExistErr) Error() string {
 	return "row doesn't exist"
 }
 
+type cycleErr struct{}
+
+func (cycleErr) Error() string {
+	return "parent cycle"
+}
+
 type fkeyErr struct{}
 
 func (fkeyErr) Error() string {
 	return "foreign key constraint violated"
 }
 
+type nameTakenErr struct{}
+
+func (nameTakenErr) Error() string {
+	return "name already taken by a sibling"
+}
+
 type oracle struct {
 	resources map[int64]Resource
 	count     *int64
@@ -62,6 +76,20 @@ func newOracle() oracle {
 	}
 }
 
+// nameTaken reports whether a resource other than the given one already has
+// the name under the parent
+func (o oracle) nameTaken(id int64, parentID sql.NullInt64, name string, except map[int64]struct{}) bool {
+	for _, r := range o.resources {
+		if _, ok := except[r.ID]; ok || r.ID == id {
+			continue
+		}
+		if r.ParentID == parentID && r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (o oracle) createResource(r Resource) (err error) {
 	if r.ID == 0 {
 		r.ID = *o.count
@@ -76,6 +104,10 @@ func (o oracle) createResource(r Resource) (err error) {
 		err = alreadyExistsErr{}
 		return
 	}
+	if o.nameTaken(r.ID, r.ParentID, r.Name, nil) {
+		err = nameTakenErr{}
+		return
+	}
 	if r.ParentID.Valid {
 		_, ok := o.resources[r.ParentID.Int64]
 		if !ok && r.ParentID.Int64 != r.ID {
@@ -87,16 +119,19 @@ func (o oracle) createResource(r Resource) (err error) {
 	return
 }
 
-func (o oracle) updateResource(r UpdateResourceParams) []int64 {
+func (o oracle) updateResource(r UpdateResourceParams) ([]int64, error) {
 	existing, ok := o.resources[r.ID]
 	if !ok {
-		return nil
+		return nil, nil
+	}
+	if o.nameTaken(r.ID, existing.ParentID, r.Name, nil) {
+		return nil, nameTakenErr{}
 	}
 	existing.Name = r.Name
 	existing.Comments = r.Comments
 	existing.Type = r.Type
 	o.resources[r.ID] = existing
-	return []int64{r.ID}
+	return []int64{r.ID}, nil
 }
 
 func (o oracle) updateResourceImage(r UpdateResourceImageParams) []int64 {
@@ -117,6 +152,24 @@ func (o oracle) moveResources(params MoveResourcesParams) (updated []int64, err
 	for _, id := range params.Ids {
 		values[id] = struct{}{}
 	}
+	// the moved resources may neither share a name with each other nor with
+	// the resources already in the destination
+	names := make(map[string]struct{})
+	for id := range values {
+		existing, ok := o.resources[id]
+		if !ok {
+			continue
+		}
+		if _, ok := names[existing.Name]; ok {
+			err = nameTakenErr{}
+			return
+		}
+		names[existing.Name] = struct{}{}
+		if o.nameTaken(id, params.NewParent, existing.Name, values) {
+			err = nameTakenErr{}
+			return
+		}
+	}
 	for id := range values {
 		existing, ok := o.resources[id]
 		if !ok {
@@ -137,6 +190,14 @@ func (o oracle) moveResources(params MoveResourcesParams) (updated []int64, err
 }
 
 func (o oracle) changeParent(params ChangeParentParams) (err error) {
+	if params.OldParent != params.NewParent {
+		for _, r := range o.resources {
+			if r.ParentID == params.OldParent && o.nameTaken(r.ID, params.NewParent, r.Name, nil) {
+				err = nameTakenErr{}
+				return
+			}
+		}
+	}
 	for _, r := range o.resources {
 		if r.ParentID == params.OldParent {
 			if params.NewParent.Valid {
@@ -211,7 +272,14 @@ func (o oracle) getPath(id int64) (p []string, err error) {
 		err = doesntExistErr{}
 		return
 	}
+	visited := make(map[int64]struct{})
 	for {
+		if _, ok := visited[existing.ID]; ok {
+			p = nil
+			err = cycleErr{}
+			return
+		}
+		visited[existing.ID] = struct{}{}
 		p = append([]string{existing.Name}, p...)
 		if !existing.ParentID.Valid {
 			return
@@ -224,6 +292,16 @@ func (o oracle) getPath(id int64) (p []string, err error) {
 	}
 }
 
+// withoutTimestamps checks that the timestamps of a resource are set and clears
+// them, the oracle does not model time
+func withoutTimestamps(t *rapid.T, r Resource) Resource {
+	require.NotZero(t, r.CreatedAt)
+	require.GreaterOrEqual(t, r.UpdatedAt, r.CreatedAt)
+	r.CreatedAt = 0
+	r.UpdatedAt = 0
+	return r
+}
+
 func TestDB(t *testing.T) {
 	rapid.Check(t, func(t *rapid.T) {
 		// ensure db is cleared
@@ -243,7 +321,11 @@ func TestDB(t *testing.T) {
 			t.Fatal("failed to remove testing.db-shm")
 		}
 
-		_, qry, err := Open(t.Context(), "testing.db", "")
+		driver, qry, err := Open(t.Context(), "testing.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Migrate(t.Context(), driver)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -256,6 +338,9 @@ func TestDB(t *testing.T) {
 			if err != nil {
 				t.Fatal("(real) unexpected error:", err)
 			}
+			for i, r := range resourcesReal {
+				resourcesReal[i] = withoutTimestamps(t, r)
+			}
 			resourcesModel := model.listResources(sql.NullInt64{})
 			slices.SortFunc(resourcesReal, func(a, b Resource) int {
 				return int(a.ID - b.ID)
@@ -278,10 +363,10 @@ func TestDB(t *testing.T) {
 				name := rapid.String().Draw(t, "name")
 				typeStr := rapid.SampledFrom(types).Draw(t, "type")
 				comments := rapid.String().Draw(t, "comments")
-				imageValue := rapid.Int64Min(0).Draw(t, "image")
-				image := sql.NullInt64{Int64: imageValue, Valid: true}
-				if imageValue == 0 {
-					image.Valid = false
+				var image sql.NullString
+				if rapid.Bool().Draw(t, "hasImage") {
+					image.String = rapid.StringMatching(`sha256-[0-9a-f]{64}`).Draw(t, "image")
+					image.Valid = true
 				}
 
 				_, errReal := qry.CreateResource(t.Context(), CreateResourceParams{
@@ -299,6 +384,10 @@ func TestDB(t *testing.T) {
 					Comments: comments,
 				})
 				if errModel != nil {
+					if errors.Is(errModel, nameTakenErr{}) {
+						require.True(t, IsNameTaken(errReal), "real error: %v", errReal)
+						return
+					}
 					if errors.Is(errModel, alreadyExistsErr{}) {
 						require.ErrorContains(t, errReal, "conflict")
 						return
@@ -328,7 +417,11 @@ func TestDB(t *testing.T) {
 					Comments: comments,
 				}
 				updatedReal, err := qry.UpdateResource(t.Context(), params)
-				updatedModel := model.updateResource(params)
+				updatedModel, errModel := model.updateResource(params)
+				if errModel != nil {
+					require.True(t, IsNameTaken(err), "real error: %v", err)
+					return
+				}
 				if err != nil {
 					t.Fatal("(real) unexpected error:", err)
 				}
@@ -349,14 +442,14 @@ func TestDB(t *testing.T) {
 					t.Fatal("(model) unexpected error:", err)
 				}
 
-				require.Equal(t, modelResource, realResource)
+				require.Equal(t, modelResource, withoutTimestamps(t, realResource))
 			},
 			"UpdateResourceImage": func(t *rapid.T) {
 				id := rapid.Int64Min(1).Draw(t, "id")
-				imageValue := rapid.Int64().Draw(t, "image")
-				image := sql.NullInt64{Int64: imageValue, Valid: true}
-				if imageValue == 0 {
-					image.Valid = false
+				var image sql.NullString
+				if rapid.Bool().Draw(t, "hasImage") {
+					image.String = rapid.StringMatching(`sha256-[0-9a-f]{64}`).Draw(t, "image")
+					image.Valid = true
 				}
 
 				params := UpdateResourceImageParams{
@@ -385,7 +478,7 @@ func TestDB(t *testing.T) {
 					t.Fatal("(model) unexpected error:", err)
 				}
 
-				require.Equal(t, modelResource, realResource)
+				require.Equal(t, modelResource, withoutTimestamps(t, realResource))
 			},
 			"MoveResources": func(t *rapid.T) {
 				ids := rapid.SliceOf(rapid.Int64Min(1)).Draw(t, "ids")
@@ -401,6 +494,10 @@ func TestDB(t *testing.T) {
 				}
 				updatedReal, errReal := qry.MoveResources(t.Context(), params)
 				updatedModel, errModel := model.moveResources(params)
+				if errors.Is(errModel, nameTakenErr{}) {
+					require.True(t, IsNameTaken(errReal), "real error: %v", errReal)
+					return
+				}
 				if errModel != nil {
 					require.ErrorContains(t, errReal, "FOREIGN KEY", "model error: %v", errModel)
 					return
@@ -432,6 +529,10 @@ func TestDB(t *testing.T) {
 				}
 				errReal := qry.ChangeParent(t.Context(), params)
 				errModel := model.changeParent(params)
+				if errors.Is(errModel, nameTakenErr{}) {
+					require.True(t, IsNameTaken(errReal), "real error: %v", errReal)
+					return
+				}
 				if errModel != nil {
 					require.ErrorContains(t, errReal, "FOREIGN KEY", "model error: %v", errModel)
 					return
@@ -476,6 +577,10 @@ func TestDB(t *testing.T) {
 
 				pathReal, errReal := qry.GetPath(t.Context(), id)
 				pathModel, errModel := model.getPath(id)
+				if errors.Is(errModel, cycleErr{}) {
+					require.ErrorIs(t, errReal, ErrCycle)
+					return
+				}
 				if len(pathReal) == len(pathModel) && len(pathReal) == 0 {
 					assertResourceStateEqual(t)
 					return
@@ -494,3 +599,447 @@ func TestDB(t *testing.T) {
 		})
 	})
 }
+
+// newTestDB returns a migrated database in a temporary directory, it is closed
+// when the test ends
+func newTestDB(t *testing.T) (*sql.DB, *Queries) {
+	t.Helper()
+	driver, qry, err := Open(t.Context(), filepath.Join(t.TempDir(), "state.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { driver.Close() })
+	_, err = Migrate(t.Context(), driver)
+	require.NoError(t, err)
+	return driver, qry
+}
+
+// newTestResource creates a resource and returns its id, failing the test if
+// that is not possible
+func newTestResource(t *testing.T, qry *Queries, params CreateResourceParams) int64 {
+	t.Helper()
+	id, err := qry.CreateResource(t.Context(), params)
+	require.NoError(t, err)
+	return id
+}
+
+func TestTrash(t *testing.T) {
+	ctx := t.Context()
+	driver, qry := newTestDB(t)
+
+	create := func(parentID sql.NullInt64, name string) sql.NullInt64 {
+		id := newTestResource(t, qry, CreateResourceParams{
+			ParentID: parentID,
+			Name:     name,
+			Type:     "container",
+		})
+		return sql.NullInt64{Int64: id, Valid: true}
+	}
+	a := create(sql.NullInt64{}, "a")
+	b := create(a, "b")
+	c := create(b, "c")
+
+	trashID, err := qry.CreateTrash(ctx, CreateTrashParams{
+		ResourceID:       b.Int64,
+		OriginalParentID: a,
+		OriginalPath:     "/a/b",
+	})
+	require.NoError(t, err)
+	n, err := qry.TrashResource(ctx, TrashResourceParams{ID: b.Int64, TrashID: trashID})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+
+	// trashed resources are hidden from every read
+	children, err := qry.ListResources(ctx, a)
+	require.NoError(t, err)
+	require.Empty(t, children)
+	roots, err := qry.ListResources(ctx, sql.NullInt64{})
+	require.NoError(t, err)
+	require.Len(t, roots, 1)
+	_, err = qry.GetResource(ctx, c.Int64)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = qry.Resolve(ctx, "/a/b/c")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = qry.Resolve(ctx, "/b")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// restoring makes the whole subtree visible again, the root does not
+	// collide with a resource of the same name at the top level
+	create(sql.NullInt64{}, "b")
+	n, err = qry.RestoreTrash(ctx, RestoreTrashParams{
+		ResourceID: b.Int64,
+		NewParent:  a,
+		TrashID:    sql.NullInt64{Int64: trashID, Valid: true},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+	found, err := qry.Resolve(ctx, "/a/b/c")
+	require.NoError(t, err)
+	require.Equal(t, c, found)
+
+	// restored entries are no longer listed
+	entries, err := qry.ListTrash(ctx)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// purging deletes the subtree permanently
+	restoredID := trashID
+	trashID, err = qry.CreateTrash(ctx, CreateTrashParams{
+		ResourceID:       b.Int64,
+		OriginalParentID: a,
+		OriginalPath:     "/a/b",
+	})
+	require.NoError(t, err)
+	_, err = qry.TrashResource(ctx, TrashResourceParams{ID: b.Int64, TrashID: trashID})
+	require.NoError(t, err)
+	purged, err := qry.PurgeTrash(ctx, time.Now().Add(time.Minute).Unix())
+	require.NoError(t, err)
+	require.ElementsMatch(t, []int64{restoredID, trashID}, purged)
+	var count int
+	err = driver.QueryRowContext(ctx, "select count(*) from resource").Scan(&count)
+	require.NoError(t, err)
+	// only a and the other b are left
+	require.Equal(t, 2, count)
+
+	// a shallow trash leaves the children in place, so a child may take the
+	// name of its trashed parent
+	d := create(a, "d")
+	e := create(d, "d")
+	trashID, err = qry.CreateTrash(ctx, CreateTrashParams{
+		ResourceID:       d.Int64,
+		OriginalParentID: a,
+		OriginalPath:     "/a/d",
+	})
+	require.NoError(t, err)
+	n, err = qry.TrashResource(ctx, TrashResourceParams{ID: d.Int64, TrashID: trashID, Shallow: true})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+	err = qry.ChangeParent(ctx, ChangeParentParams{OldParent: d, NewParent: a})
+	require.NoError(t, err)
+	found, err = qry.Resolve(ctx, "/a/d")
+	require.NoError(t, err)
+	require.Equal(t, e, found)
+}
+
+func TestHistory(t *testing.T) {
+	ctx := t.Context()
+	_, qry := newTestDB(t)
+
+	err := qry.SetChangeContext(ctx, SetChangeContextParams{Actor: "test"})
+	require.NoError(t, err)
+	id := newTestResource(t, qry, CreateResourceParams{Name: "a", Type: "item"})
+	_, err = qry.UpdateResource(ctx, UpdateResourceParams{ID: id, Name: "b", Type: "item", Comments: "c"})
+	require.NoError(t, err)
+	// updates which do not change anything are not recorded
+	_, err = qry.UpdateResource(ctx, UpdateResourceParams{ID: id, Name: "b", Type: "item", Comments: "c"})
+	require.NoError(t, err)
+	err = qry.DeleteResource(ctx, id)
+	require.NoError(t, err)
+
+	history, err := qry.ListHistory(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	for _, h := range history {
+		require.Equal(t, sql.NullString{String: "test", Valid: true}, h.Actor)
+	}
+
+	// newest first
+	before, after, err := history[0].Snapshots()
+	require.NoError(t, err)
+	require.Equal(t, "b", before.Name)
+	require.Nil(t, after)
+
+	before, after, err = history[1].Snapshots()
+	require.NoError(t, err)
+	require.Equal(t, &HistorySnapshot{Name: "a", Type: "item"}, before)
+	require.Equal(t, &HistorySnapshot{Name: "b", Type: "item", Comments: "c"}, after)
+
+	before, after, err = history[2].Snapshots()
+	require.NoError(t, err)
+	require.Nil(t, before)
+	require.Equal(t, "a", after.Name)
+}
+
+func TestOperation(t *testing.T) {
+	ctx := t.Context()
+	_, qry := newTestDB(t)
+
+	op, err := qry.CreateOperation(ctx, "test")
+	require.NoError(t, err)
+	opID := sql.NullInt64{Int64: op, Valid: true}
+	err = qry.SetChangeContext(ctx, SetChangeContextParams{Actor: "test", OperationID: opID})
+	require.NoError(t, err)
+	a := newTestResource(t, qry, CreateResourceParams{Name: "a", Type: "item"})
+	b := newTestResource(t, qry, CreateResourceParams{Name: "b", Type: "item"})
+
+	history, err := qry.ListOperationHistory(ctx, opID)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, b, history[0].ResourceID)
+	require.Equal(t, a, history[1].ResourceID)
+
+	// operations which changed something are kept
+	err = qry.DeleteUnusedOperation(ctx, op)
+	require.NoError(t, err)
+	_, err = qry.GetOperation(ctx, op)
+	require.NoError(t, err)
+
+	unused, err := qry.CreateOperation(ctx, "unused")
+	require.NoError(t, err)
+	err = qry.DeleteUnusedOperation(ctx, unused)
+	require.NoError(t, err)
+	_, err = qry.GetOperation(ctx, unused)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	changed, err := qry.CountChangesAfterOperation(ctx, opID)
+	require.NoError(t, err)
+	require.Zero(t, changed)
+
+	// later changes to the same resources are counted, changes to other
+	// resources are not
+	err = qry.SetChangeContext(ctx, SetChangeContextParams{Actor: "test"})
+	require.NoError(t, err)
+	newTestResource(t, qry, CreateResourceParams{Name: "c", Type: "item"})
+	changed, err = qry.CountChangesAfterOperation(ctx, opID)
+	require.NoError(t, err)
+	require.Zero(t, changed)
+	_, err = qry.UpdateResource(ctx, UpdateResourceParams{ID: a, Name: "a2", Type: "item"})
+	require.NoError(t, err)
+	changed, err = qry.CountChangesAfterOperation(ctx, opID)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), changed)
+
+	err = qry.MarkOperationUndone(ctx, op)
+	require.NoError(t, err)
+	operation, err := qry.GetOperation(ctx, op)
+	require.NoError(t, err)
+	require.True(t, operation.UndoneAt.Valid)
+}
+
+func TestPathAlias(t *testing.T) {
+	ctx := t.Context()
+	_, qry := newTestDB(t)
+
+	a := newTestResource(t, qry, CreateResourceParams{Name: "a", Type: "container"})
+	b := newTestResource(t, qry, CreateResourceParams{Name: "b", Type: "container"})
+	err := qry.CreatePathAlias(ctx, CreatePathAliasParams{Path: "/old", ResourceID: a})
+	require.NoError(t, err)
+	err = qry.CreatePathAlias(ctx, CreatePathAliasParams{Path: "/old/inner", ResourceID: b})
+	require.NoError(t, err)
+
+	// the longest alias which is a prefix of whole segments is used
+	alias, err := qry.GetPathAlias(ctx, "/old/inner/c")
+	require.NoError(t, err)
+	require.Equal(t, b, alias.ResourceID)
+	alias, err = qry.GetPathAlias(ctx, "/old/innermost")
+	require.NoError(t, err)
+	require.Equal(t, a, alias.ResourceID)
+	alias, err = qry.GetPathAlias(ctx, "/old")
+	require.NoError(t, err)
+	require.Equal(t, a, alias.ResourceID)
+	_, err = qry.GetPathAlias(ctx, "/older")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// aliases of trashed resources are ignored
+	trashID, err := qry.CreateTrash(ctx, CreateTrashParams{ResourceID: b, OriginalPath: "/b"})
+	require.NoError(t, err)
+	_, err = qry.TrashResource(ctx, TrashResourceParams{ID: b, TrashID: trashID})
+	require.NoError(t, err)
+	alias, err = qry.GetPathAlias(ctx, "/old/inner/c")
+	require.NoError(t, err)
+	require.Equal(t, a, alias.ResourceID)
+
+	// aliases are deleted along with their resource
+	err = qry.DeleteResource(ctx, a)
+	require.NoError(t, err)
+	aliases, err := qry.ListPathAliases(ctx)
+	require.NoError(t, err)
+	require.Len(t, aliases, 1)
+	require.Equal(t, "/old/inner", aliases[0].Path)
+}
+
+func TestTagQueue(t *testing.T) {
+	ctx := t.Context()
+	_, qry := newTestDB(t)
+
+	a := newTestResource(t, qry, CreateResourceParams{Name: "Untitled 1", Type: "item"})
+	b := newTestResource(t, qry, CreateResourceParams{Name: "Untitled 2", Type: "item"})
+	require.NoError(t, qry.EnqueueTag(ctx, a))
+	require.NoError(t, qry.EnqueueTag(ctx, b))
+	// queueing a resource twice keeps a single entry
+	require.NoError(t, qry.EnqueueTag(ctx, a))
+
+	queue, err := qry.ListTagQueue(ctx)
+	require.NoError(t, err)
+	require.Len(t, queue, 2)
+	require.Equal(t, a, queue[0].ID)
+	require.Equal(t, b, queue[1].ID)
+
+	// trashed resources are not tagged
+	trashID, err := qry.CreateTrash(ctx, CreateTrashParams{ResourceID: b, OriginalPath: "/Untitled 2"})
+	require.NoError(t, err)
+	_, err = qry.TrashResource(ctx, TrashResourceParams{ID: b, TrashID: trashID})
+	require.NoError(t, err)
+	queue, err = qry.ListTagQueue(ctx)
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+
+	require.NoError(t, qry.DequeueTag(ctx, a))
+	queue, err = qry.ListTagQueue(ctx)
+	require.NoError(t, err)
+	require.Empty(t, queue)
+}
+
+func TestResourceImage(t *testing.T) {
+	ctx := t.Context()
+	_, qry := newTestDB(t)
+
+	gallery := func(id int64) (images []string, primary string) {
+		list, err := qry.ListResourceImages(ctx, id)
+		require.NoError(t, err)
+		for _, img := range list {
+			images = append(images, img.Image)
+			if img.IsPrimary {
+				require.Empty(t, primary, "more than one primary image")
+				primary = img.Image
+			}
+		}
+		r, err := qry.GetResource(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, primary, r.Image.String)
+		return
+	}
+
+	// the image of a new resource is its primary image
+	id := newTestResource(t, qry, CreateResourceParams{
+		Name:  "a",
+		Type:  "item",
+		Image: sql.NullString{String: "front", Valid: true},
+	})
+	images, primary := gallery(id)
+	require.Equal(t, []string{"front"}, images)
+	require.Equal(t, "front", primary)
+
+	// added images are appended and do not change the primary image
+	require.NoError(t, qry.CreateResourceImage(ctx, CreateResourceImageParams{ResourceID: id, Image: "back"}))
+	require.NoError(t, qry.CreateResourceImage(ctx, CreateResourceImageParams{ResourceID: id, Image: "receipt"}))
+	require.NoError(t, qry.CreateResourceImage(ctx, CreateResourceImageParams{ResourceID: id, Image: "back"}))
+	images, primary = gallery(id)
+	require.Equal(t, []string{"front", "back", "receipt"}, images)
+	require.Equal(t, "front", primary)
+
+	// changing the image of the resource changes the primary image
+	_, err := qry.UpdateResourceImage(ctx, UpdateResourceImageParams{ID: id, Image: sql.NullString{String: "back", Valid: true}})
+	require.NoError(t, err)
+	images, primary = gallery(id)
+	require.Equal(t, []string{"front", "back", "receipt"}, images)
+	require.Equal(t, "back", primary)
+
+	// images which are not part of the gallery yet are appended, ex. when
+	// reverting to an earlier version
+	_, err = qry.UpdateResourceImage(ctx, UpdateResourceImageParams{ID: id, Image: sql.NullString{String: "old", Valid: true}})
+	require.NoError(t, err)
+	images, primary = gallery(id)
+	require.Equal(t, []string{"front", "back", "receipt", "old"}, images)
+	require.Equal(t, "old", primary)
+
+	// removing the primary image makes the first image primary
+	list, err := qry.ListResourceImages(ctx, id)
+	require.NoError(t, err)
+	require.NoError(t, qry.DeleteResourceImage(ctx, list[3].ID))
+	images, primary = gallery(id)
+	require.Equal(t, []string{"front", "back", "receipt"}, images)
+	require.Equal(t, "front", primary)
+
+	require.NoError(t, qry.SetResourceImagePosition(ctx, SetResourceImagePositionParams{ID: list[0].ID, Position: list[2].Position}))
+	require.NoError(t, qry.SetResourceImagePosition(ctx, SetResourceImagePositionParams{ID: list[2].ID, Position: list[0].Position}))
+	require.NoError(t, qry.DeleteResourceImageByBlob(ctx, DeleteResourceImageByBlobParams{ResourceID: id, Image: "front"}))
+	images, primary = gallery(id)
+	require.Equal(t, []string{"receipt", "back"}, images)
+	require.Equal(t, "receipt", primary)
+
+	// the gallery is deleted along with the resource
+	require.NoError(t, qry.DeleteResource(ctx, id))
+	list, err = qry.ListResourceImages(ctx, id)
+	require.NoError(t, err)
+	require.Empty(t, list)
+}
+
+func TestAttachment(t *testing.T) {
+	ctx := t.Context()
+	_, qry := newTestDB(t)
+
+	a := newTestResource(t, qry, CreateResourceParams{Name: "a", Type: "item"})
+	b := newTestResource(t, qry, CreateResourceParams{Name: "b", Type: "item"})
+	receipt, err := qry.CreateAttachment(ctx, CreateAttachmentParams{
+		ResourceID: a,
+		BlobID:     "receipt",
+		Filename:   "receipt.pdf",
+		MimeType:   "application/pdf",
+		Size:       1024,
+	})
+	require.NoError(t, err)
+	_, err = qry.CreateAttachment(ctx, CreateAttachmentParams{
+		ResourceID: b,
+		BlobID:     "receipt",
+		Filename:   "same receipt.pdf",
+		MimeType:   "application/pdf",
+		Size:       1024,
+	})
+	require.NoError(t, err)
+
+	attachment, err := qry.GetAttachment(ctx, receipt)
+	require.NoError(t, err)
+	require.Equal(t, "receipt.pdf", attachment.Filename)
+	attachments, err := qry.ListAttachments(ctx, a)
+	require.NoError(t, err)
+	require.Equal(t, []Attachment{attachment}, attachments)
+	blobs, err := qry.ListAttachmentBlobs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"receipt"}, blobs)
+
+	// attachments are deleted along with their resource
+	require.NoError(t, qry.DeleteResource(ctx, a))
+	all, err := qry.ListAllAttachments(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	require.Equal(t, b, all[0].ResourceID)
+}
+
+func TestSearchAttachments(t *testing.T) {
+	ctx := t.Context()
+	_, qry := newTestDB(t)
+
+	drill := newTestResource(t, qry, CreateResourceParams{Name: "drill", Type: "item"})
+	saw := newTestResource(t, qry, CreateResourceParams{Name: "saw", Type: "item", Comments: "model XJ-900"})
+	attach := func(resourceID int64, filename, text string) int64 {
+		id, err := qry.CreateAttachment(ctx, CreateAttachmentParams{
+			ResourceID: resourceID,
+			BlobID:     filename,
+			Filename:   filename,
+			MimeType:   "text/plain",
+		})
+		require.NoError(t, err)
+		require.NoError(t, qry.IndexAttachment(ctx, IndexAttachmentParams{Rowid: id, Content: text}))
+		return id
+	}
+	manual := attach(drill, "manual.txt", "Cordless drill, model XJ-900")
+	attach(saw, "receipt.txt", "1x XJ-900 saw")
+
+	results, _, err := qry.Search(ctx, SearchQuery{Terms: []string{"XJ-900"}}, -1, 0)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	byID := map[int64]SearchRow{}
+	for _, r := range results {
+		byID[r.ID] = r
+	}
+	// the drill only matches through its manual
+	require.Equal(t, manual, byID[drill].AttachmentID.Int64)
+	require.Equal(t, "manual.txt", byID[drill].AttachmentName.String)
+	// the saw matches itself, which is preferred over its receipt
+	require.False(t, byID[saw].AttachmentID.Valid)
+
+	// the text is removed from the index along with the attachment
+	require.NoError(t, qry.DeleteAttachment(ctx, manual))
+	results, _, err = qry.Search(ctx, SearchQuery{Terms: []string{"Cordless"}}, -1, 0)
+	require.NoError(t, err)
+	require.Empty(t, results)
+}
@@ -0,0 +1,574 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SearchFilter restricts the results of a search to the resources matching
+// it, or to the resources not matching it if it is negated
+type SearchFilter struct {
+	// one of "type", "in" or "has"
+	Key     string
+	Value   string
+	Negated bool
+}
+
+// SearchQuery is a search parsed by ParseSearch. resources match it if they
+// contain all of its terms, none of its excluded terms and match all of its
+// filters.
+type SearchQuery struct {
+	Terms    []string
+	Excluded []string
+	Filters  []SearchFilter
+	// restricts the results to the descendants of this resource if set
+	Ancestor sql.NullInt64
+}
+
+// ErrUnknownContainer is returned by Search if the path of an "in:" filter
+// does not exist
+var ErrUnknownContainer = errors.New("unknown container")
+
+// searchHas maps the values of "has:" to the condition a resource must meet
+var searchHas = map[string]string{
+	"image":      "resource.image is not null",
+	"attachment": "exists (select 1 from attachment where attachment.resource_id = resource.id)",
+}
+
+// ParseSearch parses a search query of space separated terms. a term can be
+// quoted to include spaces and prefixed with '-' to exclude resources
+// containing it. the filters "type:<type>", "in:<path>" and "has:image" or
+// "has:attachment" restrict the results further, they can be negated like
+// terms. a quote without a closing quote extends to the end of the query and anything
+// that is not a filter is searched for literally, so only invalid filters and
+// empty queries are errors.
+func ParseSearch(s string) (out SearchQuery, err error) {
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			break
+		}
+		negated := strings.HasPrefix(s, "-")
+		if negated {
+			s = s[1:]
+		}
+
+		var text string
+		if strings.HasPrefix(s, `"`) {
+			text, s = readQuoted(s[1:])
+		} else {
+			var word, rest string
+			word, rest = readWord(s)
+			key, value, ok := strings.Cut(word, ":")
+			if ok && isSearchKey(key) {
+				if strings.HasPrefix(value, `"`) {
+					value, rest = readQuoted(s[len(key)+2:])
+				}
+				var filter SearchFilter
+				filter, err = parseSearchFilter(strings.ToLower(key), value)
+				if err != nil {
+					return
+				}
+				filter.Negated = negated
+				out.Filters = append(out.Filters, filter)
+				s = rest
+				continue
+			}
+			text, s = word, rest
+		}
+		if text == "" {
+			continue
+		}
+		if negated {
+			out.Excluded = append(out.Excluded, text)
+		} else {
+			out.Terms = append(out.Terms, text)
+		}
+	}
+	if len(out.Terms) == 0 && len(out.Excluded) == 0 && len(out.Filters) == 0 {
+		err = errors.New("empty search query")
+	}
+	return
+}
+
+func isSearchKey(key string) bool {
+	switch strings.ToLower(key) {
+	case "type", "in", "has":
+		return true
+	}
+	return false
+}
+
+func parseSearchFilter(key, value string) (filter SearchFilter, err error) {
+	if value == "" {
+		err = fmt.Errorf("missing value for %q", key+":")
+		return
+	}
+	switch key {
+	case "type":
+		value = strings.ToLower(value)
+	case "has":
+		value = strings.ToLower(value)
+		if _, ok := searchHas[value]; !ok {
+			err = fmt.Errorf("invalid value for \"has:\": %q, must be image or attachment", value)
+			return
+		}
+	}
+	filter = SearchFilter{Key: key, Value: value}
+	return
+}
+
+// readWord returns the text up to the next space and the rest of s
+func readWord(s string) (word, rest string) {
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
+
+// readQuoted returns the text up to the next quote and the rest of s after
+// it, the text extends to the end of s if there is no quote
+func readQuoted(s string) (text, rest string) {
+	text, rest, _ = strings.Cut(s, `"`)
+	return
+}
+
+// ftsString quotes text so that fts5 matches it literally instead of
+// interpreting it as a query
+func ftsString(text string) string {
+	return `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+}
+
+// HighlightStart and HighlightEnd enclose the matching parts of the
+// highlighted name and the snippet of a SearchRow
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+// search matches the terms against the resources themselves and against the
+// text of their attachments. a resource is returned once, with the match of
+// the resource itself being preferred over the matches of its attachments.
+const search = `with
+	matches as (
+		select
+			resource.*,
+			null as attachment_id,
+			null as attachment_name,
+			highlight(resource_fts, 0, char(2), char(3)) as name_highlight,
+			snippet(resource_fts, 1, char(2), char(3), '…', 64) as snippet,
+			-- matches of the name count ten times as much as the comments
+			bm25(resource_fts, 10.0, 1.0) as rank
+		from resource
+		join resource_fts on resource.id = resource_fts.rowid
+		where resource_fts match ? and resource.trash_id is null /*resource_filters*/
+
+		union all
+
+		select
+			resource.*,
+			attachment.id,
+			attachment.filename,
+			resource.name,
+			snippet(attachment_fts, 0, char(2), char(3), '…', 64),
+			attachment_fts.rank
+		from attachment_fts
+		join attachment on attachment.id = attachment_fts.rowid
+		join resource on resource.id = attachment.resource_id
+		where attachment_fts match ? and resource.trash_id is null /*attachment_filters*/
+	),
+	best as (
+		select
+			*,
+			row_number() over (
+				partition by id
+				order by attachment_id is not null, rank
+			) as n
+		from matches
+	)
+select
+	id,
+	parent_id,
+	name,
+	type,
+	comments,
+	image,
+	created_at,
+	updated_at,
+	trash_id,
+	attachment_id,
+	attachment_name,
+	name_highlight,
+	snippet,
+	count(*) over ()
+from best
+where n = 1
+order by rank, id
+limit ? offset ?`
+
+// searchFiltered is used instead of search if there are no terms to match,
+// fts5 cannot list the documents which do not contain a term
+const searchFiltered = `select
+	resource.*,
+	null,
+	null,
+	resource.name,
+	iif(length(resource.comments) > 64, substr(resource.comments, 1, 64) || '…', resource.comments),
+	count(*) over ()
+from resource
+where resource.trash_id is null /*resource_filters*/
+order by /*order*/resource.name, resource.id
+limit ? offset ?`
+
+// searchIn matches the descendants of a resource like GetSubtree, "union"
+// stops the recursion if the parents form a cycle
+const searchIn = `resource.id in (
+	with recursive
+		subtree(id) as (
+			select id from resource
+			where parent_id = ?
+
+			union
+
+			select resource.id from resource
+			join subtree on
+				resource.parent_id = subtree.id
+		)
+	select id from subtree
+)`
+
+// minTrigram is the length a term needs for fts5 to match it, the trigram
+// tokenizer cannot match shorter terms so they are matched with like instead
+const minTrigram = 3
+
+func isShortTerm(text string) bool {
+	return utf8.RuneCountInString(text) < minTrigram
+}
+
+// likePattern matches text anywhere in a value with "like ? escape '\'"
+func likePattern(text string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text) + "%"
+}
+
+const searchLikeResource = `(resource.name like ? escape '\' or resource.comments like ? escape '\')`
+
+const searchLikeAttachment = `attachment_fts.content like ? escape '\'`
+
+const searchExcludeResource = `resource.id not in (
+	select rowid from resource_fts where resource_fts match ?
+)`
+
+const searchExcludeAttachment = `attachment.id not in (
+	select rowid from attachment_fts where attachment_fts match ?
+)`
+
+type SearchRow struct {
+	Resource
+	// the attachment whose text matched the query, it is not set if the
+	// resource itself matched
+	AttachmentID   sql.NullInt64
+	AttachmentName sql.NullString
+	// the name with the matches highlighted and the part of the comments or
+	// of the text of the attachment around the first match, see
+	// HighlightStart
+	NameHighlight string
+	Snippet       string
+}
+
+// searchConditions compiles the filters of the query into conditions on the
+// resource table, the values of the filters are only ever passed as arguments
+func (q *Queries) searchConditions(ctx context.Context, query SearchQuery) (conds []string, args []any, err error) {
+	if query.Ancestor.Valid {
+		conds = append(conds, searchIn)
+		args = append(args, query.Ancestor.Int64)
+	}
+	for _, f := range query.Filters {
+		var cond string
+		switch f.Key {
+		case "type":
+			cond = "resource.type = ?"
+			args = append(args, f.Value)
+		case "has":
+			cond = searchHas[f.Value]
+		case "in":
+			var id sql.NullInt64
+			id, err = q.Resolve(ctx, f.Value)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = fmt.Errorf("%w: %s", ErrUnknownContainer, f.Value)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if !id.Valid {
+				// everything is in the root
+				cond = "true"
+				break
+			}
+			cond = searchIn
+			args = append(args, id.Int64)
+		default:
+			err = fmt.Errorf("invalid search filter: %q", f.Key)
+			return
+		}
+		if f.Negated {
+			cond = "not (" + cond + ")"
+		}
+		conds = append(conds, cond)
+	}
+	return
+}
+
+// joinConds formats conditions to be appended to a where clause
+func joinConds(conds []string) (out string) {
+	for _, cond := range conds {
+		out += " and " + cond
+	}
+	return
+}
+
+// Search returns a page of the resources matching the query ordered by
+// relevance, along with the total amount of matching resources. resources
+// which only match through the text of their attachments are returned with the
+// best matching attachment. excluded terms are checked against the resource
+// and the attachment it matched through. a negative limit returns every
+// result.
+//
+// terms shorter than minTrigram are matched with like, queries made only of
+// them are not matched against attachments.
+func (q *Queries) Search(ctx context.Context, query SearchQuery, limit, offset int64) (items []SearchRow, total int64, err error) {
+	conds, condArgs, err := q.searchConditions(ctx, query)
+	if err != nil {
+		return
+	}
+	// the conditions which only apply to the resource itself or to the
+	// attachment it matched through
+	var resourceConds, attachmentConds []string
+	var resourceArgs, attachmentArgs []any
+	var terms, excluded []string
+	// names matching the short terms are listed first if there are no other
+	// terms to rank by
+	var nameHits []string
+	var nameHitArgs []any
+	for _, text := range query.Terms {
+		if !isShortTerm(text) {
+			terms = append(terms, ftsString(text))
+			continue
+		}
+		pattern := likePattern(text)
+		resourceConds = append(resourceConds, searchLikeResource)
+		resourceArgs = append(resourceArgs, pattern, pattern)
+		attachmentConds = append(attachmentConds, searchLikeAttachment)
+		attachmentArgs = append(attachmentArgs, pattern)
+		nameHits = append(nameHits, `(resource.name like ? escape '\')`)
+		nameHitArgs = append(nameHitArgs, pattern)
+	}
+	for _, text := range query.Excluded {
+		if !isShortTerm(text) {
+			excluded = append(excluded, ftsString(text))
+			continue
+		}
+		pattern := likePattern(text)
+		conds = append(conds, "not "+searchLikeResource)
+		condArgs = append(condArgs, pattern, pattern)
+		attachmentConds = append(attachmentConds, "not "+searchLikeAttachment)
+		attachmentArgs = append(attachmentArgs, pattern)
+	}
+	if len(excluded) > 0 {
+		exclude := strings.Join(excluded, " OR ")
+		conds = append(conds, searchExcludeResource)
+		condArgs = append(condArgs, exclude)
+		attachmentConds = append(attachmentConds, searchExcludeAttachment)
+		attachmentArgs = append(attachmentArgs, exclude)
+	}
+	resourceConds = append(slices.Clone(conds), resourceConds...)
+	resourceArgs = append(slices.Clone(condArgs), resourceArgs...)
+	attachmentConds = append(slices.Clone(conds), attachmentConds...)
+	attachmentArgs = append(slices.Clone(condArgs), attachmentArgs...)
+
+	var stmt string
+	var args []any
+	if len(terms) == 0 {
+		order := ""
+		if len(nameHits) > 0 {
+			order = "(" + strings.Join(nameHits, " + ") + ") desc, "
+		}
+		stmt = strings.Replace(searchFiltered, "/*resource_filters*/", joinConds(resourceConds), 1)
+		stmt = strings.Replace(stmt, "/*order*/", order, 1)
+		args = append(resourceArgs, nameHitArgs...)
+	} else {
+		match := strings.Join(terms, " ")
+		args = append(args, match)
+		args = append(args, resourceArgs...)
+		args = append(args, match)
+		args = append(args, attachmentArgs...)
+		stmt = strings.Replace(search, "/*resource_filters*/", joinConds(resourceConds), 1)
+		stmt = strings.Replace(stmt, "/*attachment_filters*/", joinConds(attachmentConds), 1)
+	}
+	args = append(args, limit, offset)
+
+	rows, err := q.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i SearchRow
+		err = rows.Scan(
+			&i.ID,
+			&i.ParentID,
+			&i.Name,
+			&i.Type,
+			&i.Comments,
+			&i.Image,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TrashID,
+			&i.AttachmentID,
+			&i.AttachmentName,
+			&i.NameHighlight,
+			&i.Snippet,
+			&total,
+		)
+		if err != nil {
+			return
+		}
+		items = append(items, i)
+	}
+	err = rows.Err()
+	return
+}
+
+// String formats the query in the syntax understood by ParseSearch, the
+// ancestor of the query is not part of it
+func (query SearchQuery) String() string {
+	var parts []string
+	for _, text := range query.Terms {
+		parts = append(parts, quoteSearch(text))
+	}
+	for _, text := range query.Excluded {
+		parts = append(parts, "-"+quoteSearch(text))
+	}
+	for _, f := range query.Filters {
+		part := f.Key + ":" + quoteSearch(f.Value)
+		if f.Negated {
+			part = "-" + part
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+// quoteSearch quotes text if it would not be parsed as a single term
+func quoteSearch(text string) string {
+	key, _, isFilter := strings.Cut(text, ":")
+	if strings.ContainsFunc(text, unicode.IsSpace) ||
+		strings.HasPrefix(text, "-") ||
+		strings.HasPrefix(text, `"`) ||
+		isFilter && isSearchKey(key) {
+		return `"` + text + `"`
+	}
+	return text
+}
+
+// editDistance returns the amount of runes which have to be inserted,
+// deleted, replaced or swapped with their neighbour to turn a into b
+func editDistance(a, b []rune) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// maxTypos returns how many typos a word may contain to still be corrected,
+// short words become too many other words with more typos
+func maxTypos(word []rune) int {
+	if len(word) <= 5 {
+		return 1
+	}
+	return 2
+}
+
+const listVocabulary = `select term, doc from resource_vocabulary
+where length(term) between ? and ?`
+
+// suggestWord returns the word of the resource names closest to the term,
+// ties are broken by how many names contain the word. ok is false if the term
+// is a word of the names itself or no word is close enough.
+func (q *Queries) suggestWord(ctx context.Context, term string) (word string, ok bool, err error) {
+	target := []rune(strings.ToLower(term))
+	typos := maxTypos(target)
+	rows, err := q.db.QueryContext(ctx, listVocabulary, len(target)-typos, len(target)+typos)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	best, bestDocs := typos+1, int64(0)
+	for rows.Next() {
+		var candidate string
+		var docs int64
+		err = rows.Scan(&candidate, &docs)
+		if err != nil {
+			return
+		}
+		d := editDistance(target, []rune(candidate))
+		if d == 0 {
+			return "", false, nil
+		}
+		if d < best || d == best && docs > bestDocs {
+			word, best, bestDocs = candidate, d, docs
+		}
+	}
+	err = rows.Err()
+	ok = word != ""
+	return
+}
+
+// Suggest returns the query with its misspelled terms replaced by the closest
+// word of the resource names, it is meant for queries without results. ok is
+// false if none of the terms could be corrected. phrases and terms which are
+// too short to be matched by fts5 are never corrected.
+func (q *Queries) Suggest(ctx context.Context, query SearchQuery) (out SearchQuery, ok bool, err error) {
+	out = query
+	out.Terms = slices.Clone(query.Terms)
+	for i, text := range query.Terms {
+		if isShortTerm(text) || strings.ContainsFunc(text, unicode.IsSpace) {
+			continue
+		}
+		var word string
+		var found bool
+		word, found, err = q.suggestWord(ctx, text)
+		if err != nil {
+			return
+		}
+		if found {
+			out.Terms[i] = word
+			ok = true
+		}
+	}
+	return
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSearch(t *testing.T) {
+	t.Run("terms and filters", func(t *testing.T) {
+		query, err := ParseSearch(`drill "cordless 18V" -broken type:item -in:"/garage/top shelf" HAS:Image`)
+		require.NoError(t, err)
+		require.Equal(t, SearchQuery{
+			Terms:    []string{"drill", "cordless 18V"},
+			Excluded: []string{"broken"},
+			Filters: []SearchFilter{
+				{Key: "type", Value: "item"},
+				{Key: "in", Value: "/garage/top shelf", Negated: true},
+				{Key: "has", Value: "image"},
+			},
+		}, query)
+	})
+
+	t.Run("stray syntax is literal", func(t *testing.T) {
+		query, err := ParseSearch(`12:30 a"b "AND (x`)
+		require.NoError(t, err)
+		require.Equal(t, []string{"12:30", `a"b`, "AND (x"}, query.Terms)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, s := range []string{"", `  "" - `, "type:", "has:receipt"} {
+			_, err := ParseSearch(s)
+			require.Error(t, err, s)
+		}
+	})
+}
+
+func TestSearchQuery(t *testing.T) {
+	ctx := t.Context()
+	_, qry := newTestDB(t)
+
+	create := func(parentID int64, name, typ, comments string, image bool) int64 {
+		params := CreateResourceParams{Name: name, Type: typ, Comments: comments}
+		if parentID != 0 {
+			params.ParentID = sql.NullInt64{Int64: parentID, Valid: true}
+		}
+		if image {
+			params.Image = sql.NullString{String: name + ".jpg", Valid: true}
+		}
+		return newTestResource(t, qry, params)
+	}
+	garage := create(0, "garage", "container", "", false)
+	shelf := create(garage, "shelf", "container", "tools", false)
+	drill := create(shelf, "drill", "item", "cordless tools", true)
+	saw := create(shelf, "saw", "item", "broken tools", false)
+	kitchen := create(0, "kitchen", "container", "", false)
+	knife := create(kitchen, "knife", "item", "kitchen tools", true)
+
+	search := func(s string) []int64 {
+		query, err := ParseSearch(s)
+		require.NoError(t, err, s)
+		results, _, err := qry.Search(ctx, query, -1, 0)
+		require.NoError(t, err, s)
+		var ids []int64
+		for _, r := range results {
+			ids = append(ids, r.ID)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	require.Equal(t, []int64{shelf, drill, saw, knife}, search("tools"))
+	require.Equal(t, []int64{drill, saw, knife}, search("tools type:item"))
+	require.Equal(t, []int64{shelf}, search("tools -type:item"))
+	require.Equal(t, []int64{shelf, drill, saw}, search("tools in:/garage"))
+	require.Equal(t, []int64{knife}, search("tools -in:garage/"))
+	require.Equal(t, []int64{shelf, drill, saw, knife}, search("tools in:/"))
+	require.Equal(t, []int64{drill, knife}, search("tools has:image"))
+	require.Equal(t, []int64{shelf, drill, knife}, search("tools -broken"))
+	require.Equal(t, []int64{drill}, search(`"cordless tools"`))
+	// without terms every resource matching the filters is listed
+	require.Equal(t, []int64{drill, saw}, search("in:/garage/shelf"))
+	require.Equal(t, []int64{garage, shelf, kitchen}, search("type:container"))
+	// syntax is never passed to fts5
+	require.Empty(t, search(`tools"`))
+	require.Empty(t, search(`"tools OR kitchen"`))
+
+	// the scope of a search combines with its filters
+	query, err := ParseSearch("tools -type:container")
+	require.NoError(t, err)
+	query.Ancestor = sql.NullInt64{Int64: garage, Valid: true}
+	results, _, err := qry.Search(ctx, query, -1, 0)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.ElementsMatch(t, []int64{drill, saw}, []int64{results[0].ID, results[1].ID})
+
+	// results are paged with the total counted over every page
+	results, total, err := qry.Search(ctx, SearchQuery{Terms: []string{"tools"}}, 3, 2)
+	require.NoError(t, err)
+	require.EqualValues(t, 4, total)
+	require.Len(t, results, 2)
+	results, total, err = qry.Search(ctx, SearchQuery{Filters: []SearchFilter{{Key: "type", Value: "container"}}}, 2, 0)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	require.Equal(t, []int64{garage, kitchen}, []int64{results[0].ID, results[1].ID})
+
+	results, _, err = qry.Search(ctx, SearchQuery{Terms: []string{"cordless"}}, -1, 0)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, "drill", results[0].NameHighlight)
+	require.Equal(t, HighlightStart+"cordless"+HighlightEnd+" tools", results[0].Snippet)
+
+	paths, err := qry.GetPaths(ctx, []int64{drill, kitchen})
+	require.NoError(t, err)
+	require.Equal(t, map[int64][]string{
+		drill:   {"garage", "shelf", "drill"},
+		kitchen: {"kitchen"},
+	}, paths)
+
+	_, _, err = qry.Search(ctx, SearchQuery{Filters: []SearchFilter{{Key: "in", Value: "/attic"}}}, -1, 0)
+	require.ErrorIs(t, err, ErrUnknownContainer)
+}
+
+func TestSearchShortTerms(t *testing.T) {
+	ctx := t.Context()
+	_, qry := newTestDB(t)
+
+	create := func(name, comments string) int64 {
+		return newTestResource(t, qry, CreateResourceParams{Name: name, Type: "item", Comments: comments})
+	}
+	batteries := create("AA batteries", "")
+	remote := create("remote", "takes two aa batteries")
+	screws := create("M3 screws", "10% spare")
+	create("screwdriver", "for M4")
+
+	search := func(s string) []int64 {
+		query, err := ParseSearch(s)
+		require.NoError(t, err, s)
+		results, _, err := qry.Search(ctx, query, -1, 0)
+		require.NoError(t, err, s)
+		var ids []int64
+		for _, r := range results {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+	// names matching the term come first
+	require.Equal(t, []int64{batteries, remote}, search("aa"))
+	require.Equal(t, []int64{screws}, search("m3"))
+	require.Equal(t, []int64{screws}, search("screw -M4"))
+	// short and long terms combine
+	require.Equal(t, []int64{remote}, search("two aa"))
+	require.Equal(t, []int64{batteries}, search("aa batteries -two"))
+	// like wildcards are matched literally
+	require.Equal(t, []int64{screws}, search("%"))
+	require.Empty(t, search("_"))
+
+	// name hits are ranked above comment hits
+	require.Equal(t, []int64{batteries, remote}, search("batteries"))
+}
+
+func TestSuggest(t *testing.T) {
+	ctx := t.Context()
+	_, qry := newTestDB(t)
+
+	for _, name := range []string{"Cordless drill", "Drill bits", "Dril press", "Hammer"} {
+		newTestResource(t, qry, CreateResourceParams{Name: name, Type: "item"})
+	}
+	suggest := func(s string) string {
+		query, err := ParseSearch(s)
+		require.NoError(t, err, s)
+		suggestion, ok, err := qry.Suggest(ctx, query)
+		require.NoError(t, err, s)
+		if !ok {
+			return ""
+		}
+		return suggestion.String()
+	}
+	// the more common of two equally close words is suggested
+	require.Equal(t, "drill in:/garage", suggest("drlil in:/garage"))
+	require.Equal(t, "hammer -bits", suggest("hamer -bits"))
+	require.Equal(t, "cordless", suggest("cordlss"))
+	require.Equal(t, "", suggest("hammer"))
+	require.Equal(t, "", suggest("xylophone"))
+	require.Equal(t, "", suggest(`"drlil press"`))
+
+	// renamed resources change the vocabulary
+	id := newTestResource(t, qry, CreateResourceParams{Name: "wrench", Type: "item"})
+	require.Equal(t, "wrench", suggest("wrnch"))
+	_, err := qry.UpdateResource(ctx, UpdateResourceParams{ID: id, Name: "spanner", Type: "item"})
+	require.NoError(t, err)
+	require.Equal(t, "", suggest("wrnch"))
+	require.Equal(t, "spanner", suggest("spaner"))
+
+	// trashed resources are not searched, so their words are not suggested
+	trash := func() int64 {
+		trashID, err := qry.CreateTrash(ctx, CreateTrashParams{ResourceID: id, OriginalPath: "/spanner"})
+		require.NoError(t, err)
+		_, err = qry.TrashResource(ctx, TrashResourceParams{ID: id, TrashID: trashID})
+		require.NoError(t, err)
+		return trashID
+	}
+	trashID := trash()
+	require.Equal(t, "", suggest("spaner"))
+	_, err = qry.RestoreTrash(ctx, RestoreTrashParams{ResourceID: id, TrashID: sql.NullInt64{Int64: trashID, Valid: true}})
+	require.NoError(t, err)
+	require.Equal(t, "spanner", suggest("spaner"))
+	trash()
+	require.NoError(t, qry.RebuildSearchIndex(ctx))
+	require.Equal(t, "", suggest("spaner"))
+	require.Equal(t, "hammer", suggest("hamer"))
+	_, err = qry.PurgeTrash(ctx, time.Now().Add(time.Hour).Unix())
+	require.NoError(t, err)
+	require.Equal(t, "", suggest("spaner"))
+	require.Equal(t, "hammer", suggest("hamer"))
+}
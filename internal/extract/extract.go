@@ -0,0 +1,68 @@
+// Package extract reads the text of uploaded files so that it can be searched
+package extract
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// ErrUnsupported is returned for files which have no text that can be read,
+// ex. images and archives
+var ErrUnsupported = errors.New("unsupported media type")
+
+// MaxText is the most text that is extracted from a single file, manuals can
+// be hundreds of pages long but the first pages are the ones which name the
+// model
+const MaxText = 1 << 20
+
+// Text returns the text of a file of the given media type, this is the file
+// itself for plain text files and the text layer of PDFs. scanned PDFs
+// without a text layer have no text.
+func Text(r io.ReaderAt, size int64, mediaType string) (text string, err error) {
+	mediaType, _, err = mime.ParseMediaType(mediaType)
+	if err != nil {
+		return "", ErrUnsupported
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		text, err = readText(io.NewSectionReader(r, 0, size))
+	case mediaType == "application/pdf":
+		text, err = pdfText(r, size)
+	default:
+		return "", ErrUnsupported
+	}
+	if err != nil {
+		return
+	}
+	return strings.ToValidUTF8(text, "�"), nil
+}
+
+func readText(r io.Reader) (string, error) {
+	var sb strings.Builder
+	_, err := io.Copy(&sb, io.LimitReader(r, MaxText))
+	return sb.String(), err
+}
+
+func pdfText(r io.ReaderAt, size int64) (text string, err error) {
+	// the pdf package panics on some malformed files instead of returning an
+	// error
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("malformed pdf: %v", p)
+		}
+	}()
+	doc, err := pdf.NewReader(r, size)
+	if err != nil {
+		return
+	}
+	plain, err := doc.GetPlainText()
+	if err != nil {
+		return
+	}
+	return readText(plain)
+}
@@ -0,0 +1,63 @@
+package extract
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// textPDF returns a single page PDF which shows the text in Helvetica
+func textPDF(text string) []byte {
+	content := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func TestText(t *testing.T) {
+	t.Run("plain text", func(t *testing.T) {
+		const contents = "Model: XJ-900\nSerial: 12345\n"
+		text, err := Text(strings.NewReader(contents), int64(len(contents)), "text/plain; charset=utf-8")
+		require.NoError(t, err)
+		require.Equal(t, contents, text)
+	})
+
+	t.Run("pdf", func(t *testing.T) {
+		doc := textPDF("Model XJ-900")
+		text, err := Text(bytes.NewReader(doc), int64(len(doc)), "application/pdf")
+		require.NoError(t, err)
+		require.Contains(t, text, "XJ-900")
+	})
+
+	t.Run("malformed pdf", func(t *testing.T) {
+		doc := []byte("%PDF-1.4\nnot really a pdf")
+		_, err := Text(bytes.NewReader(doc), int64(len(doc)), "application/pdf")
+		require.Error(t, err)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := Text(bytes.NewReader(nil), 0, "image/png")
+		require.ErrorIs(t, err, ErrUnsupported)
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"item-archive-d/internal/db"
 	"log"
 	"os"
@@ -28,6 +29,9 @@ func loadDir(ctx context.Context, txqry *db.Queries, cwd string, parentId int64)
 			continue
 		}
 		segments := strings.Split(e.Name(), ".")
+		if segments[0] == "" {
+			panic(fmt.Errorf("%s: directory has no name", filepath.Join(cwd, e.Name())))
+		}
 		name := normalizeName(segments[0])
 		macrotype := segments[len(segments)-1]
 		tags := strings.Join(segments[1:len(segments)-1], ",")
@@ -46,6 +50,9 @@ func loadDir(ctx context.Context, txqry *db.Queries, cwd string, parentId int64)
 			Type:     macrotype,
 			Comments: tags,
 		})
+		if db.IsNameTaken(err) {
+			panic(fmt.Errorf("%s: a resource named %q has already been imported from %s", filepath.Join(cwd, e.Name()), name, cwd))
+		}
 		if err != nil {
 			panic(err)
 		}
@@ -56,17 +63,25 @@ func loadDir(ctx context.Context, txqry *db.Queries, cwd string, parentId int64)
 func main() {
 	ctx := context.Background()
 
-	db, qry, err := db.Open(ctx, "state.db", "")
+	driver, qry, err := db.Open(ctx, "state.db")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Migrate(ctx, driver)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	tx, err := db.BeginTx(ctx, nil)
+	tx, err := driver.BeginTx(ctx, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer tx.Rollback()
 	txqry := qry.WithTx(tx)
+	err = txqry.SetChangeContext(ctx, db.SetChangeContextParams{Actor: "importer"})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	loadDir(ctx, txqry, ".", -1)
 
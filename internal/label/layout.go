@@ -0,0 +1,94 @@
+package label
+
+// Layout describes a sheet of label stock, all lengths are in the unit of the
+// layout ("in" or "mm") so that they can be used as CSS lengths directly
+type Layout struct {
+	Name string
+	Unit string
+
+	PageWidth  float64
+	PageHeight float64
+
+	Columns int
+	Rows    int
+
+	// the distance from the edge of the page to the first label
+	MarginTop  float64
+	MarginLeft float64
+
+	LabelWidth  float64
+	LabelHeight float64
+
+	// the distance from the start of a label to the start of the next one,
+	// which includes the gap between them
+	PitchX float64
+	PitchY float64
+}
+
+// PerPage returns the amount of labels on a single sheet
+func (l Layout) PerPage() int {
+	return l.Columns * l.Rows
+}
+
+// Layouts are the supported label stocks, keyed by their product number
+var Layouts = map[string]Layout{
+	"5160": {
+		Name:        "Avery 5160 (Letter, 30 labels, 2 5/8\" x 1\")",
+		Unit:        "in",
+		PageWidth:   8.5,
+		PageHeight:  11,
+		Columns:     3,
+		Rows:        10,
+		MarginTop:   0.5,
+		MarginLeft:  0.1875,
+		LabelWidth:  2.625,
+		LabelHeight: 1,
+		PitchX:      2.75,
+		PitchY:      1,
+	},
+	"5163": {
+		Name:        "Avery 5163 (Letter, 10 labels, 4\" x 2\")",
+		Unit:        "in",
+		PageWidth:   8.5,
+		PageHeight:  11,
+		Columns:     2,
+		Rows:        5,
+		MarginTop:   0.5,
+		MarginLeft:  0.15625,
+		LabelWidth:  4,
+		LabelHeight: 2,
+		PitchX:      4.1875,
+		PitchY:      2,
+	},
+	"L7160": {
+		Name:        "Avery L7160 (A4, 21 labels, 63.5 x 38.1 mm)",
+		Unit:        "mm",
+		PageWidth:   210,
+		PageHeight:  297,
+		Columns:     3,
+		Rows:        7,
+		MarginTop:   15.15,
+		MarginLeft:  7.25,
+		LabelWidth:  63.5,
+		LabelHeight: 38.1,
+		PitchX:      66,
+		PitchY:      38.1,
+	},
+	"L7163": {
+		Name:        "Avery L7163 (A4, 14 labels, 99.1 x 38.1 mm)",
+		Unit:        "mm",
+		PageWidth:   210,
+		PageHeight:  297,
+		Columns:     2,
+		Rows:        7,
+		MarginTop:   15.15,
+		MarginLeft:  4.65,
+		LabelWidth:  99.1,
+		LabelHeight: 38.1,
+		PitchX:      101.6,
+		PitchY:      38.1,
+	},
+}
+
+// DefaultLayout is used when no layout is chosen
+const DefaultLayout = "5160"
@@ -0,0 +1,62 @@
+package label
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode/decoder"
+	"github.com/makiuchi-d/gozxing/qrcode/encoder"
+)
+
+// quietZone is the amount of light modules around a QR code, the standard asks
+// for 4 but labels are small and scanners cope with 2 on a white background
+const quietZone = 2
+
+// QRCode encodes the contents into the modules of a QR code, true being dark.
+// the medium error correction level survives labels being scuffed a bit.
+func QRCode(contents string) (modules [][]bool, err error) {
+	code, err := encoder.Encoder_encode(contents, decoder.ErrorCorrectionLevel_M, map[gozxing.EncodeHintType]any{
+		gozxing.EncodeHintType_CHARACTER_SET: "UTF-8",
+	})
+	if err != nil {
+		return
+	}
+	matrix := code.GetMatrix()
+	modules = make([][]bool, matrix.GetHeight())
+	for y := range modules {
+		modules[y] = make([]bool, matrix.GetWidth())
+		for x := range modules[y] {
+			modules[y][x] = matrix.Get(x, y) == 1
+		}
+	}
+	return
+}
+
+// SVG renders the QR code of the contents as an SVG element which scales to
+// the size of its container
+func SVG(contents string) (svg string, err error) {
+	modules, err := QRCode(contents)
+	if err != nil {
+		return
+	}
+	size := len(modules) + 2*quietZone
+
+	var b strings.Builder
+	fmt.Fprintf(
+		&b,
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size,
+	)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+	for y, row := range modules {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x+quietZone, y+quietZone)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	svg = b.String()
+	return
+}
@@ -0,0 +1,43 @@
+package label
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+	"github.com/stretchr/testify/require"
+)
+
+// render draws the modules with a quiet zone, each module being scale pixels
+// wide
+func render(modules [][]bool, scale int) *image.Gray {
+	size := (len(modules) + 2*quietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := range size {
+		for x := range size {
+			mx, my := x/scale-quietZone, y/scale-quietZone
+			dark := my >= 0 && my < len(modules) && mx >= 0 && mx < len(modules) && modules[my][mx]
+			if dark {
+				img.SetGray(x, y, color.Gray{})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestQRCode(t *testing.T) {
+	const contents = "http://archive.local/_id/42"
+	modules, err := QRCode(contents)
+	require.NoError(t, err)
+
+	// render the modules and read them back
+	bmp, err := gozxing.NewBinaryBitmapFromImage(render(modules, 4))
+	require.NoError(t, err)
+	result, err := qrcode.NewQRCodeReader().Decode(bmp, nil)
+	require.NoError(t, err)
+	require.Equal(t, contents, result.GetText())
+}
@@ -0,0 +1,51 @@
+package label
+
+import (
+	"errors"
+	"image"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/oned"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// ErrNoCode is returned by Scan when there is no readable code in the image
+var ErrNoCode = errors.New("no QR code or barcode found")
+
+// readers returns a reader for every supported format, QR codes come first
+// since they are what the labels carry. readers keep state between calls, so
+// they are not shared between scans.
+func readers() []gozxing.Reader {
+	return []gozxing.Reader{
+		qrcode.NewQRCodeReader(),
+		// EAN and UPC, the barcodes on most retail packaging
+		oned.NewMultiFormatUPCEANReader(nil),
+		oned.NewCode128Reader(),
+		oned.NewCode39Reader(),
+		oned.NewCode93Reader(),
+		oned.NewITFReader(),
+		oned.NewCodaBarReader(),
+	}
+}
+
+// Scan returns the contents of the first QR code or barcode found in the
+// image, photos need not be cropped or rotated
+func Scan(img image.Image) (contents string, err error) {
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return
+	}
+	hints := map[gozxing.DecodeHintType]any{
+		// also looks for barcodes which are rotated and off center
+		gozxing.DecodeHintType_TRY_HARDER: true,
+	}
+	for _, reader := range readers() {
+		result, err := reader.Decode(bmp, hints)
+		// readers fail when the image does not contain their format
+		if err != nil {
+			continue
+		}
+		return result.GetText(), nil
+	}
+	return "", ErrNoCode
+}
@@ -0,0 +1,49 @@
+package label
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/oned"
+	"github.com/stretchr/testify/require"
+)
+
+// photo places the code off center on a larger gray background, roughly like
+// a photo of a label would
+func photo(code image.Image) image.Image {
+	bounds := code.Bounds()
+	img := image.NewGray(image.Rect(0, 0, bounds.Dx()*3, bounds.Dy()*3))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{Y: 200}), image.Point{}, draw.Src)
+	offset := image.Pt(bounds.Dx()/2, bounds.Dy())
+	draw.Draw(img, bounds.Add(offset), code, bounds.Min, draw.Src)
+	return img
+}
+
+func TestScan(t *testing.T) {
+	t.Run("qr code", func(t *testing.T) {
+		const contents = "http://archive.local/_id/42"
+		modules, err := QRCode(contents)
+		require.NoError(t, err)
+		scanned, err := Scan(photo(render(modules, 4)))
+		require.NoError(t, err)
+		require.Equal(t, contents, scanned)
+	})
+
+	t.Run("barcode", func(t *testing.T) {
+		const contents = "SN-0042-X"
+		matrix, err := oned.NewCode128Writer().Encode(contents, gozxing.BarcodeFormat_CODE_128, 300, 80, nil)
+		require.NoError(t, err)
+		scanned, err := Scan(photo(matrix))
+		require.NoError(t, err)
+		require.Equal(t, contents, scanned)
+	})
+
+	t.Run("no code", func(t *testing.T) {
+		blank := image.NewGray(image.Rect(0, 0, 200, 200))
+		_, err := Scan(blank)
+		require.ErrorIs(t, err, ErrNoCode)
+	})
+}
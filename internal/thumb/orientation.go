@@ -0,0 +1,141 @@
+package thumb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+/*
+Phones usually store photos in the orientation of the sensor and record how
+the photo should be rotated in the EXIF orientation tag. Browsers apply it when
+displaying the original, so thumbnails have to apply it as well or they would
+end up sideways.
+
+Orientation values:
+
+1: as is
+2: mirrored horizontally
+3: rotated 180°
+4: mirrored vertically
+5: mirrored horizontally, then rotated 270° clockwise
+6: rotated 90° clockwise
+7: mirrored horizontally, then rotated 90° clockwise
+8: rotated 270° clockwise
+*/
+
+const orientationTag = 0x0112
+
+// readOrientation returns the EXIF orientation of a JPEG image or 1 if there
+// is none
+func readOrientation(r io.Reader) int {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	_, err := io.ReadFull(br, soi[:])
+	if err != nil || soi != [2]byte{0xff, 0xd8} {
+		return 1
+	}
+	for {
+		var marker [4]byte
+		_, err = io.ReadFull(br, marker[:])
+		if err != nil || marker[0] != 0xff {
+			return 1
+		}
+		// start of scan, image data follows so there is no more metadata
+		if marker[1] == 0xda {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return 1
+		}
+		segment := make([]byte, length)
+		_, err = io.ReadFull(br, segment)
+		if err != nil {
+			return 1
+		}
+		if marker[1] == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return parseOrientation(segment[6:])
+		}
+	}
+}
+
+// parseOrientation finds the orientation tag in the first IFD of a TIFF
+// structure
+func parseOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := range count {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) != orientationTag {
+			continue
+		}
+		o := int(order.Uint16(tiff[entry+8:]))
+		if o < 1 || o > 8 {
+			return 1
+		}
+		return o
+	}
+	return 1
+}
+
+// swapsAxes reports whether applying the orientation swaps width and height
+func swapsAxes(orientation int) bool {
+	return orientation >= 5
+}
+
+// orient transforms the image so that it is displayed upright
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if swapsAxes(orientation) {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		for x := range dw {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], img.Pix[sy*img.Stride+sx*4:sy*img.Stride+sx*4+4])
+		}
+	}
+	return dst
+}
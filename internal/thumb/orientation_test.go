@@ -0,0 +1,109 @@
+package thumb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// segment returns a JPEG marker segment
+func segment(marker byte, data []byte) []byte {
+	out := []byte{0xff, marker}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(data)+2))
+	return append(out, data...)
+}
+
+// exifSegment returns an APP1 segment whose first IFD holds an unrelated tag
+// followed by the orientation
+func exifSegment(order binary.AppendByteOrder, orientation uint16) []byte {
+	tiff := []byte("MM")
+	if order == binary.LittleEndian {
+		tiff = []byte("II")
+	}
+	tiff = order.AppendUint16(tiff, 42)
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, 2)
+	// make, ascii, 4 bytes stored in the entry itself
+	tiff = order.AppendUint16(tiff, 0x010f)
+	tiff = order.AppendUint16(tiff, 2)
+	tiff = order.AppendUint32(tiff, 4)
+	tiff = append(tiff, 'a', 'b', 'c', 0)
+	// orientation, short, a single value padded to 4 bytes
+	tiff = order.AppendUint16(tiff, orientationTag)
+	tiff = order.AppendUint16(tiff, 3)
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0)
+	// no further IFDs
+	tiff = order.AppendUint32(tiff, 0)
+	return segment(0xe1, append([]byte("Exif\x00\x00"), tiff...))
+}
+
+func TestReadOrientation(t *testing.T) {
+	soi := []byte{0xff, 0xd8}
+	app0 := segment(0xe0, []byte("JFIF\x00\x01\x02\x00\x00\x01\x00\x01\x00\x00"))
+	sos := segment(0xda, []byte{0, 0, 0})
+
+	truncatedIFD := exifSegment(binary.BigEndian, 6)
+	// the length of the segment still covers the cut off entries
+	truncatedIFD = truncatedIFD[:len(truncatedIFD)-16]
+	binary.BigEndian.PutUint16(truncatedIFD[2:], uint16(len(truncatedIFD)-2))
+
+	for _, test := range []struct {
+		name     string
+		data     []byte
+		expected int
+	}{
+		{"big endian", slices.Concat(soi, exifSegment(binary.BigEndian, 6), sos), 6},
+		{"little endian", slices.Concat(soi, exifSegment(binary.LittleEndian, 8), sos), 8},
+		{"after other segments", slices.Concat(soi, app0, exifSegment(binary.BigEndian, 3), sos), 3},
+		{"missing app1", slices.Concat(soi, app0, sos), 1},
+		{"app1 after scan", slices.Concat(soi, sos, exifSegment(binary.BigEndian, 6)), 1},
+		{"truncated app1", slices.Concat(soi, exifSegment(binary.BigEndian, 6))[:20], 1},
+		{"truncated ifd", slices.Concat(soi, truncatedIFD, sos), 1},
+		{"invalid value", slices.Concat(soi, exifSegment(binary.LittleEndian, 9), sos), 1},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), 1},
+		{"empty", nil, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.expected, readOrientation(bytes.NewReader(test.data)))
+		})
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 3x2 image whose pixels are numbered row by row
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := range 6 {
+		img.Pix[i*4] = uint8(i)
+	}
+	pixels := func(img *image.RGBA) (out []uint8) {
+		for i := 0; i < len(img.Pix); i += 4 {
+			out = append(out, img.Pix[i])
+		}
+		return
+	}
+
+	for _, test := range []struct {
+		orientation int
+		width       int
+		expected    []uint8
+	}{
+		{1, 3, []uint8{0, 1, 2, 3, 4, 5}},
+		{2, 3, []uint8{2, 1, 0, 5, 4, 3}},
+		{3, 3, []uint8{5, 4, 3, 2, 1, 0}},
+		{4, 3, []uint8{3, 4, 5, 0, 1, 2}},
+		{5, 2, []uint8{0, 3, 1, 4, 2, 5}},
+		{6, 2, []uint8{3, 0, 4, 1, 5, 2}},
+		{7, 2, []uint8{5, 2, 4, 1, 3, 0}},
+		{8, 2, []uint8{2, 5, 1, 4, 0, 3}},
+	} {
+		oriented := orient(img, test.orientation)
+		require.Equal(t, test.width, oriented.Bounds().Dx(), "orientation %d", test.orientation)
+		require.Equal(t, test.expected, pixels(oriented), "orientation %d", test.orientation)
+	}
+}
@@ -0,0 +1,205 @@
+package thumb
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"item-archive-d/internal/blob"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	_ "image/gif"
+)
+
+// Widths are the widths thumbnails are generated at, requested widths are
+// rounded up to one of these so that only a few variants of each blob are
+// cached
+var Widths = []int{80, 160, 320, 640, 1280}
+
+// ErrUnsupported is returned when the blob is not an image that can be decoded
+var ErrUnsupported = errors.New("unsupported image format")
+
+// Width returns the width thumbnails are generated at for the requested width
+func Width(requested int) int {
+	i, _ := slices.BinarySearch(Widths, requested)
+	if i == len(Widths) {
+		return Widths[len(Widths)-1]
+	}
+	return Widths[i]
+}
+
+// Cache stores resized variants of the blobs in a blob.Store, thumbnails are
+// generated the first time they are requested. they are encoded as JPEG, or
+// as PNG if they are transparent since JPEG cannot represent that.
+type Cache struct {
+	Dir   string
+	Blobs blob.Store
+}
+
+// extensions of the formats thumbnails are encoded in
+var extensions = []string{".jpg", ".png"}
+
+// filename returns the name of the thumbnail without the extension of its
+// format
+func (c Cache) filename(id string, width int) (string, error) {
+	name, err := blob.CanonicalID(id)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(c.Dir, strconv.Itoa(width), name), nil
+}
+
+// Open returns a thumbnail of the blob at the given width (see Width),
+// generating it if it has not been cached yet
+func (c Cache) Open(id string, width int) (f *os.File, err error) {
+	width = Width(width)
+	filename, err := c.filename(id, width)
+	if err != nil {
+		return
+	}
+	for _, ext := range extensions {
+		f, err = os.Open(filename + ext)
+		if !os.IsNotExist(err) {
+			return
+		}
+	}
+
+	src, err := c.Blobs.Open(id)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	filename, err = generate(src, filename, width)
+	if err != nil {
+		return
+	}
+	return os.Open(filename)
+}
+
+// Remove deletes all cached thumbnails of a blob
+func (c Cache) Remove(id string) error {
+	for _, width := range Widths {
+		filename, err := c.filename(id, width)
+		if err != nil {
+			return err
+		}
+		for _, ext := range extensions {
+			err = os.Remove(filename + ext)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// generate writes the thumbnail to the filename with the extension of the
+// format it is encoded in added, which is returned in out
+func generate(src io.ReadSeeker, filename string, width int) (out string, err error) {
+	orientation := readOrientation(src)
+	_, err = src.Seek(0, io.SeekStart)
+	if err != nil {
+		return
+	}
+	img, _, err := image.Decode(src)
+	if errors.Is(err, image.ErrFormat) {
+		err = ErrUnsupported
+	}
+	if err != nil {
+		return
+	}
+	// the width of the result is the height of the source if orienting it
+	// swaps the axes
+	if swapsAxes(orientation) {
+		bounds := img.Bounds()
+		width = max(1, width*bounds.Dx()/bounds.Dy())
+	}
+	resized := orient(resize(img, width), orientation)
+	out = filename + ".jpg"
+	if !resized.Opaque() {
+		out = filename + ".png"
+	}
+
+	err = os.MkdirAll(filepath.Dir(filename), 0777)
+	if err != nil {
+		return
+	}
+	// thumbnails are written to a temporary file first so that concurrent
+	// requests never see a partially written thumbnail
+	tmpFilename := out + ".tmp-" + strconv.FormatUint(rand.Uint64(), 10)
+	f, err := os.Create(tmpFilename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(tmpFilename)
+		}
+	}()
+	if resized.Opaque() {
+		err = jpeg.Encode(f, resized, &jpeg.Options{Quality: 80})
+	} else {
+		err = png.Encode(f, resized)
+	}
+	if err != nil {
+		return
+	}
+	err = f.Close()
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmpFilename, out)
+	return
+}
+
+// resize scales the image down to the given width, each pixel of the result is
+// the average of the pixels it covers in the source image. images which are
+// already narrower are not scaled up.
+func resize(img image.Image, width int) *image.RGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sw <= width {
+		return src
+	}
+	dw := width
+	dh := max(1, sh*dw/sw)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for dy := range dh {
+		y0 := dy * sh / dh
+		y1 := max(y0+1, (dy+1)*sh/dh)
+		for dx := range dw {
+			x0 := dx * sw / dw
+			x1 := max(x0+1, (dx+1)*sw/dw)
+
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride:]
+				for x := x0; x < x1; x++ {
+					p := row[x*4 : x*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+			p := dst.Pix[dy*dst.Stride+dx*4 : dy*dst.Stride+dx*4+4]
+			p[0] = uint8(r / n)
+			p[1] = uint8(g / n)
+			p[2] = uint8(b / n)
+			p[3] = uint8(a / n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,149 @@
+package thumb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"item-archive-d/internal/blob"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWidth(t *testing.T) {
+	require.Equal(t, 80, Width(1))
+	require.Equal(t, 80, Width(80))
+	require.Equal(t, 160, Width(81))
+	require.Equal(t, 1280, Width(1280))
+	require.Equal(t, 1280, Width(5000))
+}
+
+func TestResize(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		width, height  int
+		requested      int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{"scaled down", 100, 50, 40, 40, 20},
+		{"narrower", 30, 10, 80, 30, 10},
+		{"same width", 80, 60, 80, 80, 60},
+		{"at least one row", 1000, 1, 80, 80, 1},
+		{"tall", 10, 1000, 5, 5, 500},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resized := resize(image.NewGray(image.Rect(0, 0, test.width, test.height)), test.requested)
+			require.Equal(t, image.Rect(0, 0, test.expectedWidth, test.expectedHeight), resized.Bounds())
+		})
+	}
+
+	t.Run("averages", func(t *testing.T) {
+		img := image.NewGray(image.Rect(10, 10, 12, 11))
+		img.SetGray(10, 10, color.Gray{Y: 0})
+		img.SetGray(11, 10, color.Gray{Y: 254})
+		resized := resize(img, 1)
+		require.Equal(t, color.RGBA{R: 127, G: 127, B: 127, A: 255}, resized.RGBAAt(0, 0))
+	})
+}
+
+func TestCache(t *testing.T) {
+	blobs := blob.Store{Dir: t.TempDir()}
+	cache := Cache{Dir: t.TempDir(), Blobs: blobs}
+
+	store := func(img image.Image, encode func(*bytes.Buffer, image.Image) error) string {
+		var buf bytes.Buffer
+		require.NoError(t, encode(&buf, img))
+		id, err := blobs.Store(&buf)
+		require.NoError(t, err)
+		return id
+	}
+	encodeJPEG := func(buf *bytes.Buffer, img image.Image) error {
+		return jpeg.Encode(buf, img, nil)
+	}
+	encodePNG := func(buf *bytes.Buffer, img image.Image) error {
+		return png.Encode(buf, img)
+	}
+	decode := func(f *os.File) (image.Image, string) {
+		defer f.Close()
+		img, format, err := image.Decode(f)
+		require.NoError(t, err)
+		return img, format
+	}
+
+	opaque := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for i := range opaque.Pix {
+		opaque.Pix[i] = 255
+	}
+	transparent := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+
+	t.Run("opaque", func(t *testing.T) {
+		id := store(opaque, encodeJPEG)
+		f, err := cache.Open(id, 100)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(cache.Dir, "160", id+".jpg"), f.Name())
+		img, format := decode(f)
+		require.Equal(t, "jpeg", format)
+		require.Equal(t, image.Rect(0, 0, 160, 80), img.Bounds())
+
+		// once cached the blob is no longer needed
+		require.NoError(t, blobs.Remove(blob.Info{Name: id}))
+		f, err = cache.Open(id, 160)
+		require.NoError(t, err)
+		f.Close()
+
+		// without the cached thumbnail it is generated again, which fails
+		// now that the blob is gone
+		require.NoError(t, cache.Remove(id))
+		_, err = cache.Open(id, 160)
+		require.True(t, os.IsNotExist(err), "unexpected error: %v", err)
+	})
+
+	t.Run("transparent", func(t *testing.T) {
+		id := store(transparent, encodePNG)
+		f, err := cache.Open(id, 80)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(cache.Dir, "80", id+".png"), f.Name())
+		img, format := decode(f)
+		require.Equal(t, "png", format)
+		require.Equal(t, image.Rect(0, 0, 80, 40), img.Bounds())
+		_, _, _, a := img.At(0, 0).RGBA()
+		require.Zero(t, a)
+
+		f, err = cache.Open(id, 80)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(cache.Dir, "80", id+".png"), f.Name())
+		f.Close()
+		require.NoError(t, cache.Remove(id))
+		_, err = os.Stat(filepath.Join(cache.Dir, "80", id+".png"))
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("oriented", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, jpeg.Encode(&buf, opaque, nil))
+		// rotated 90° clockwise, the source is 400x200 so the thumbnail is
+		// as wide as requested once it is upright
+		data := slices.Concat(buf.Bytes()[:2], exifSegment(binary.BigEndian, 6), buf.Bytes()[2:])
+		id, err := blobs.Store(bytes.NewReader(data))
+		require.NoError(t, err)
+		f, err := cache.Open(id, 160)
+		require.NoError(t, err)
+		img, _ := decode(f)
+		require.Equal(t, image.Rect(0, 0, 160, 320), img.Bounds())
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		id, err := blobs.Store(strings.NewReader("not an image"))
+		require.NoError(t, err)
+		_, err = cache.Open(id, 160)
+		require.ErrorIs(t, err, ErrUnsupported)
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"item-archive-d/internal/blob"
 	"item-archive-d/internal/db"
+	"item-archive-d/internal/thumb"
 	"log"
 	"net/http"
 	"os"
@@ -20,54 +21,109 @@ type Context struct {
 	driver *sql.DB
 	qry    *db.Queries
 	blobs  blob.Store
+	thumbs thumb.Cache
+}
+
+// commands are run instead of the server when their name is given as the
+// first argument after the flags
+var commands = map[string]func(ctx context.Context, c Context, args []string) error{
+	"rehash":  rehashCommand,
+	"gc":      gcCommand,
+	"fsck":    fsckCommand,
+	"migrate": migrateCommand,
+	"reindex": reindexCommand,
+}
+
+// routes returns the handler serving every route of the Context
+func (c Context) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(c.Search())
+	mux.HandleFunc(c.Image())
+	mux.HandleFunc(c.Edit())
+	mux.HandleFunc(c.Update())
+	mux.HandleFunc(c.Gallery())
+	mux.HandleFunc(c.Attachment())
+	mux.HandleFunc(c.Attachments())
+	mux.HandleFunc(c.MoveStart())
+	mux.HandleFunc(c.MoveFinish())
+	mux.HandleFunc(c.DeleteConfirm())
+	mux.HandleFunc(c.DeleteShallow())
+	mux.HandleFunc(c.DeleteDeep())
+	mux.HandleFunc(c.History())
+	mux.HandleFunc(c.AuditLog())
+	mux.HandleFunc(c.Revert())
+	mux.HandleFunc(c.Trash())
+	mux.HandleFunc(c.Restore())
+	mux.HandleFunc(c.Undo())
+	mux.HandleFunc(c.Permalink())
+	mux.HandleFunc(c.Aliases())
+	mux.HandleFunc(c.Labels())
+	mux.HandleFunc(c.Scan())
+	mux.HandleFunc(c.Intake())
+	mux.HandleFunc(c.List())
+	mux.HandleFunc(c.ApiNotFound())
+	mux.HandleFunc(c.ApiGet())
+	mux.HandleFunc(c.ApiGetByPath())
+	mux.HandleFunc(c.ApiChildren())
+	mux.HandleFunc(c.ApiChildrenByPath())
+	mux.HandleFunc(c.ApiCreate())
+	mux.HandleFunc(c.ApiUpdate())
+	mux.HandleFunc(c.ApiMove())
+	mux.HandleFunc(c.ApiDelete())
+	mux.HandleFunc(c.ApiSearch())
+	return mux
 }
 
 func main() {
 	addr := flag.String("addr", ":4502", "The address to listen on.")
 	dataPath := flag.String("data", ".", "The directory in which to store item-archive data.")
-	migrationPath := flag.String("migration", "", "Specify a file containing migration statements to run upon opening the database. (optional)")
+	gcInterval := flag.Duration("gc-interval", 24*time.Hour, "How often to delete unreferenced blobs while serving, 0 disables garbage collection.")
+	gcGrace := flag.Duration("gc-grace", 7*24*time.Hour, "Only delete unreferenced blobs that have not been modified for this long.")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "How long deleted resources are kept in the trash before they are purged, 0 keeps them forever.")
 	flag.Parse()
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
-	migrations := ""
-	if *migrationPath != "" {
-		log.Println("using migrations:", *migrationPath)
-		migrationContents, err := os.ReadFile(*migrationPath)
-		if err != nil {
-			log.Println(err)
-			return
-		}
-		migrations = string(migrationContents)
-	}
-
-	driver, qry, err := db.Open(ctx, filepath.Join(*dataPath, "state.db"), migrations)
+	driver, qry, err := db.Open(ctx, filepath.Join(*dataPath, "state.db"))
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	mux := http.NewServeMux()
+	blobs := blob.Store{Dir: filepath.Join(*dataPath, "blobs")}
 	router := Context{
 		driver: driver,
 		qry:    qry,
-		blobs:  blob.Store{Dir: filepath.Join(*dataPath, "blobs")},
+		blobs:  blobs,
+		thumbs: thumb.Cache{Dir: filepath.Join(*dataPath, "thumbs"), Blobs: blobs},
+	}
+
+	// the migrate command reports and applies migrations itself
+	if flag.Arg(0) != "migrate" {
+		_, err = migrate(ctx, router)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	if flag.NArg() > 0 {
+		command, ok := commands[flag.Arg(0)]
+		if !ok {
+			log.Println("unknown command:", flag.Arg(0))
+			return
+		}
+		err = command(ctx, router, flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	mux.HandleFunc(router.Search())
-	mux.HandleFunc(router.Image())
-	mux.HandleFunc(router.Edit())
-	mux.HandleFunc(router.Update())
-	mux.HandleFunc(router.MoveStart())
-	mux.HandleFunc(router.MoveFinish())
-	mux.HandleFunc(router.DeleteConfirm())
-	mux.HandleFunc(router.DeleteShallow())
-	mux.HandleFunc(router.DeleteDeep())
-	mux.HandleFunc(router.List())
 
 	srv := &http.Server{
 		Addr:    *addr,
-		Handler: mux,
+		Handler: router.routes(),
 	}
 	go func() {
 		err := srv.ListenAndServe()
@@ -76,6 +132,13 @@ func main() {
 		}
 	}()
 
+	if *gcInterval > 0 {
+		go runGarbageCollector(ctx, router, *gcInterval, *gcGrace)
+	}
+	if *trashRetention > 0 {
+		go runTrashPurger(ctx, router, *trashRetention)
+	}
+
 	log.Println("serving on...", *addr)
 	<-ctx.Done()
 
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"item-archive-d/internal/blob"
+	"item-archive-d/internal/db"
+	"item-archive-d/internal/thumb"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestContext returns a Context whose database, blobs and thumbnails are
+// kept in a temporary directory, the database is migrated and closed when the
+// test ends
+func newTestContext(t *testing.T) Context {
+	t.Helper()
+	dir := t.TempDir()
+	driver, qry, err := db.Open(t.Context(), filepath.Join(dir, "state.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { driver.Close() })
+	_, err = db.Migrate(t.Context(), driver)
+	require.NoError(t, err)
+
+	blobs := blob.Store{Dir: filepath.Join(dir, "blobs")}
+	return Context{
+		driver: driver,
+		qry:    qry,
+		blobs:  blobs,
+		thumbs: thumb.Cache{Dir: filepath.Join(dir, "thumbs"), Blobs: blobs},
+	}
+}
+
+// serve sends the request to the handler and returns the recorded response
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// formRequest returns a POST request with the fields as a multipart form, like
+// the forms of the pages send them
+func formRequest(t *testing.T, target string, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		require.NoError(t, form.WriteField(name, value))
+	}
+	require.NoError(t, form.Close())
+	r := httptest.NewRequest("POST", target, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
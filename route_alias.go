@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"item-archive-d/internal/db"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// rememberPath records the current path of a resource as an alias, this must
+// be called before the resource is renamed or moved so that its old links
+// keep working
+func rememberPath(ctx context.Context, txqry *db.Queries, id int64) (err error) {
+	segments, err := txqry.GetPath(ctx, id)
+	if err != nil || len(segments) == 0 {
+		return
+	}
+	err = txqry.CreatePathAlias(ctx, db.CreatePathAliasParams{
+		Path:       path.Join(append([]string{"/"}, segments...)...),
+		ResourceID: id,
+	})
+	return
+}
+
+// redirectAlias responds to a request for a path which does not resolve with
+// a redirect to where the resource with the longest matching alias is now,
+// route is the prefix of the path in the url (ex. "/_edit"). paths without an
+// alias result in a not found error.
+func redirectAlias(ctx context.Context, txqry *db.Queries, w http.ResponseWriter, r *http.Request, route, p string) (err error) {
+	p = path.Join("/", p)
+	moved, err := followAliases(ctx, txqry, p)
+	if err != nil {
+		return
+	}
+
+	location := path.Join("/", route, moved)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		location = trailingPath(location)
+	}
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Location", location)
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		w.WriteHeader(301)
+	} else {
+		// 301 would turn a form submission into a GET
+		w.WriteHeader(308)
+	}
+	return
+}
+
+// maxAliasHops limits how many aliases are followed for a single path, a path
+// needs another hop when a container inside a moved container has been moved
+// as well
+const maxAliasHops = 8
+
+// followAliases returns the current path of what used to be at the given path
+func followAliases(ctx context.Context, txqry *db.Queries, p string) (moved string, err error) {
+	moved = p
+	for range maxAliasHops {
+		var alias db.PathAlias
+		alias, err = txqry.GetPathAlias(ctx, moved)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return
+		}
+		var segments []string
+		segments, err = txqry.GetPath(ctx, alias.ResourceID)
+		if err != nil {
+			return
+		}
+		current := path.Join(append([]string{"/"}, segments...)...)
+		next := path.Join(current, strings.TrimPrefix(moved, alias.Path))
+		if next == moved {
+			break
+		}
+		moved = next
+		_, err = txqry.Resolve(ctx, moved)
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return
+		}
+	}
+	err = notFoundf("unknown resource: %s", p)
+	return
+}
+
+type AliasesProps_Alias struct {
+	Path       string
+	Current    string
+	Created    string
+	Stale      bool
+	StaleCause string
+}
+
+type AliasesProps struct {
+	Aliases []AliasesProps_Alias
+	Stale   int
+}
+
+const aliases_template = `<!DOCTYPE html>
+<html>
+<head>
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Item Archive: Aliases</title>
+	<style>
+	th, td {
+		text-align: start;
+		padding-right: 0.5rem;
+	}
+	h1, h2, h3, h4, h5, h6 {
+		margin: 0.75rem 0rem;
+	}
+	* {
+		box-sizing: border-box;
+	}
+	body {
+		margin: 0;
+		padding: 0.5rem;
+	}
+	form {
+		display: inline;
+	}
+	</style>
+</head>
+
+<body>
+	<a href="/">&lt;&lt; Home</a>
+
+	<hr>
+
+	<h4>Aliases</h4>
+	<p>Old paths of renamed and moved resources redirect to where they are now.</p>
+	{{if .Stale}}
+		<form action="/_aliases" method="post">
+			<input type="submit" value="Prune {{.Stale}} stale alias(es)">
+		</form>
+	{{end}}
+	<table>
+		<thead>
+			<th>Old path</th>
+			<th>Redirects to</th>
+			<th>Added</th>
+			<th></th>
+		</thead>
+		<tbody>
+			{{range .Aliases}}
+			<tr>
+				<td>{{.Path}}</td>
+				<td>
+					{{if .Stale}}
+						{{.StaleCause}}
+					{{else}}
+						<a href="{{.Current}}">{{.Current}}</a>
+					{{end}}
+				</td>
+				<td>{{.Created}}</td>
+				<td>
+					<form action="/_aliases" method="post">
+						<input type="hidden" name="path" value="{{.Path}}">
+						<input type="submit" value="Remove">
+					</form>
+				</td>
+			</tr>
+			{{end}}
+		</tbody>
+	</table>
+</body>
+</html>`
+
+// listAliases describes every alias, aliases are stale if they can never be
+// redirected from
+func listAliases(ctx context.Context, txqry *db.Queries) (out []AliasesProps_Alias, err error) {
+	aliases, err := txqry.ListPathAliases(ctx)
+	if err != nil {
+		return
+	}
+	for _, a := range aliases {
+		alias := AliasesProps_Alias{
+			Path:    a.Path,
+			Created: formatDate(a.CreatedAt),
+		}
+		var resolved sql.NullInt64
+		resolved, err = txqry.Resolve(ctx, a.Path)
+		switch {
+		case err == nil:
+			alias.Stale = true
+			if resolved.Int64 == a.ResourceID {
+				alias.StaleCause = "is the current path again"
+			} else {
+				alias.StaleCause = "is taken by another resource"
+			}
+		case errors.Is(err, sql.ErrNoRows):
+			_, err = txqry.GetResource(ctx, a.ResourceID)
+			if errors.Is(err, sql.ErrNoRows) {
+				alias.Stale = true
+				alias.StaleCause = "resource is in the trash"
+				break
+			}
+			if err != nil {
+				return
+			}
+			var segments []string
+			segments, err = txqry.GetPath(ctx, a.ResourceID)
+			if err != nil {
+				return
+			}
+			alias.Current = trailingPath(path.Join(append([]string{"/"}, segments...)...))
+		default:
+			return
+		}
+		err = nil
+		out = append(out, alias)
+	}
+	return
+}
+
+func (c Context) Aliases() (string, func(w http.ResponseWriter, r *http.Request)) {
+	tmpl, err := template.New("aliases").Parse(aliases_template)
+	if err != nil {
+		panic(err)
+	}
+	return "/_aliases", c.withTx(&sql.TxOptions{
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		ctx := r.Context()
+		aliases, err := listAliases(ctx, txqry)
+		if err != nil {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			stale := 0
+			for _, a := range aliases {
+				if a.Stale {
+					stale++
+				}
+			}
+			err = tmpl.Execute(w, AliasesProps{
+				Aliases: aliases,
+				Stale:   stale,
+			})
+			return
+		case http.MethodPost:
+			// a single alias is removed if it is given, otherwise all stale
+			// aliases are
+			if p := r.FormValue("path"); p != "" {
+				err = txqry.DeletePathAlias(ctx, p)
+			} else {
+				for _, a := range aliases {
+					if !a.Stale {
+						continue
+					}
+					err = txqry.DeletePathAlias(ctx, a.Path)
+					if err != nil {
+						return
+					}
+				}
+			}
+			if err != nil {
+				return
+			}
+			w.Header().Set("Location", "/_aliases")
+			w.WriteHeader(303)
+			return
+		default:
+			err = methodNotAllowed(r, http.MethodGet, http.MethodPost)
+			return
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"item-archive-d/internal/db"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirectAlias(t *testing.T) {
+	ctx := t.Context()
+	router := newTestContext(t)
+	h := router.routes()
+	box, err := router.qry.CreateResource(ctx, db.CreateResourceParams{Name: "box", Type: "container"})
+	require.NoError(t, err)
+	old, err := router.qry.CreateResource(ctx, db.CreateResourceParams{
+		ParentID: sql.NullInt64{Int64: box, Valid: true},
+		Name:     "old",
+		Type:     "container",
+	})
+	require.NoError(t, err)
+	_, err = router.qry.CreateResource(ctx, db.CreateResourceParams{
+		ParentID: sql.NullInt64{Int64: old, Valid: true},
+		Name:     "inner",
+		Type:     "item",
+	})
+	require.NoError(t, err)
+
+	// rename with the form first and with the API after, the first alias
+	// needs a second hop
+	w := serve(h, formRequest(t, "/_update/box/old", map[string]string{"name": "new", "type": "container"}))
+	require.Equal(t, 303, w.Code, w.Body.String())
+	w = apiRequest(t, h, "PATCH", fmt.Sprintf("/api/v1/resources/%d", old), `{"name": "newer"}`, nil)
+	require.Equal(t, 200, w.Code, w.Body.String())
+
+	for _, tc := range []struct {
+		method   string
+		target   string
+		status   int
+		location string
+	}{
+		{"GET", "/box/old/", 301, "/box/newer/"},
+		{"GET", "/box/new/", 301, "/box/newer/"},
+		{"HEAD", "/box/old/", 301, "/box/newer/"},
+		{"GET", "/box/old/inner?sort=name&order=desc", 301, "/box/newer/inner?sort=name&order=desc"},
+		{"GET", "/_edit/box/old", 301, "/_edit/box/newer"},
+		{"GET", "/api/v1/paths/box/old/inner", 301, "/api/v1/paths/box/newer/inner"},
+		{"GET", "/api/v1/children/box/new", 301, "/api/v1/children/box/newer"},
+		// form submissions must stay POST requests
+		{"POST", "/_update/box/old", 308, "/_update/box/newer"},
+		{"POST", "/_delete_deep/box/old/inner", 308, "/_delete_deep/box/newer/inner"},
+		{"POST", "/_intake/box/old", 308, "/_intake/box/newer"},
+		{"GET", "/box/missing/", 404, ""},
+		{"GET", "/api/v1/paths/missing", 404, ""},
+		{"POST", "/_update/box/missing", 404, ""},
+		{"POST", "/_intake/box/missing", 404, ""},
+	} {
+		t.Run(tc.method+" "+tc.target, func(t *testing.T) {
+			w := serve(h, httptest.NewRequest(tc.method, tc.target, nil))
+			require.Equal(t, tc.status, w.Code, w.Body.String())
+			require.Equal(t, tc.location, w.Header().Get("Location"))
+		})
+	}
+
+	// a resource created at an old path takes it over
+	_, err = router.qry.CreateResource(ctx, db.CreateResourceParams{
+		ParentID: sql.NullInt64{Int64: box, Valid: true},
+		Name:     "old",
+		Type:     "item",
+	})
+	require.NoError(t, err)
+	w = serve(h, httptest.NewRequest("GET", "/api/v1/paths/box/old", nil))
+	require.Equal(t, 200, w.Code, w.Body.String())
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"item-archive-d/internal/db"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// isApiRequest reports whether the request was made against the JSON API
+func isApiRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+type apiResource struct {
+	ID       int64   `json:"id"`
+	ParentID *int64  `json:"parent_id"`
+	Path     string  `json:"path"`
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Comments string  `json:"comments"`
+	Image    *string `json:"image"`
+	// timestamps are null for resources created before they were recorded
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+type apiAttachment struct {
+	ID       int64  `json:"id"`
+	Filename string `json:"filename"`
+	URL      string `json:"url"`
+}
+
+// apiSearchResult is a resource which matched a search, along with the
+// attachment whose text matched if the resource itself did not
+type apiSearchResult struct {
+	apiResource
+	Attachment *apiAttachment `json:"attachment,omitempty"`
+}
+
+// toApiResource converts a resource to its JSON representation, parent is the
+// path of the resource's parent
+func toApiResource(parent string, r db.Resource) apiResource {
+	out := apiResource{
+		ID:       r.ID,
+		Path:     path.Join("/", parent, r.Name),
+		Name:     r.Name,
+		Type:     r.Type,
+		Comments: r.Comments,
+	}
+	if r.ParentID.Valid {
+		out.ParentID = &r.ParentID.Int64
+	}
+	if r.Image.Valid {
+		src := path.Join("/_image", r.Image.String)
+		out.Image = &src
+	}
+	if r.CreatedAt != 0 {
+		t := time.Unix(r.CreatedAt, 0).UTC()
+		out.CreatedAt = &t
+	}
+	if r.UpdatedAt != 0 {
+		t := time.Unix(r.UpdatedAt, 0).UTC()
+		out.UpdatedAt = &t
+	}
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+func readJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err != nil {
+		return validationf("invalid request body: %v", err)
+	}
+	return nil
+}
+
+func validType(t string) bool {
+	return t == "item" || t == "container"
+}
+
+// apiGetResource fetches a resource and its path, a missing resource results
+// in a 404
+func apiGetResource(r *http.Request, txqry *db.Queries, id int64) (out apiResource, err error) {
+	ctx := r.Context()
+	resource, err := txqry.GetResource(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = notFoundf("unknown resource: %d", id)
+		return
+	}
+	if err != nil {
+		return
+	}
+	segments, err := txqry.GetPath(ctx, id)
+	if err != nil {
+		return
+	}
+	out = toApiResource(strings.Join(segments[:len(segments)-1], "/"), resource)
+	return
+}
+
+func apiPathID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return 0, validationf("invalid resource id: %s", r.PathValue("id"))
+	}
+	return id, nil
+}
+
+func (c Context) ApiNotFound() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, notFoundf("unknown endpoint: %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func (c Context) ApiGet() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "GET /api/v1/resources/{id}", c.withTx(&sql.TxOptions{
+		// multiple reads
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		id, err := apiPathID(r)
+		if err != nil {
+			return
+		}
+		resource, err := apiGetResource(r, txqry, id)
+		if err != nil {
+			return
+		}
+		err = writeJSON(w, 200, resource)
+		return
+	})
+}
+
+func (c Context) ApiGetByPath() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "GET /api/v1/paths/{path...}", c.withTx(&sql.TxOptions{
+		// multiple reads
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		ctx := r.Context()
+		p := r.PathValue("path")
+		id, err := txqry.Resolve(ctx, p)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && !id.Valid {
+			err = redirectAlias(ctx, txqry, w, r, "/api/v1/paths", p)
+			return
+		}
+		if err != nil {
+			return
+		}
+		resource, err := apiGetResource(r, txqry, id.Int64)
+		if err != nil {
+			return
+		}
+		err = writeJSON(w, 200, resource)
+		return
+	})
+}
+
+// apiWriteChildren writes the children of the resource at parentPath
+func apiWriteChildren(w http.ResponseWriter, r *http.Request, txqry *db.Queries, parentPath string, parentID sql.NullInt64) (err error) {
+	rows, err := txqry.ListResources(r.Context(), parentID)
+	if err != nil {
+		return
+	}
+	out := make([]apiResource, len(rows))
+	for i, row := range rows {
+		out[i] = toApiResource(parentPath, row)
+	}
+	err = writeJSON(w, 200, out)
+	return
+}
+
+func (c Context) ApiChildren() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "GET /api/v1/resources/{id}/children", c.withTx(&sql.TxOptions{
+		// multiple reads
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		id, err := apiPathID(r)
+		if err != nil {
+			return
+		}
+		// GetPath also finds trashed resources
+		_, err = txqry.GetResource(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = notFoundf("unknown resource: %d", id)
+			return
+		}
+		if err != nil {
+			return
+		}
+		segments, err := txqry.GetPath(r.Context(), id)
+		if err != nil {
+			return
+		}
+		err = apiWriteChildren(w, r, txqry, strings.Join(segments, "/"), sql.NullInt64{Int64: id, Valid: true})
+		return
+	})
+}
+
+func (c Context) ApiChildrenByPath() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "GET /api/v1/children/{path...}", c.withTx(&sql.TxOptions{
+		// multiple reads
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		p := r.PathValue("path")
+		parentID, err := txqry.Resolve(r.Context(), p)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = redirectAlias(r.Context(), txqry, w, r, "/api/v1/children", p)
+			return
+		}
+		if err != nil {
+			return
+		}
+		err = apiWriteChildren(w, r, txqry, p, parentID)
+		return
+	})
+}
+
+type apiCreateRequest struct {
+	ParentID   *int64 `json:"parent_id"`
+	ParentPath string `json:"parent_path"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Comments   string `json:"comments"`
+}
+
+func (c Context) ApiCreate() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "POST /api/v1/resources", c.withTx(&sql.TxOptions{
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		ctx := r.Context()
+		var req apiCreateRequest
+		err = readJSON(r, &req)
+		if err != nil {
+			return
+		}
+		if !db.ValidName(req.Name) {
+			err = validationf("invalid name: %q, names must not be empty or contain '/'", req.Name)
+			return
+		}
+		if !validType(req.Type) {
+			err = validationf("invalid resource type: %q", req.Type)
+			return
+		}
+
+		var parentID sql.NullInt64
+		switch {
+		case req.ParentID != nil && req.ParentPath != "":
+			err = validationf("only one of parent_id and parent_path may be specified")
+			return
+		case req.ParentID != nil:
+			_, err = txqry.GetResource(ctx, *req.ParentID)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = notFoundf("unknown parent: %d", *req.ParentID)
+				return
+			}
+			if err != nil {
+				return
+			}
+			parentID = sql.NullInt64{Int64: *req.ParentID, Valid: true}
+		default:
+			parentID, err = txqry.Resolve(ctx, req.ParentPath)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = notFoundf("unknown parent: %s", req.ParentPath)
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+
+		id, err := txqry.CreateResource(ctx, db.CreateResourceParams{
+			ParentID: parentID,
+			Name:     req.Name,
+			Type:     req.Type,
+			Comments: req.Comments,
+		})
+		if err != nil {
+			err = nameConflict(err, "a resource named %q already exists in the parent", req.Name)
+			return
+		}
+		resource, err := apiGetResource(r, txqry, id)
+		if err != nil {
+			return
+		}
+		w.Header().Set("Location", path.Join("/api/v1/resources", strconv.FormatInt(id, 10)))
+		err = writeJSON(w, 201, resource)
+		return
+	})
+}
+
+// apiUpdateRequest only changes the fields which are specified
+type apiUpdateRequest struct {
+	Name     *string `json:"name"`
+	Type     *string `json:"type"`
+	Comments *string `json:"comments"`
+}
+
+func (c Context) ApiUpdate() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "PATCH /api/v1/resources/{id}", c.withTx(&sql.TxOptions{
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		ctx := r.Context()
+		id, err := apiPathID(r)
+		if err != nil {
+			return
+		}
+		var req apiUpdateRequest
+		err = readJSON(r, &req)
+		if err != nil {
+			return
+		}
+
+		existing, err := txqry.GetResource(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = notFoundf("unknown resource: %d", id)
+			return
+		}
+		if err != nil {
+			return
+		}
+		params := db.UpdateResourceParams{
+			ID:       id,
+			Name:     existing.Name,
+			Type:     existing.Type,
+			Comments: existing.Comments,
+		}
+		if req.Name != nil {
+			if !db.ValidName(*req.Name) {
+				err = validationf("invalid name: %q, names must not be empty or contain '/'", *req.Name)
+				return
+			}
+			if *req.Name != existing.Name {
+				err = rememberPath(ctx, txqry, id)
+				if err != nil {
+					return
+				}
+			}
+			params.Name = *req.Name
+		}
+		if req.Type != nil {
+			if !validType(*req.Type) {
+				err = validationf("invalid resource type: %q", *req.Type)
+				return
+			}
+			params.Type = *req.Type
+		}
+		if req.Comments != nil {
+			params.Comments = *req.Comments
+		}
+
+		updated, err := txqry.UpdateResource(ctx, params)
+		if err != nil {
+			err = nameConflict(err, "a resource named %q already exists in the parent", params.Name)
+			return
+		}
+		if len(updated) != 1 {
+			err = fmt.Errorf("update failed, changed: %v", updated)
+			return
+		}
+		resource, err := apiGetResource(r, txqry, id)
+		if err != nil {
+			return
+		}
+		err = writeJSON(w, 200, resource)
+		return
+	})
+}
+
+type apiMoveRequest struct {
+	IDs []int64 `json:"ids"`
+	// ParentID is the destination, null moves the resources to the root
+	ParentID *int64 `json:"parent_id"`
+}
+
+func (c Context) ApiMove() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "POST /api/v1/move", c.withTx(&sql.TxOptions{
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		ctx := r.Context()
+		var req apiMoveRequest
+		err = readJSON(r, &req)
+		if err != nil {
+			return
+		}
+		if len(req.IDs) == 0 {
+			err = validationf("ids must not be empty")
+			return
+		}
+
+		to := "/"
+		var toID sql.NullInt64
+		if req.ParentID != nil {
+			// GetPath also finds trashed resources
+			_, err = txqry.GetResource(ctx, *req.ParentID)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = notFoundf("unknown parent: %d", *req.ParentID)
+				return
+			}
+			if err != nil {
+				return
+			}
+			var segments []string
+			segments, err = txqry.GetPath(ctx, *req.ParentID)
+			if err != nil {
+				return
+			}
+			to = strings.Join(segments, "/")
+			toID = sql.NullInt64{Int64: *req.ParentID, Valid: true}
+		}
+
+		for _, id := range req.IDs {
+			var existing db.Resource
+			existing, err = txqry.GetResource(ctx, id)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = notFoundf("unknown resource: %d", id)
+				return
+			}
+			if err != nil {
+				return
+			}
+			var segments []string
+			segments, err = txqry.GetPath(ctx, id)
+			if err != nil {
+				return
+			}
+			fullpath := strings.Join(segments, "/")
+			if hasAncestor(fullpath, to) {
+				err = conflictf("cannot move resource '%s' into its own subtree '%s'", fullpath, to)
+				return
+			}
+			if existing.ParentID != toID {
+				err = rememberPath(ctx, txqry, id)
+				if err != nil {
+					return
+				}
+			}
+		}
+
+		changed, err := txqry.MoveResources(ctx, db.MoveResourcesParams{
+			Ids:       req.IDs,
+			NewParent: toID,
+		})
+		if err != nil {
+			err = nameConflict(err, "a resource with the same name as one of the moved resources already exists in the parent")
+			return
+		}
+		out := make([]apiResource, 0, len(changed))
+		for _, id := range req.IDs {
+			if !slices.Contains(changed, id) {
+				err = fmt.Errorf("move failed, changed: %v", changed)
+				return
+			}
+			var resource apiResource
+			resource, err = apiGetResource(r, txqry, id)
+			if err != nil {
+				return
+			}
+			out = append(out, resource)
+		}
+		err = writeJSON(w, 200, out)
+		return
+	})
+}
+
+func (c Context) ApiDelete() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "DELETE /api/v1/resources/{id}", c.withTx(&sql.TxOptions{
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		ctx := r.Context()
+		id, err := apiPathID(r)
+		if err != nil {
+			return
+		}
+		resource, err := txqry.GetResource(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = notFoundf("unknown resource: %d", id)
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		// keep_children=true behaves like DeleteShallow, otherwise the
+		// whole subtree is moved to the trash like DeleteDeep
+		err = trashResource(ctx, txqry, resource, r.URL.Query().Get("keep_children") == "true")
+		if err != nil {
+			return
+		}
+		w.WriteHeader(204)
+		return
+	})
+}
+
+func (c Context) ApiSearch() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "GET /api/v1/search", c.withTx(&sql.TxOptions{
+		// phantom reads not possible since initial search contains all results
+		// that will be searched anyway
+		Isolation: sql.LevelReadCommitted,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		ctx := r.Context()
+		query := r.URL.Query().Get("q")
+		if query == "" {
+			err = validationf("missing query parameter: q")
+			return
+		}
+		// every result is returned unless a limit is given
+		limit, err := queryInt(r.URL.Query(), "limit", -1)
+		if err != nil {
+			return
+		}
+		offset, err := queryInt(r.URL.Query(), "offset", 0)
+		if err != nil {
+			return
+		}
+		resources, total, _, err := searchResources(ctx, txqry, query, sql.NullInt64{}, limit, offset)
+		if err != nil {
+			return
+		}
+		ids := make([]int64, len(resources))
+		for i, resource := range resources {
+			ids[i] = resource.ID
+		}
+		paths, err := txqry.GetPaths(ctx, ids)
+		if err != nil {
+			return
+		}
+		out := make([]apiSearchResult, len(resources))
+		for i, resource := range resources {
+			segments := paths[resource.ID]
+			out[i].apiResource = toApiResource(strings.Join(segments[:len(segments)-1], "/"), resource.Resource)
+			if resource.AttachmentID.Valid {
+				out[i].Attachment = &apiAttachment{
+					ID:       resource.AttachmentID.Int64,
+					Filename: resource.AttachmentName.String,
+					URL:      path.Join("/_attachment", strconv.FormatInt(resource.AttachmentID.Int64, 10)),
+				}
+			}
+		}
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+		err = writeJSON(w, 200, out)
+		return
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// apiRequest sends a request with a JSON body to the API, the response is
+// decoded into out unless it is nil
+func apiRequest(t *testing.T, h http.Handler, method, target, body string, out any) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := serve(h, r)
+	if out != nil {
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), out), w.Body.String())
+	}
+	return w
+}
+
+func TestApi(t *testing.T) {
+	h := newTestContext(t).routes()
+
+	create := func(body string) apiResource {
+		var out apiResource
+		w := apiRequest(t, h, "POST", "/api/v1/resources", body, &out)
+		require.Equal(t, 201, w.Code, w.Body.String())
+		require.Equal(t, fmt.Sprintf("/api/v1/resources/%d", out.ID), w.Header().Get("Location"))
+		return out
+	}
+	garage := create(`{"name": "garage", "type": "container"}`)
+	require.Nil(t, garage.ParentID)
+	require.Equal(t, "/garage", garage.Path)
+	shelf := create(fmt.Sprintf(`{"parent_id": %d, "name": "shelf", "type": "container"}`, garage.ID))
+	drill := create(`{"parent_path": "/garage/shelf", "name": "drill", "type": "item", "comments": "cordless"}`)
+	require.Equal(t, shelf.ID, *drill.ParentID)
+	require.Equal(t, "/garage/shelf/drill", drill.Path)
+	require.Equal(t, "cordless", drill.Comments)
+
+	t.Run("invalid requests", func(t *testing.T) {
+		for _, test := range []struct {
+			method, target, body string
+			status               int
+		}{
+			{"POST", "/api/v1/resources", `{"name": "saw", "type": "tool"}`, 400},
+			{"POST", "/api/v1/resources", `{"name": "a/b", "type": "item"}`, 400},
+			{"POST", "/api/v1/resources", `{"name": "saw", "type": "item", "color": "red"}`, 400},
+			{"POST", "/api/v1/resources", `{"parent_id": 1, "parent_path": "/garage", "name": "saw", "type": "item"}`, 400},
+			{"POST", "/api/v1/resources", `{"parent_id": 999, "name": "saw", "type": "item"}`, 404},
+			{"POST", "/api/v1/resources", `{"parent_path": "/attic", "name": "saw", "type": "item"}`, 404},
+			{"POST", "/api/v1/resources", `{"parent_path": "/garage", "name": "shelf", "type": "item"}`, 409},
+			{"GET", "/api/v1/resources/abc", "", 400},
+			{"GET", "/api/v1/resources/999", "", 404},
+			{"PATCH", "/api/v1/resources/999", `{"name": "x"}`, 404},
+			{"POST", "/api/v1/move", `{"ids": []}`, 400},
+			{"GET", "/api/v1/search", "", 400},
+			{"GET", "/api/v1/unknown", "", 404},
+		} {
+			var out errorBody
+			w := apiRequest(t, h, test.method, test.target, test.body, &out)
+			require.Equal(t, test.status, w.Code, "%s %s %s", test.method, test.target, test.body)
+			require.NotEmpty(t, out.Error)
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		var out apiResource
+		w := apiRequest(t, h, "GET", fmt.Sprintf("/api/v1/resources/%d", drill.ID), "", &out)
+		require.Equal(t, 200, w.Code)
+		require.Equal(t, drill, out)
+		w = apiRequest(t, h, "GET", "/api/v1/paths/garage/shelf/drill", "", &out)
+		require.Equal(t, 200, w.Code)
+		require.Equal(t, drill, out)
+
+		var children []apiResource
+		w = apiRequest(t, h, "GET", fmt.Sprintf("/api/v1/resources/%d/children", shelf.ID), "", &children)
+		require.Equal(t, 200, w.Code)
+		require.Equal(t, []apiResource{drill}, children)
+		w = apiRequest(t, h, "GET", "/api/v1/children/garage", "", &children)
+		require.Equal(t, 200, w.Code)
+		require.Equal(t, []apiResource{shelf}, children)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		var out apiResource
+		w := apiRequest(t, h, "PATCH", fmt.Sprintf("/api/v1/resources/%d", drill.ID), `{"comments": "18V"}`, &out)
+		require.Equal(t, 200, w.Code, w.Body.String())
+		require.Equal(t, "drill", out.Name)
+		require.Equal(t, "18V", out.Comments)
+		drill = out
+	})
+
+	t.Run("move", func(t *testing.T) {
+		var errOut errorBody
+		w := apiRequest(t, h, "POST", "/api/v1/move", fmt.Sprintf(`{"ids": [%d], "parent_id": %d}`, garage.ID, shelf.ID), &errOut)
+		require.Equal(t, 409, w.Code)
+
+		var out []apiResource
+		w = apiRequest(t, h, "POST", "/api/v1/move", fmt.Sprintf(`{"ids": [%d]}`, drill.ID), &out)
+		require.Equal(t, 200, w.Code, w.Body.String())
+		require.Len(t, out, 1)
+		require.Nil(t, out[0].ParentID)
+		require.Equal(t, "/drill", out[0].Path)
+
+		// trashed resources cannot be moved into
+		w = apiRequest(t, h, "DELETE", fmt.Sprintf("/api/v1/resources/%d", shelf.ID), "", nil)
+		require.Equal(t, 204, w.Code)
+		w = apiRequest(t, h, "POST", "/api/v1/move", fmt.Sprintf(`{"ids": [%d], "parent_id": %d}`, drill.ID, shelf.ID), &errOut)
+		require.Equal(t, 404, w.Code)
+		w = apiRequest(t, h, "GET", fmt.Sprintf("/api/v1/resources/%d/children", shelf.ID), "", &errOut)
+		require.Equal(t, 404, w.Code)
+	})
+
+	t.Run("delete keeping children", func(t *testing.T) {
+		box := create(`{"name": "box", "type": "container"}`)
+		// the child takes the name of its parent once that is deleted
+		inner := create(`{"parent_path": "/box", "name": "box", "type": "item"}`)
+		w := apiRequest(t, h, "DELETE", fmt.Sprintf("/api/v1/resources/%d?keep_children=true", box.ID), "", nil)
+		require.Equal(t, 204, w.Code, w.Body.String())
+
+		var out apiResource
+		w = apiRequest(t, h, "GET", "/api/v1/paths/box", "", &out)
+		require.Equal(t, 200, w.Code)
+		require.Equal(t, inner.ID, out.ID)
+		w = apiRequest(t, h, "GET", fmt.Sprintf("/api/v1/resources/%d", box.ID), "", nil)
+		require.Equal(t, 404, w.Code)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		var out []apiSearchResult
+		w := apiRequest(t, h, "GET", "/api/v1/search?q=18V&limit=1", "", &out)
+		require.Equal(t, 200, w.Code, w.Body.String())
+		require.Equal(t, "1", w.Header().Get("X-Total-Count"))
+		require.Len(t, out, 1)
+		require.Equal(t, drill.ID, out[0].ID)
+		require.Nil(t, out[0].Attachment)
+	})
+}
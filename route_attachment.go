@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"item-archive-d/internal/db"
+	"item-archive-d/internal/extract"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// attachmentName returns the name an uploaded file is downloaded as, browsers
+// may send the full path of the file on the client
+func attachmentName(header *multipart.FileHeader) string {
+	name := header.Filename
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "" || name == "." || name == ".." {
+		return "attachment"
+	}
+	return name
+}
+
+// attachmentType returns the media type of an uploaded file, the type given
+// by the browser is preferred over guessing it from the name of the file
+func attachmentType(header *multipart.FileHeader, f multipart.File) (string, error) {
+	contentType := header.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && mediaType != "application/octet-stream" {
+		return contentType, nil
+	}
+	if byExt := mime.TypeByExtension(filepath.Ext(header.Filename)); byExt != "" {
+		return byExt, nil
+	}
+	return sniffContentType(f)
+}
+
+// indexAttachment adds the text of an attachment to the search index, files
+// whose text cannot be read are still attached but cannot be searched
+func indexAttachment(ctx context.Context, txqry *db.Queries, attachment db.Attachment, r io.ReaderAt) error {
+	text, err := extract.Text(r, attachment.Size, attachment.MimeType)
+	if errors.Is(err, extract.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("extract: attachment %d (%s): %v", attachment.ID, attachment.Filename, err)
+		return nil
+	}
+	return txqry.IndexAttachment(ctx, db.IndexAttachmentParams{
+		Rowid:   attachment.ID,
+		Content: text,
+	})
+}
+
+// addAttachment stores a single uploaded file and attaches it to the resource
+func addAttachment(ctx context.Context, c Context, txqry *db.Queries, resourceID int64, upload *multipart.FileHeader, f multipart.File) (err error) {
+	mimeType, err := attachmentType(upload, f)
+	if err != nil {
+		return
+	}
+	blobID, err := c.blobs.Store(f)
+	if err != nil {
+		return
+	}
+	attachment := db.Attachment{
+		ResourceID: resourceID,
+		BlobID:     blobID,
+		Filename:   attachmentName(upload),
+		MimeType:   mimeType,
+		Size:       upload.Size,
+	}
+	attachment.ID, err = txqry.CreateAttachment(ctx, db.CreateAttachmentParams{
+		ResourceID: attachment.ResourceID,
+		BlobID:     attachment.BlobID,
+		Filename:   attachment.Filename,
+		MimeType:   attachment.MimeType,
+		Size:       attachment.Size,
+	})
+	if err != nil {
+		return
+	}
+	return indexAttachment(ctx, txqry, attachment, f)
+}
+
+// addAttachments stores the uploaded files and attaches them to the resource
+func addAttachments(c Context, txqry *db.Queries, r *http.Request, resourceID int64) (err error) {
+	ctx := r.Context()
+	uploads := r.MultipartForm.File["attachments"]
+	if len(uploads) == 0 {
+		err = validationf("missing attachments")
+		return
+	}
+	for _, upload := range uploads {
+		var f multipart.File
+		f, err = upload.Open()
+		if err != nil {
+			return
+		}
+		err = addAttachment(ctx, c, txqry, resourceID, upload, f)
+		f.Close()
+		if err != nil {
+			return
+		}
+	}
+	return txqry.TouchResource(ctx, resourceID)
+}
+
+// Attachment downloads an attachment under the name it was uploaded with
+func (c Context) Attachment() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "/_attachment/{id}", c.withTx(&sql.TxOptions{
+		// single read
+		Isolation: sql.LevelReadCommitted,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			err = methodNotAllowed(r, http.MethodGet, http.MethodHead)
+			return
+		}
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			err = notFoundf("unknown attachment: %s", r.PathValue("id"))
+			return
+		}
+		attachment, err := txqry.GetAttachment(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = notFoundf("unknown attachment: %d", id)
+			return
+		}
+		if err != nil {
+			return
+		}
+		f, err := c.blobs.Open(attachment.BlobID)
+		if os.IsNotExist(err) {
+			err = notFoundf("the contents of attachment %d are missing", id)
+			return
+		}
+		if err != nil {
+			return
+		}
+		defer f.Close()
+
+		header := w.Header()
+		header.Set("Content-Type", attachment.MimeType)
+		header.Set("X-Content-Type-Options", "nosniff")
+		// non-ascii names are encoded as "filename*" by FormatMediaType
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": attachment.Filename,
+		}))
+		header.Set("ETag", `"`+attachment.BlobID+`"`)
+		http.ServeContent(w, r, attachment.Filename, time.Time{}, f)
+		return
+	})
+}
+
+// Attachments adds and removes the attachments of a resource
+func (c Context) Attachments() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "/_attachments/{path...}", c.withTx(&sql.TxOptions{
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		if r.Method != http.MethodPost {
+			err = methodNotAllowed(r, http.MethodPost)
+			return
+		}
+		ctx := r.Context()
+
+		p := r.PathValue("path")
+		id, err := txqry.Resolve(ctx, p)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && !id.Valid {
+			err = redirectAlias(ctx, txqry, w, r, "/_attachments", p)
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		err = invalidForm(r.ParseMultipartForm(10 * 1000 * 1000 * 1000))
+		if err != nil {
+			return
+		}
+
+		switch action := first(r.MultipartForm.Value, "action"); action {
+		case "add":
+			err = addAttachments(c, txqry, r, id.Int64)
+		case "remove":
+			var attachmentID int64
+			attachmentID, err = strconv.ParseInt(first(r.MultipartForm.Value, "attachment"), 10, 64)
+			if err != nil {
+				err = validationf("invalid attachment: %q", first(r.MultipartForm.Value, "attachment"))
+				return
+			}
+			var attachment db.Attachment
+			attachment, err = txqry.GetAttachment(ctx, attachmentID)
+			if errors.Is(err, sql.ErrNoRows) || err == nil && attachment.ResourceID != id.Int64 {
+				err = notFoundf("unknown attachment: %d", attachmentID)
+				return
+			}
+			if err != nil {
+				return
+			}
+			err = txqry.DeleteAttachment(ctx, attachmentID)
+			if err != nil {
+				return
+			}
+			err = txqry.TouchResource(ctx, id.Int64)
+		default:
+			err = validationf("invalid action: %q", action)
+		}
+		if err != nil {
+			return
+		}
+
+		w.Header().Set("Location", path.Join("/_edit", p))
+		w.WriteHeader(303)
+		return
+	})
+}
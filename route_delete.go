@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"html/template"
 	"item-archive-d/internal/db"
 	"net/http"
@@ -47,6 +48,7 @@ const delete_confirm_template = `<!DOCTYPE html>
 		<h4>Deleting: {{.Path}}</h4>
 		<input formaction="{{.ActionDeep}}" type="submit" value="Delete resource and children">
 		<input formaction="{{.ActionShallow}}" type="submit" value="Delete resource, keep children">
+		<p>Deleted resources are moved to the <a href="/_trash">trash</a>.</p>
 	</form>
 </body>
 </html>`
@@ -70,41 +72,32 @@ func (c Context) DeleteConfirm() (string, func(w http.ResponseWriter, r *http.Re
 
 func (c Context) DeleteShallow() (string, func(w http.ResponseWriter, r *http.Request)) {
 	return "/_delete_shallow/{path...}", c.withTx(&sql.TxOptions{
-		// single read
-		Isolation: sql.LevelReadCommitted,
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
 	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
 		if r.Method != http.MethodPost {
-			w.WriteHeader(400)
-			w.Write([]byte("unsupported method"))
+			err = methodNotAllowed(r, http.MethodPost)
 			return
 		}
 		ctx := r.Context()
 		p := r.PathValue("path")
 		id, err := txqry.Resolve(ctx, p)
-		if err != nil {
-			return
-		}
-		if !id.Valid {
-			w.WriteHeader(400)
-			w.Write([]byte("Cannot delete non-existant resource."))
+		if errors.Is(err, sql.ErrNoRows) || err == nil && !id.Valid {
+			err = redirectAlias(ctx, txqry, w, r, "/_delete_shallow", p)
 			return
 		}
-		resource, err := txqry.GetResource(ctx, id.Int64)
 		if err != nil {
 			return
 		}
-		err = txqry.ChangeParent(ctx, db.ChangeParentParams{
-			OldParent: sql.NullInt64{Int64: resource.ID, Valid: true},
-			NewParent: resource.ParentID,
-		})
+		resource, err := txqry.GetResource(ctx, id.Int64)
 		if err != nil {
 			return
 		}
-		err = txqry.DeleteResource(ctx, id.Int64)
+		err = trashResource(ctx, txqry, resource, true)
 		if err != nil {
 			return
 		}
-		w.Header().Set("Location", trailingPath(path.Join("/", path.Dir(p))))
+		w.Header().Set("Location", withUndo(r, trailingPath(path.Join("/", path.Dir(p)))))
 		w.WriteHeader(303)
 		return
 	})
@@ -112,30 +105,32 @@ func (c Context) DeleteShallow() (string, func(w http.ResponseWriter, r *http.Re
 
 func (c Context) DeleteDeep() (string, func(w http.ResponseWriter, r *http.Request)) {
 	return "/_delete_deep/{path...}", c.withTx(&sql.TxOptions{
-		// single read
-		Isolation: sql.LevelReadCommitted,
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
 	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
 		if r.Method != http.MethodPost {
-			w.WriteHeader(400)
-			w.Write([]byte("unsupported method"))
+			err = methodNotAllowed(r, http.MethodPost)
 			return
 		}
 		ctx := r.Context()
 		p := r.PathValue("path")
 		id, err := txqry.Resolve(ctx, p)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && !id.Valid {
+			err = redirectAlias(ctx, txqry, w, r, "/_delete_deep", p)
+			return
+		}
 		if err != nil {
 			return
 		}
-		if !id.Valid {
-			w.WriteHeader(400)
-			w.Write([]byte("Cannot delete non-existant resource."))
+		resource, err := txqry.GetResource(ctx, id.Int64)
+		if err != nil {
 			return
 		}
-		err = txqry.DeleteResource(ctx, id.Int64)
+		err = trashResource(ctx, txqry, resource, false)
 		if err != nil {
 			return
 		}
-		w.Header().Set("Location", trailingPath(path.Join("/", path.Dir(p))))
+		w.Header().Set("Location", withUndo(r, trailingPath(path.Join("/", path.Dir(p)))))
 		w.WriteHeader(303)
 		return
 	})
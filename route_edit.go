@@ -2,21 +2,46 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"html/template"
 	"item-archive-d/internal/db"
 	"net/http"
 	"path"
+	"strconv"
 )
 
+type EditProps_Image struct {
+	ID        int64
+	Src       string
+	Href      string
+	IsPrimary bool
+	IsFirst   bool
+	IsLast    bool
+}
+
+type EditProps_Attachment struct {
+	ID       int64
+	Filename string
+	MimeType string
+	Size     string
+	Href     string
+}
+
 type EditProps struct {
-	Path        string
-	Cancel      string
-	Action      string
-	Name        string
-	Comments    string
-	IsItem      bool
-	IsContainer bool
+	Path          string
+	Cancel        string
+	HistoryHref   string
+	Permalink     string
+	Action        string
+	GalleryAction string
+	AttachAction  string
+	Name          string
+	Comments      string
+	IsItem        bool
+	IsContainer   bool
+	Images        []EditProps_Image
+	Attachments   []EditProps_Attachment
 }
 
 const edit_template = `<!DOCTYPE html>
@@ -53,7 +78,7 @@ const edit_template = `<!DOCTYPE html>
 </head>
 
 <body>
-	<a href="{{.Cancel}}">&lt;&lt; Cancel</a>
+	<a href="{{.Cancel}}">&lt;&lt; Cancel</a> / <a href="{{.HistoryHref}}">History</a> / <a href="{{.Permalink}}">Permalink</a>
 
 	<hr>
 
@@ -67,10 +92,6 @@ const edit_template = `<!DOCTYPE html>
 			<label for="comments">Comments:</label>
 			<textarea name="comments" id="comments" placeholder="Comments">{{.Comments}}</textarea>
 		</div>
-		<div>
-			<label for="image">Image:</label>
-			<input type="file" name="image" id="image">
-		</div>
 		<div>
 			<label for="type">Type:</label>
 			<select name="type" id="type-select">
@@ -80,6 +101,65 @@ const edit_template = `<!DOCTYPE html>
 		</div>
 		<input type="submit" value="Submit">
 	</form>
+
+	<hr>
+
+	<h4>Images</h4>
+	<table>
+		<tbody>
+			{{range .Images}}
+			<tr>
+				<td><a href="{{.Href}}"><img src="{{.Src}}" alt="Image of {{$.Name}}" loading="lazy"></a></td>
+				<td>
+					<form action="{{$.GalleryAction}}" method="post" enctype="multipart/form-data">
+						<input type="hidden" name="image" value="{{.ID}}">
+						{{if .IsPrimary}}
+							<span>Primary</span>
+						{{else}}
+							<button type="submit" name="action" value="primary">Make primary</button>
+						{{end}}
+						<button type="submit" name="action" value="up" {{if .IsFirst}}disabled{{end}}>Up</button>
+						<button type="submit" name="action" value="down" {{if .IsLast}}disabled{{end}}>Down</button>
+						<button type="submit" name="action" value="remove">Remove</button>
+					</form>
+				</td>
+			</tr>
+			{{end}}
+		</tbody>
+	</table>
+	<form action="{{.GalleryAction}}" method="post" enctype="multipart/form-data">
+		<input type="hidden" name="action" value="add">
+		<label for="images">Add images:</label>
+		<input type="file" name="images" id="images" accept="image/*" multiple required>
+		<input type="submit" value="Upload">
+	</form>
+
+	<hr>
+
+	<h4>Attachments</h4>
+	<table>
+		<tbody>
+			{{range .Attachments}}
+			<tr>
+				<td><a href="{{.Href}}">{{.Filename}}</a></td>
+				<td>{{.MimeType}}</td>
+				<td>{{.Size}}</td>
+				<td>
+					<form action="{{$.AttachAction}}" method="post" enctype="multipart/form-data">
+						<input type="hidden" name="attachment" value="{{.ID}}">
+						<button type="submit" name="action" value="remove">Remove</button>
+					</form>
+				</td>
+			</tr>
+			{{end}}
+		</tbody>
+	</table>
+	<form action="{{.AttachAction}}" method="post" enctype="multipart/form-data">
+		<input type="hidden" name="action" value="add">
+		<label for="attachments">Add attachments:</label>
+		<input type="file" name="attachments" id="attachments" multiple required>
+		<input type="submit" value="Upload">
+	</form>
 </body>
 </html>`
 
@@ -92,34 +172,70 @@ func (c Context) Edit() (string, func(w http.ResponseWriter, r *http.Request)) {
 		Isolation: sql.LevelReadCommitted,
 	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
 		if r.Method != http.MethodGet {
-			w.WriteHeader(400)
-			w.Write([]byte("unsupported method"))
+			err = methodNotAllowed(r, http.MethodGet)
 			return
 		}
 		ctx := r.Context()
 
 		p := r.PathValue("path")
 		id, err := txqry.Resolve(ctx, p)
-		if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || err == nil && !id.Valid {
+			err = redirectAlias(ctx, txqry, w, r, "/_edit", p)
 			return
 		}
-		if !id.Valid {
-			err = fmt.Errorf("unknown resource: %s", p)
+		if err != nil {
 			return
 		}
 		resource, err := txqry.GetResource(ctx, id.Int64)
 		if err != nil {
 			return
 		}
+		gallery, err := txqry.ListResourceImages(ctx, id.Int64)
+		if err != nil {
+			return
+		}
+		images := make([]EditProps_Image, len(gallery))
+		for i, img := range gallery {
+			images[i] = EditProps_Image{
+				ID: img.ID,
+				// images are displayed at most 80px wide, 160px keeps them
+				// sharp on high density screens
+				Src:       path.Join("/_image", img.Image) + "?w=160",
+				Href:      path.Join("/_image", img.Image),
+				IsPrimary: img.IsPrimary,
+				IsFirst:   i == 0,
+				IsLast:    i == len(gallery)-1,
+			}
+		}
+		stored, err := txqry.ListAttachments(ctx, id.Int64)
+		if err != nil {
+			return
+		}
+		attachments := make([]EditProps_Attachment, len(stored))
+		for i, a := range stored {
+			attachments[i] = EditProps_Attachment{
+				ID:       a.ID,
+				Filename: a.Filename,
+				MimeType: a.MimeType,
+				Size:     formatSize(a.Size),
+				Href:     path.Join("/_attachment", strconv.FormatInt(a.ID, 10)),
+			}
+		}
 
 		err = tmpl.Execute(w, EditProps{
-			Path:        p,
-			Cancel:      trailingPath(path.Join("/", path.Dir(p))),
-			Action:      path.Join("/_update", p),
-			Name:        resource.Name,
-			Comments:    resource.Comments,
-			IsItem:      resource.Type == "item",
-			IsContainer: resource.Type == "container",
+			Path:          p,
+			Cancel:        trailingPath(path.Join("/", path.Dir(p))),
+			HistoryHref:   path.Join("/_history", strconv.FormatInt(id.Int64, 10)),
+			Permalink:     permalink(id.Int64),
+			Action:        path.Join("/_update", p),
+			GalleryAction: path.Join("/_gallery", p),
+			AttachAction:  path.Join("/_attachments", p),
+			Name:          resource.Name,
+			Comments:      resource.Comments,
+			IsItem:        resource.Type == "item",
+			IsContainer:   resource.Type == "container",
+			Images:        images,
+			Attachments:   attachments,
 		})
 		return
 	})
@@ -131,23 +247,22 @@ func (c Context) Update() (string, func(w http.ResponseWriter, r *http.Request))
 		Isolation: sql.LevelReadUncommitted,
 	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
 		if r.Method != http.MethodPost {
-			w.WriteHeader(400)
-			w.Write([]byte("unsupported method"))
+			err = methodNotAllowed(r, http.MethodPost)
 			return
 		}
 		ctx := r.Context()
 
 		p := r.PathValue("path")
 		id, err := txqry.Resolve(ctx, p)
-		if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || err == nil && !id.Valid {
+			err = redirectAlias(ctx, txqry, w, r, "/_update", p)
 			return
 		}
-		if !id.Valid {
-			err = fmt.Errorf("unknown resource")
+		if err != nil {
 			return
 		}
 
-		err = r.ParseMultipartForm(10 * 1000 * 1000 * 1000)
+		err = invalidForm(r.ParseMultipartForm(10 * 1000 * 1000 * 1000))
 		if err != nil {
 			return
 		}
@@ -155,6 +270,21 @@ func (c Context) Update() (string, func(w http.ResponseWriter, r *http.Request))
 		name := first(r.MultipartForm.Value, "name")
 		resourceType := first(r.MultipartForm.Value, "type")
 		comments := first(r.MultipartForm.Value, "comments")
+		if !db.ValidName(name) {
+			err = validationf("invalid name: %q, names must not be empty or contain '/'", name)
+			return
+		}
+		if !validType(resourceType) {
+			err = validationf("invalid resource type: %q", resourceType)
+			return
+		}
+
+		if name != path.Base(path.Join("/", p)) {
+			err = rememberPath(ctx, txqry, id.Int64)
+			if err != nil {
+				return
+			}
+		}
 
 		updated, err := txqry.UpdateResource(ctx, db.UpdateResourceParams{
 			ID:       id.Int64,
@@ -163,6 +293,7 @@ func (c Context) Update() (string, func(w http.ResponseWriter, r *http.Request))
 			Comments: comments,
 		})
 		if err != nil {
+			err = nameConflict(err, "a resource named %q already exists in %s", name, trailingPath(path.Join("/", path.Dir(p))))
 			return
 		}
 		if len(updated) != 1 {
@@ -170,26 +301,7 @@ func (c Context) Update() (string, func(w http.ResponseWriter, r *http.Request))
 			return
 		}
 
-		image := first(r.MultipartForm.File, "image")
-		imageID, err := handleImageUpload(c.blobs, image)
-		if err != nil {
-			return
-		}
-		if imageID.Valid {
-			updated, err = txqry.UpdateResourceImage(ctx, db.UpdateResourceImageParams{
-				ID:    id.Int64,
-				Image: imageID,
-			})
-			if err != nil {
-				return
-			}
-			if len(updated) != 1 {
-				err = fmt.Errorf("update failed, changed: %v", updated)
-				return
-			}
-		}
-
-		w.Header().Set("Location", trailingPath(path.Join("/", path.Dir(p))))
+		w.Header().Set("Location", withUndo(r, trailingPath(path.Join("/", path.Dir(p)))))
 		w.WriteHeader(303)
 		return
 	})
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"item-archive-d/internal/db"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+)
+
+// Gallery changes the images of a resource, the image to change is given by
+// its id in resource_image
+func (c Context) Gallery() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "/_gallery/{path...}", c.withTx(&sql.TxOptions{
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		if r.Method != http.MethodPost {
+			err = methodNotAllowed(r, http.MethodPost)
+			return
+		}
+		ctx := r.Context()
+
+		p := r.PathValue("path")
+		id, err := txqry.Resolve(ctx, p)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && !id.Valid {
+			err = redirectAlias(ctx, txqry, w, r, "/_gallery", p)
+			return
+		}
+		if err != nil {
+			return
+		}
+		resource, err := txqry.GetResource(ctx, id.Int64)
+		if err != nil {
+			return
+		}
+
+		err = invalidForm(r.ParseMultipartForm(10 * 1000 * 1000 * 1000))
+		if err != nil {
+			return
+		}
+
+		action := first(r.MultipartForm.Value, "action")
+		if action == "add" {
+			err = addImages(c, txqry, r, resource)
+			if err != nil {
+				return
+			}
+			w.Header().Set("Location", path.Join("/_edit", p))
+			w.WriteHeader(303)
+			return
+		}
+
+		imageID, err := strconv.ParseInt(first(r.MultipartForm.Value, "image"), 10, 64)
+		if err != nil {
+			err = validationf("invalid image: %q", first(r.MultipartForm.Value, "image"))
+			return
+		}
+		images, err := txqry.ListResourceImages(ctx, id.Int64)
+		if err != nil {
+			return
+		}
+		i := slices.IndexFunc(images, func(img db.ResourceImage) bool {
+			return img.ID == imageID
+		})
+		if i < 0 {
+			err = notFoundf("unknown image: %d", imageID)
+			return
+		}
+
+		switch action {
+		case "primary":
+			// the primary image is changed through the resource so that the
+			// change is recorded in its history
+			var updated []int64
+			updated, err = txqry.UpdateResourceImage(ctx, db.UpdateResourceImageParams{
+				ID:    id.Int64,
+				Image: sql.NullString{String: images[i].Image, Valid: true},
+			})
+			if err != nil {
+				return
+			}
+			if len(updated) != 1 {
+				err = fmt.Errorf("update failed, changed: %v", updated)
+				return
+			}
+		case "remove":
+			err = txqry.DeleteResourceImage(ctx, imageID)
+		case "up", "down":
+			j := i - 1
+			if action == "down" {
+				j = i + 1
+			}
+			if j < 0 || j >= len(images) {
+				break
+			}
+			err = txqry.SetResourceImagePosition(ctx, db.SetResourceImagePositionParams{
+				ID:       images[i].ID,
+				Position: images[j].Position,
+			})
+			if err != nil {
+				return
+			}
+			err = txqry.SetResourceImagePosition(ctx, db.SetResourceImagePositionParams{
+				ID:       images[j].ID,
+				Position: images[i].Position,
+			})
+		default:
+			err = validationf("invalid action: %q", action)
+		}
+		if err != nil {
+			return
+		}
+		err = txqry.TouchResource(ctx, id.Int64)
+		if err != nil {
+			return
+		}
+
+		w.Header().Set("Location", path.Join("/_edit", p))
+		w.WriteHeader(303)
+		return
+	})
+}
+
+// addImages appends the uploaded images to the gallery of the resource, the
+// first of them becomes the primary image if the resource has none
+func addImages(c Context, txqry *db.Queries, r *http.Request, resource db.Resource) (err error) {
+	ctx := r.Context()
+	uploads := r.MultipartForm.File["images"]
+	if len(uploads) == 0 {
+		err = validationf("missing images")
+		return
+	}
+	for _, upload := range uploads {
+		var imageID sql.NullString
+		imageID, err = handleImageUpload(c.blobs, upload)
+		if err != nil {
+			return
+		}
+		if !resource.Image.Valid {
+			_, err = txqry.UpdateResourceImage(ctx, db.UpdateResourceImageParams{
+				ID:    resource.ID,
+				Image: imageID,
+			})
+			if err != nil {
+				return
+			}
+			resource.Image = imageID
+			continue
+		}
+		err = txqry.CreateResourceImage(ctx, db.CreateResourceImageParams{
+			ResourceID: resource.ID,
+			Image:      imageID.String,
+		})
+		if err != nil {
+			return
+		}
+	}
+	return txqry.TouchResource(ctx, resource.ID)
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"item-archive-d/internal/db"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+type HistoryProps_Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+type HistoryProps_Entry struct {
+	Changed      string
+	Actor        string
+	Action       string
+	ResourceName string
+	ResourceHref string
+	Changes      []HistoryProps_Change
+	RevertHref   string
+}
+
+type HistoryProps struct {
+	Title   string
+	Back    string
+	Entries []HistoryProps_Entry
+}
+
+const history_template = `<!DOCTYPE html>
+<html>
+<head>
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Item Archive: {{.Title}}</title>
+	<style>
+	th, td {
+		text-align: start;
+		vertical-align: top;
+		padding-right: 0.5rem;
+	}
+	h1, h2, h3, h4, h5, h6 {
+		margin: 0.75rem 0rem;
+	}
+	* {
+		box-sizing: border-box;
+	}
+	body {
+		margin: 0;
+		padding: 0.5rem;
+	}
+	del {
+		color: darkred;
+	}
+	ins {
+		color: darkgreen;
+	}
+	</style>
+</head>
+
+<body>
+	<a href="{{.Back}}">&lt;&lt; Back</a>
+
+	<hr>
+
+	<h4>{{.Title}}</h4>
+	{{if not .Entries}}
+		<p>Nothing has been recorded yet.</p>
+	{{end}}
+	<table>
+		<tbody>
+			{{range .Entries}}
+			<tr>
+				<td>{{.Changed}}</td>
+				<td>{{if .ResourceHref}}<a href="{{.ResourceHref}}">{{.ResourceName}}</a>{{end}}</td>
+				<td>{{.Action}} by {{.Actor}}</td>
+				<td>
+					{{range .Changes}}
+						<div>{{.Field}}: {{if .Old}}<del>{{.Old}}</del> &rarr; {{end}}<ins>{{.New}}</ins></div>
+					{{end}}
+				</td>
+				<td>
+					{{if .RevertHref}}
+						<form action="{{.RevertHref}}" method="post">
+							<input type="submit" value="Revert to this version">
+						</form>
+					{{end}}
+				</td>
+			</tr>
+			{{end}}
+		</tbody>
+	</table>
+</body>
+</html>`
+
+// describeParent returns the path of a parent as of now, parents which no
+// longer exist are shown by their id
+func describeParent(ctx context.Context, txqry *db.Queries, parentID *int64) string {
+	if parentID == nil {
+		return "/"
+	}
+	segments, err := txqry.GetPath(ctx, *parentID)
+	if err != nil || len(segments) == 0 {
+		return "#" + strconv.FormatInt(*parentID, 10)
+	}
+	return path.Join(append([]string{"/"}, segments...)...)
+}
+
+func describeImage(image *string) string {
+	if image == nil {
+		return "none"
+	}
+	return *image
+}
+
+// toHistoryEntry describes a history row, the fields which changed are listed
+// with their old and new values
+func toHistoryEntry(ctx context.Context, txqry *db.Queries, h db.History) (entry HistoryProps_Entry, err error) {
+	before, after, err := h.Snapshots()
+	if err != nil {
+		return
+	}
+	entry = HistoryProps_Entry{
+		Changed: time.Unix(h.ChangedAt, 0).Format("2006-01-02 15:04"),
+		Actor:   "unknown",
+	}
+	if h.Actor.Valid {
+		entry.Actor = h.Actor.String
+	}
+
+	switch {
+	case before == nil:
+		entry.Action = "created"
+		before = &db.HistorySnapshot{}
+	case after == nil:
+		entry.Action = "deleted permanently"
+		entry.ResourceName = before.Name
+		return
+	case before.TrashID == nil && after.TrashID != nil:
+		entry.Action = "moved to trash"
+		entry.ResourceName = after.Name
+		return
+	case before.TrashID != nil && after.TrashID == nil:
+		entry.Action = "restored from trash"
+	default:
+		entry.Action = "updated"
+	}
+	entry.ResourceName = after.Name
+
+	if before.Name != after.Name {
+		entry.Changes = append(entry.Changes, HistoryProps_Change{Field: "name", Old: before.Name, New: after.Name})
+	}
+	if before.Type != after.Type {
+		entry.Changes = append(entry.Changes, HistoryProps_Change{Field: "type", Old: before.Type, New: after.Type})
+	}
+	if before.Comments != after.Comments {
+		entry.Changes = append(entry.Changes, HistoryProps_Change{Field: "comments", Old: before.Comments, New: after.Comments})
+	}
+	if describeImage(before.Image) != describeImage(after.Image) {
+		entry.Changes = append(entry.Changes, HistoryProps_Change{
+			Field: "image",
+			Old:   describeImage(before.Image),
+			New:   describeImage(after.Image),
+		})
+	}
+	switch {
+	case entry.Action == "restored from trash":
+		// trashed resources are detached from their parent, so the parent
+		// before a restore says nothing about where the resource was
+		entry.Changes = append(entry.Changes, HistoryProps_Change{
+			Field: "location",
+			Old:   "trash",
+			New:   describeParent(ctx, txqry, after.ParentID),
+		})
+	case entry.Action == "created" || !equalPtr(before.ParentID, after.ParentID):
+		entry.Changes = append(entry.Changes, HistoryProps_Change{
+			Field: "location",
+			Old:   describeParent(ctx, txqry, before.ParentID),
+			New:   describeParent(ctx, txqry, after.ParentID),
+		})
+	}
+	if entry.Action == "created" {
+		for i := range entry.Changes {
+			entry.Changes[i].Old = ""
+		}
+	}
+
+	entry.RevertHref = path.Join("/_revert", strconv.FormatInt(h.ID, 10))
+	return
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (c Context) History() (string, func(w http.ResponseWriter, r *http.Request)) {
+	tmpl, err := template.New("history").Parse(history_template)
+	if err != nil {
+		panic(err)
+	}
+	return "/_history/{id}", c.withTx(&sql.TxOptions{
+		// multiple reads
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			err = notFoundf("unknown resource: %s", r.PathValue("id"))
+			return
+		}
+		rows, err := txqry.ListHistory(ctx, id)
+		if err != nil {
+			return
+		}
+		if len(rows) == 0 {
+			err = notFoundf("no history recorded for resource: %d", id)
+			return
+		}
+
+		entries := make([]HistoryProps_Entry, len(rows))
+		for i, h := range rows {
+			entries[i], err = toHistoryEntry(ctx, txqry, h)
+			if err != nil {
+				return
+			}
+		}
+
+		title := "History of " + entries[0].ResourceName
+		back := "/"
+		segments, err := txqry.GetPath(ctx, id)
+		if err == nil && len(segments) > 0 {
+			p := path.Join(append([]string{"/"}, segments...)...)
+			title = "History of " + p
+			back = trailingPath(path.Dir(p))
+		}
+		err = tmpl.Execute(w, HistoryProps{
+			Title:   title,
+			Back:    back,
+			Entries: entries,
+		})
+		return
+	})
+}
+
+func (c Context) AuditLog() (string, func(w http.ResponseWriter, r *http.Request)) {
+	tmpl, err := template.New("audit-log").Parse(history_template)
+	if err != nil {
+		panic(err)
+	}
+	return "/_history", c.withTx(&sql.TxOptions{
+		// multiple reads
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		ctx := r.Context()
+		rows, err := txqry.ListRecentHistory(ctx, 200)
+		if err != nil {
+			return
+		}
+		entries := make([]HistoryProps_Entry, len(rows))
+		for i, h := range rows {
+			entries[i], err = toHistoryEntry(ctx, txqry, h)
+			if err != nil {
+				return
+			}
+			entries[i].ResourceHref = path.Join("/_history", strconv.FormatInt(h.ResourceID, 10))
+		}
+		err = tmpl.Execute(w, HistoryProps{
+			Title:   "Recent changes",
+			Back:    "/",
+			Entries: entries,
+		})
+		return
+	})
+}
+
+func (c Context) Revert() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "/_revert/{id}", c.withTx(&sql.TxOptions{
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		if r.Method != http.MethodPost {
+			err = methodNotAllowed(r, http.MethodPost)
+			return
+		}
+		ctx := r.Context()
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			err = notFoundf("unknown history entry: %s", r.PathValue("id"))
+			return
+		}
+		h, err := txqry.GetHistory(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = notFoundf("unknown history entry: %d", id)
+			return
+		}
+		if err != nil {
+			return
+		}
+		_, version, err := h.Snapshots()
+		if err != nil {
+			return
+		}
+		if version == nil || version.TrashID != nil {
+			err = conflictf("the resource did not exist after this change, it cannot be reverted to")
+			return
+		}
+
+		current, err := txqry.GetResource(ctx, h.ResourceID)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = conflictf("the resource has been deleted, restore it from the trash first")
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		var parentID sql.NullInt64
+		if version.ParentID != nil {
+			parentID = sql.NullInt64{Int64: *version.ParentID, Valid: true}
+			_, err = txqry.GetResource(ctx, parentID.Int64)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = conflictf("the former location of the resource no longer exists")
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			// the resource may have gained descendants since, it cannot be
+			// moved into one of them
+			var from, to []string
+			from, err = txqry.GetPath(ctx, h.ResourceID)
+			if err != nil {
+				return
+			}
+			to, err = txqry.GetPath(ctx, parentID.Int64)
+			if err != nil {
+				return
+			}
+			fromPath := path.Join(append([]string{"/"}, from...)...)
+			toPath := path.Join(append([]string{"/"}, to...)...)
+			if hasAncestor(fromPath, toPath) {
+				err = conflictf("cannot move resource '%s' into its own subtree '%s'", fromPath, toPath)
+				return
+			}
+		}
+
+		var image sql.NullString
+		if version.Image != nil {
+			image = sql.NullString{String: *version.Image, Valid: true}
+			var f *os.File
+			f, err = c.blobs.Open(image.String)
+			if os.IsNotExist(err) {
+				err = conflictf("the image of this version has been garbage collected")
+				return
+			}
+			if err != nil {
+				return
+			}
+			f.Close()
+		}
+
+		if current.Name != version.Name || current.ParentID != parentID {
+			err = rememberPath(ctx, txqry, h.ResourceID)
+			if err != nil {
+				return
+			}
+		}
+
+		_, err = txqry.RevertResource(ctx, db.RevertResourceParams{
+			ID:       h.ResourceID,
+			ParentID: parentID,
+			Name:     version.Name,
+			Type:     version.Type,
+			Comments: version.Comments,
+			Image:    image,
+		})
+		if err != nil {
+			return
+		}
+
+		w.Header().Set("Location", path.Join("/_history", strconv.FormatInt(h.ResourceID, 10)))
+		w.WriteHeader(303)
+		return
+	})
+}
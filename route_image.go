@@ -1,34 +1,95 @@
 package main
 
 import (
+	"errors"
 	"io"
+	"item-archive-d/internal/blob"
+	"item-archive-d/internal/thumb"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// sniffContentType detects the content type of a blob from its first bytes,
+// content which a browser would render as a page is served as plain text
+func sniffContentType(f io.ReadSeeker) (string, error) {
+	var buf [512]byte
+	n, err := io.ReadFull(f, buf[:])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	contentType := http.DetectContentType(buf[:n])
+	if strings.HasPrefix(contentType, "text/") {
+		return "text/plain; charset=utf-8", nil
+	}
+	return contentType, nil
+}
+
 func (c Context) Image() (string, func(w http.ResponseWriter, r *http.Request)) {
 	return "/_image/{id}", c.withError(func(w http.ResponseWriter, r *http.Request) (err error) {
-		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		// both sha256 and legacy ids are accepted
+		id, err := blob.CanonicalID(r.PathValue("id"))
 		if err != nil {
+			err = notFoundf("unknown image: %s", r.PathValue("id"))
 			return
 		}
 
-		// specify that:
-		// 1. public: can be cached by any cache (browser, cdn, proxy, etc...)
-		// 2. max-age=1 year: cache for 1 year
-		// 3. immutable: will not change during cache duration, specifies
-		// additional requests to validate freshness are not necessary
-		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-
-		f, err := c.blobs.Open(id)
+		var f *os.File
+		// blobs never change, so the id is a strong validator of the content
+		etag := `"` + id + `"`
+		if width := r.URL.Query().Get("w"); width != "" {
+			var px int
+			px, err = strconv.Atoi(width)
+			if err != nil || px <= 0 {
+				err = validationf("invalid width: %s", width)
+				return
+			}
+			f, err = c.thumbs.Open(id, px)
+			etag = `"` + id + "-w" + strconv.Itoa(thumb.Width(px)) + `"`
+			// fall back to the original for blobs which are not images
+			if errors.Is(err, thumb.ErrUnsupported) {
+				f, err = c.blobs.Open(id)
+				etag = `"` + id + `"`
+			}
+		} else {
+			f, err = c.blobs.Open(id)
+		}
+		if os.IsNotExist(err) {
+			err = notFoundf("unknown image: %s", id)
+			return
+		}
 		if err != nil {
 			return
 		}
 		defer f.Close()
-		_, err = io.Copy(w, f)
+
+		// thumbnails are either JPEG or PNG, so they are sniffed as well
+		contentType, err := sniffContentType(f)
 		if err != nil {
 			return
 		}
+
+		header := w.Header()
+		header.Set("Content-Type", contentType)
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("ETag", etag)
+		// specify that:
+		// 1. public: can be cached by any cache (browser, cdn, proxy, etc...)
+		// 2. max-age=1 year: cache for 1 year
+		// 3. immutable: will not change during cache duration, specifies
+		// additional requests to validate freshness are not necessary
+		header.Set("Cache-Control", "public, max-age=31536000, immutable")
+
+		// handles If-None-Match (304), Range (206), HEAD and Content-Length.
+		// the modification time of a blob says nothing about its content so
+		// it is left out in favor of the etag
+		http.ServeContent(w, r, id, time.Time{}, f)
 		return
 	})
 }
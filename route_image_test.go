@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImage(t *testing.T) {
+	router := newTestContext(t)
+	h := router.routes()
+
+	store := func(img image.Image) (string, []byte) {
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, img))
+		data := buf.Bytes()
+		id, err := router.blobs.Store(bytes.NewReader(data))
+		require.NoError(t, err)
+		return id, data
+	}
+	opaque := image.NewGray(image.Rect(0, 0, 400, 200))
+	id, data := store(opaque)
+	transparentID, _ := store(image.NewNRGBA(image.Rect(0, 0, 400, 200)))
+	textID, err := router.blobs.Store(strings.NewReader("<html>not an image</html>"))
+	require.NoError(t, err)
+
+	t.Run("original", func(t *testing.T) {
+		w := serve(h, httptest.NewRequest("GET", "/_image/"+id, nil))
+		require.Equal(t, 200, w.Code)
+		require.Equal(t, data, w.Body.Bytes())
+		require.Equal(t, "image/png", w.Header().Get("Content-Type"))
+		require.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+		require.Equal(t, `"`+id+`"`, w.Header().Get("ETag"))
+		require.Contains(t, w.Header().Get("Cache-Control"), "immutable")
+
+		w = serve(h, httptest.NewRequest("HEAD", "/_image/"+id, nil))
+		require.Equal(t, 200, w.Code)
+		require.Equal(t, strconv.Itoa(len(data)), w.Header().Get("Content-Length"))
+		require.Empty(t, w.Body.Bytes())
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/_image/"+id, nil)
+		r.Header.Set("If-None-Match", `"`+id+`"`)
+		w := serve(h, r)
+		require.Equal(t, 304, w.Code)
+		require.Empty(t, w.Body.Bytes())
+
+		r = httptest.NewRequest("GET", "/_image/"+id, nil)
+		r.Header.Set("If-None-Match", `"other"`)
+		w = serve(h, r)
+		require.Equal(t, 200, w.Code)
+	})
+
+	t.Run("range", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/_image/"+id, nil)
+		r.Header.Set("Range", "bytes=0-3")
+		w := serve(h, r)
+		require.Equal(t, 206, w.Code)
+		require.Equal(t, data[:4], w.Body.Bytes())
+		require.Equal(t, "bytes 0-3/"+strconv.Itoa(len(data)), w.Header().Get("Content-Range"))
+
+		r.Header.Set("Range", "bytes=100000-")
+		w = serve(h, r)
+		require.Equal(t, 416, w.Code)
+	})
+
+	t.Run("thumbnail", func(t *testing.T) {
+		w := serve(h, httptest.NewRequest("GET", "/_image/"+id+"?w=100", nil))
+		require.Equal(t, 200, w.Code)
+		require.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+		require.Equal(t, `"`+id+`-w160"`, w.Header().Get("ETag"))
+		config, _, err := image.DecodeConfig(w.Body)
+		require.NoError(t, err)
+		require.Equal(t, 160, config.Width)
+
+		r := httptest.NewRequest("GET", "/_image/"+id+"?w=100", nil)
+		r.Header.Set("If-None-Match", `"`+id+`-w160"`)
+		w = serve(h, r)
+		require.Equal(t, 304, w.Code)
+
+		// transparency is kept
+		w = serve(h, httptest.NewRequest("GET", "/_image/"+transparentID+"?w=80", nil))
+		require.Equal(t, 200, w.Code)
+		require.Equal(t, "image/png", w.Header().Get("Content-Type"))
+
+		// blobs which are not images are served as they are
+		w = serve(h, httptest.NewRequest("GET", "/_image/"+textID+"?w=80", nil))
+		require.Equal(t, 200, w.Code)
+		require.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+		require.Equal(t, `"`+textID+`"`, w.Header().Get("ETag"))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for target, status := range map[string]int{
+			"/_image/" + id + "?w=abc":                            400,
+			"/_image/" + id + "?w=0":                              400,
+			"/_image/not-a-blob":                                  404,
+			"/_image/sha256-" + strings.Repeat("0", 64):           404,
+			"/_image/sha256-" + strings.Repeat("0", 64) + "?w=80": 404,
+		} {
+			w := serve(h, httptest.NewRequest("GET", target, nil))
+			require.Equal(t, status, w.Code, target)
+		}
+	})
+}
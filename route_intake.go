@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"item-archive-d/internal/db"
+	"net/http"
+	"path"
+)
+
+// Intake creates one untitled item for every uploaded photo, the items are
+// named later by the ai-tagger
+func (c Context) Intake() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "/_intake/{path...}", c.withTx(&sql.TxOptions{
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		if r.Method != http.MethodPost {
+			err = methodNotAllowed(r, http.MethodPost)
+			return
+		}
+		ctx := r.Context()
+		p := path.Join("/", r.PathValue("path"))
+		parentID, err := txqry.Resolve(ctx, p)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = redirectAlias(ctx, txqry, w, r, "/_intake", p)
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		err = invalidForm(r.ParseMultipartForm(10 * 1000 * 1000 * 1000))
+		if err != nil {
+			return
+		}
+		images := r.MultipartForm.File["images"]
+		if len(images) == 0 {
+			err = validationf("missing images")
+			return
+		}
+		resourceType := first(r.MultipartForm.Value, "type")
+		if !validType(resourceType) {
+			err = validationf("invalid resource type: %q", resourceType)
+			return
+		}
+		tag := first(r.MultipartForm.Value, "tag") != ""
+
+		next, err := nextUntitled(ctx, txqry, parentID)
+		if err != nil {
+			return
+		}
+		for _, image := range images {
+			var imageID sql.NullString
+			imageID, err = handleImageUpload(c.blobs, image)
+			if err != nil {
+				return
+			}
+			var id int64
+			id, err = txqry.CreateResource(ctx, db.CreateResourceParams{
+				ParentID: parentID,
+				Name:     fmt.Sprintf("Untitled %d", next),
+				Type:     resourceType,
+				Image:    imageID,
+			})
+			if err != nil {
+				return
+			}
+			next++
+			if tag {
+				err = txqry.EnqueueTag(ctx, id)
+				if err != nil {
+					return
+				}
+			}
+		}
+
+		w.Header().Set("Location", withUndo(r, trailingPath(p)))
+		w.WriteHeader(303)
+		return
+	})
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"item-archive-d/internal/db"
+	"item-archive-d/internal/label"
+	"maps"
+	"math"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+)
+
+// baseURL returns the scheme and host the request was made to, links which
+// leave the browser (ex. printed on labels) must be absolute
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+type LabelsProps_Label struct {
+	Name   string
+	Path   string
+	QRCode template.HTML
+	Top    string
+	Left   string
+}
+
+type LabelsProps_Page struct {
+	Labels []LabelsProps_Label
+}
+
+type LabelsProps_Layout struct {
+	ID       string
+	Name     string
+	Selected bool
+}
+
+type LabelsProps struct {
+	Path    string
+	Back    string
+	Skip    int
+	Count   int
+	Layouts []LabelsProps_Layout
+
+	PageWidth   string
+	PageHeight  string
+	LabelWidth  string
+	LabelHeight string
+	NameSize    string
+	PathSize    string
+	Pages       []LabelsProps_Page
+}
+
+const labels_template = `<!DOCTYPE html>
+<html>
+<head>
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Item Archive: Labels for {{.Path}}</title>
+	<style>
+	@page {
+		size: {{.PageWidth}} {{.PageHeight}};
+		margin: 0;
+	}
+	* {
+		box-sizing: border-box;
+	}
+	body {
+		margin: 0;
+		padding: 0.5rem;
+	}
+	.page {
+		position: relative;
+		width: {{.PageWidth}};
+		height: {{.PageHeight}};
+		margin-bottom: 0.5rem;
+		outline: 1px solid lightgray;
+		overflow: hidden;
+		break-after: page;
+	}
+	.label {
+		position: absolute;
+		width: {{.LabelWidth}};
+		height: {{.LabelHeight}};
+		padding: 2%;
+		display: flex;
+		align-items: center;
+		gap: 4%;
+		overflow: hidden;
+		outline: 1px dashed lightgray;
+		font-family: sans-serif;
+	}
+	.label svg {
+		height: 100%;
+		flex-shrink: 0;
+	}
+	.label div {
+		min-width: 0;
+		overflow-wrap: anywhere;
+	}
+	.name {
+		font-size: {{.NameSize}};
+		font-weight: bold;
+	}
+	.path {
+		font-size: {{.PathSize}};
+	}
+	@media print {
+		body {
+			padding: 0;
+		}
+		.no-print {
+			display: none;
+		}
+		.page {
+			margin: 0;
+			outline: none;
+		}
+		.label {
+			outline: none;
+		}
+	}
+	</style>
+</head>
+
+<body>
+	<div class="no-print">
+		<a href="{{.Back}}">&lt;&lt; Back</a>
+
+		<hr>
+
+		<form action="" method="get">
+			<h4>{{.Count}} label(s) for the containers in {{.Path}}</h4>
+			<label>
+				Label stock:
+				<select name="layout">
+					{{range .Layouts}}
+						<option value="{{.ID}}" {{if .Selected}}selected{{end}}>{{.Name}}</option>
+					{{end}}
+				</select>
+			</label>
+			<label>
+				Skip used labels:
+				<input type="number" name="skip" min="0" value="{{.Skip}}">
+			</label>
+			<input type="submit" value="Update">
+			<button type="button" onclick="window.print()">Print</button>
+		</form>
+		<p>Print at 100% scale ("actual size") so that the labels line up with the sheet.</p>
+
+		<hr>
+	</div>
+
+	{{range .Pages}}
+		<div class="page">
+			{{range .Labels}}
+				<div class="label" style="top: {{.Top}}; left: {{.Left}};">
+					{{.QRCode}}
+					<div>
+						<div class="name">{{.Name}}</div>
+						<div class="path">{{.Path}}</div>
+					</div>
+				</div>
+			{{end}}
+		</div>
+	{{end}}
+</body>
+</html>`
+
+func (c Context) Labels() (string, func(w http.ResponseWriter, r *http.Request)) {
+	tmpl, err := template.New("labels").Parse(labels_template)
+	if err != nil {
+		panic(err)
+	}
+	return "/_labels/{path...}", c.withTx(&sql.TxOptions{
+		// multiple reads
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		if r.Method != http.MethodGet {
+			err = methodNotAllowed(r, http.MethodGet)
+			return
+		}
+		ctx := r.Context()
+		p := path.Join("/", r.PathValue("path"))
+		id, err := txqry.Resolve(ctx, p)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = redirectAlias(ctx, txqry, w, r, "/_labels", p)
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		layoutID := r.URL.Query().Get("layout")
+		if layoutID == "" {
+			layoutID = label.DefaultLayout
+		}
+		layout, ok := label.Layouts[layoutID]
+		if !ok {
+			err = validationf("unknown label layout: %q", layoutID)
+			return
+		}
+		skip := 0
+		if s := r.URL.Query().Get("skip"); s != "" {
+			skip, err = strconv.Atoi(s)
+			// skipping whole sheets makes no sense, they are simply not put
+			// into the printer
+			if err != nil || skip < 0 || skip >= layout.PerPage() {
+				err = validationf("invalid skip: %q, it must be less than the %d labels on a sheet", s, layout.PerPage())
+				return
+			}
+		}
+
+		type container struct {
+			id   int64
+			path string
+		}
+		var containers []container
+		if id.Valid {
+			var resource db.Resource
+			resource, err = txqry.GetResource(ctx, id.Int64)
+			if err != nil {
+				return
+			}
+			if resource.Type == "container" {
+				containers = append(containers, container{id: resource.ID, path: p})
+			}
+		}
+		rows, err := txqry.GetContainers(ctx, id)
+		if err != nil {
+			return
+		}
+		for _, row := range rows {
+			containers = append(containers, container{id: row.ID, path: path.Join(p, row.Path)})
+		}
+
+		length := func(v float64) string {
+			// a thousandth of an inch or millimeter is finer than any printer
+			return strconv.FormatFloat(math.Round(v*1000)/1000, 'f', -1, 64) + layout.Unit
+		}
+		// used labels at the start of the first sheet are left empty
+		var pages []LabelsProps_Page
+		for i := range skip + len(containers) {
+			slot := i % layout.PerPage()
+			if slot == 0 {
+				pages = append(pages, LabelsProps_Page{})
+			}
+			if i < skip {
+				continue
+			}
+			ct := containers[i-skip]
+			var svg string
+			svg, err = label.SVG(baseURL(r) + permalink(ct.id))
+			if err != nil {
+				return
+			}
+			page := &pages[len(pages)-1]
+			page.Labels = append(page.Labels, LabelsProps_Label{
+				Name: path.Base(ct.path),
+				Path: trailingPath(ct.path),
+				// generated from the permalink, it contains no user input
+				QRCode: template.HTML(svg),
+				Top:    length(layout.MarginTop + float64(slot/layout.Columns)*layout.PitchY),
+				Left:   length(layout.MarginLeft + float64(slot%layout.Columns)*layout.PitchX),
+			})
+		}
+
+		layouts := make([]LabelsProps_Layout, 0, len(label.Layouts))
+		for _, key := range slices.Sorted(maps.Keys(label.Layouts)) {
+			layouts = append(layouts, LabelsProps_Layout{
+				ID:       key,
+				Name:     label.Layouts[key].Name,
+				Selected: key == layoutID,
+			})
+		}
+
+		err = tmpl.Execute(w, LabelsProps{
+			Path:        trailingPath(p),
+			Back:        trailingPath(p),
+			Skip:        skip,
+			Count:       len(containers),
+			Layouts:     layouts,
+			PageWidth:   length(layout.PageWidth),
+			PageHeight:  length(layout.PageHeight),
+			LabelWidth:  length(layout.LabelWidth),
+			LabelHeight: length(layout.LabelHeight),
+			// text has to fit next to the code on the smallest labels
+			NameSize: length(layout.LabelHeight * 0.16),
+			PathSize: length(layout.LabelHeight * 0.1),
+			Pages:    pages,
+		})
+		return
+	})
+}
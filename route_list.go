@@ -1,13 +1,17 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"html/template"
 	"item-archive-d/internal/db"
 	"net/http"
+	"net/url"
 	"path"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -23,16 +27,36 @@ type ListProps_Row struct {
 	NameHref   string
 	Comments   string
 	ImageSrc   sql.NullString
+	Created    string
+	Updated    string
 	EditHref   string
 	DeleteHref string
+	Permalink  string
+}
+
+type ListProps_SortHrefs struct {
+	Name    string
+	Created string
+	Updated string
+}
+
+// ListProps_Undo offers to undo the operation which redirected to the list
+type ListProps_Undo struct {
+	Changes  int
+	Href     string
+	Redirect string
 }
 
 type ListProps struct {
 	IsNotRoot    bool
 	Path         string
 	MoveHref     string
+	LabelsHref   string
+	IntakeHref   string
 	PathSegments []ListProps_PathSegment
+	SortHrefs    ListProps_SortHrefs
 	Rows         []ListProps_Row
+	Undo         *ListProps_Undo
 }
 
 const list_template = `<!DOCTYPE html>
@@ -61,7 +85,7 @@ const list_template = `<!DOCTYPE html>
 		margin: 0;
 		padding: 0.5rem;
 		display: grid;
-		grid-template-rows: min-content min-content min-content min-content 1fr min-content min-content;
+		grid-template-rows: min-content min-content min-content min-content 1fr min-content min-content min-content min-content;
 	}
 	hr {
 		width: 100%;
@@ -92,15 +116,31 @@ const list_template = `<!DOCTYPE html>
 		<a href="{{.Location}}">{{.Name}}</a>
 		<span>/</span>
 		{{end}}
+		{{with .Undo}}
+			<form action="{{.Href}}" method="post" style="margin-left: auto;">
+				<span>Last action changed {{.Changes}} resource(s).</span>
+				<input type="hidden" name="redirect" value="{{.Redirect}}">
+				<input type="submit" value="Undo">
+			</form>
+		{{end}}
 	</div>
 
 	<hr>
 
-	<div>
+	<div style="display: flex; gap: 1rem; flex-wrap: wrap;">
 		<form action="/_search" method="get">
 			<h4><label for="q">Search</label></h4>
 			<input type="text" name="q" id="q" placeholder="Search query..." required>
 			<input type="submit" value="Submit">
+			{{if .IsNotRoot}}
+				<label><input type="checkbox" name="scope" value="{{.Path}}" checked> Only in this container</label>
+			{{end}}
+		</form>
+		<form action="/_scan" method="post" enctype="multipart/form-data">
+			<h4><label for="photo">Scan a code</label></h4>
+			<input type="hidden" name="from" value="{{.Path}}">
+			<input type="file" name="photo" id="photo" accept="image/*" capture="environment" required>
+			<input type="submit" value="Submit">
 		</form>
 	</div>
 
@@ -109,9 +149,11 @@ const list_template = `<!DOCTYPE html>
 	<div style="position: relative; overflow-y: auto;">
 		<table>
 			<thead style="position: sticky; top: 0; background-color: white;">
-				<th>Name</th>
+				<th><a href="{{.SortHrefs.Name}}">Name</a></th>
 				<th>Comments</th>
 				<th>Image</th>
+				<th><a href="{{.SortHrefs.Created}}">Added</a></th>
+				<th><a href="{{.SortHrefs.Updated}}">Updated</a></th>
 				<th></th>
 			</thead>
 			<tbody>
@@ -121,6 +163,8 @@ const list_template = `<!DOCTYPE html>
 						<td></td>
 						<td></td>
 						<td></td>
+						<td></td>
+						<td></td>
 					</tr>
 				{{end}}
 				{{range .Rows}}
@@ -136,7 +180,9 @@ const list_template = `<!DOCTYPE html>
 							<img src="{{.ImageSrc.String}}" alt="Image of {{.Name}}" loading="lazy">
 						{{end}}
 					</td>
-					<td><a href={{.EditHref}}>Edit</a> / <a href="{{.DeleteHref}}">Delete</a></td>
+					<td>{{.Created}}</td>
+					<td>{{.Updated}}</td>
+					<td><a href={{.EditHref}}>Edit</a> / <a href="{{.DeleteHref}}">Delete</a> / <a href="{{.Permalink}}">Link</a></td>
 				</tr>
 				{{end}}
 			</tbody>
@@ -146,7 +192,7 @@ const list_template = `<!DOCTYPE html>
 	<hr>
 
 	<form action="" method="post" enctype="multipart/form-data">
-		<h4>New Item / <a href="{{.MoveHref}}">Move Item</a></h4>
+		<h4>New Item / <a href="{{.MoveHref}}">Move Item</a> / <a href="/_trash">Trash</a> / <a href="/_history">Recent Changes</a> / <a href="/_aliases">Aliases</a> / <a href="{{.LabelsHref}}">Labels</a></h4>
 		<div>
 			<label for="name">Name:</label>
 			<input type="text" name="name" id="name" placeholder="Resource name">
@@ -168,6 +214,28 @@ const list_template = `<!DOCTYPE html>
 		</div>
 		<input type="submit" value="Submit">
 	</form>
+
+	<hr>
+
+	<form action="{{.IntakeHref}}" method="post" enctype="multipart/form-data">
+		<h4>Batch Intake</h4>
+		<div>
+			<label for="images">Photos:</label>
+			<input type="file" name="images" id="images" accept="image/*" multiple required>
+		</div>
+		<div>
+			<label for="intake-type">Type:</label>
+			<select name="type" id="intake-type">
+				<option value="item" selected>Item</option>
+				<option value="container">Container</option>
+			</select>
+		</div>
+		<div>
+			<input type="checkbox" name="tag" id="tag" value="1" checked>
+			<label for="tag">Queue for AI tagging</label>
+		</div>
+		<input type="submit" value="Submit">
+	</form>
 </body>
 </html>`
 
@@ -188,21 +256,146 @@ func makePathSegments(p string) (out []ListProps_PathSegment) {
 	return
 }
 
+// listSorts are the columns the list can be sorted by with "?sort=", along
+// with the order used when none is given with "?order="
+var listSorts = map[string]struct {
+	cmp          func(a, b db.Resource) int
+	defaultOrder string
+}{
+	"name": {
+		cmp:          func(a, b db.Resource) int { return strings.Compare(a.Name, b.Name) },
+		defaultOrder: "asc",
+	},
+	"created": {
+		cmp:          func(a, b db.Resource) int { return cmp.Compare(a.CreatedAt, b.CreatedAt) },
+		defaultOrder: "desc",
+	},
+	"updated": {
+		cmp:          func(a, b db.Resource) int { return cmp.Compare(a.UpdatedAt, b.UpdatedAt) },
+		defaultOrder: "desc",
+	},
+}
+
+// sortResources sorts the resources in place, resources are left in the order
+// they were created if sortBy is empty
+func sortResources(rows []db.Resource, sortBy, order string) error {
+	if sortBy == "" {
+		return nil
+	}
+	s, ok := listSorts[sortBy]
+	if !ok {
+		return validationf("invalid sort: %q", sortBy)
+	}
+	if order == "" {
+		order = s.defaultOrder
+	}
+	if order != "asc" && order != "desc" {
+		return validationf("invalid order: %q", order)
+	}
+	slices.SortStableFunc(rows, func(a, b db.Resource) int {
+		if order == "desc" {
+			return s.cmp(b, a)
+		}
+		return s.cmp(a, b)
+	})
+	return nil
+}
+
+// sortHref returns the link of a column header, which sorts by the column or
+// reverses the order if the list is already sorted by it
+func sortHref(column, sortBy, order string) string {
+	if column == sortBy {
+		if order == "" {
+			order = listSorts[column].defaultOrder
+		}
+		if order == "asc" {
+			order = "desc"
+		} else {
+			order = "asc"
+		}
+		return "?sort=" + column + "&order=" + order
+	}
+	return "?sort=" + column
+}
+
+// listUndo returns the undo banner for the "?undo=" operation, there is no
+// banner if the operation is unknown or has already been undone
+func listUndo(ctx context.Context, txqry *db.Queries, undo, p string) (props *ListProps_Undo, err error) {
+	if undo == "" {
+		return
+	}
+	id, err := strconv.ParseInt(undo, 10, 64)
+	if err != nil {
+		err = nil
+		return
+	}
+	operation, err := txqry.GetOperation(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+		return
+	}
+	if err != nil || operation.UndoneAt.Valid {
+		return
+	}
+	changes, err := txqry.ListOperationHistory(ctx, sql.NullInt64{Int64: id, Valid: true})
+	if err != nil {
+		return
+	}
+	resources := map[int64]struct{}{}
+	for _, h := range changes {
+		resources[h.ResourceID] = struct{}{}
+	}
+	props = &ListProps_Undo{
+		Changes:  len(resources),
+		Href:     path.Join("/_undo", undo),
+		Redirect: (&url.URL{Path: trailingPath(p)}).String(),
+	}
+	return
+}
+
+// nextUntitled returns the number following the highest "Untitled N" in the
+// parent, resources created without a name are numbered from it
+func nextUntitled(ctx context.Context, txqry *db.Queries, parentID sql.NullInt64) (idx uint64, err error) {
+	resources, err := txqry.ListResources(ctx, parentID)
+	if err != nil {
+		return
+	}
+	maxIdx := uint64(0)
+	for _, r := range resources {
+		if !strings.HasPrefix(r.Name, "Untitled") {
+			continue
+		}
+		segments := strings.Split(r.Name, " ")
+		if len(segments) != 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(segments[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if n > maxIdx {
+			maxIdx = n
+		}
+	}
+	idx = maxIdx + 1
+	return
+}
+
 func (c Context) List() (string, func(w http.ResponseWriter, r *http.Request)) {
 	tmpl, err := template.New("list").Parse(list_template)
 	if err != nil {
 		panic(err)
 	}
 	return "/", c.withTx(&sql.TxOptions{
-		// single read
-		Isolation: sql.LevelReadCommitted,
+		// multiple reads
+		Isolation: sql.LevelSerializable,
 	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
 		ctx := r.Context()
 		p := path.Join("/", r.URL.Path)
 
 		parentID, err := txqry.Resolve(ctx, p)
 		if errors.Is(err, sql.ErrNoRows) {
-			err = fmt.Errorf("unknown resource: %s", r.URL.Path)
+			err = redirectAlias(ctx, txqry, w, r, "", p)
 			return
 		}
 		if err != nil {
@@ -210,7 +403,7 @@ func (c Context) List() (string, func(w http.ResponseWriter, r *http.Request)) {
 		}
 
 		if r.Method == http.MethodPost {
-			err = r.ParseMultipartForm(10 * 1000 * 1000 * 1000)
+			err = invalidForm(r.ParseMultipartForm(10 * 1000 * 1000 * 1000))
 			if err != nil {
 				return
 			}
@@ -219,40 +412,46 @@ func (c Context) List() (string, func(w http.ResponseWriter, r *http.Request)) {
 			resourceType := first(r.MultipartForm.Value, "type")
 			comments := first(r.MultipartForm.Value, "comments")
 			image := first(r.MultipartForm.File, "image")
+			// the code is given when the item is created from a scan
+			code := first(r.MultipartForm.Value, "code")
+			if !validType(resourceType) {
+				err = validationf("invalid resource type: %q", resourceType)
+				return
+			}
+			// an empty name is replaced with the next free "Untitled N"
+			if name != "" && !db.ValidName(name) {
+				err = validationf("invalid name: %q, names must not contain '/'", name)
+				return
+			}
+
+			if code != "" {
+				_, err = txqry.GetResourceByCode(ctx, code)
+				if err == nil {
+					err = conflictf("the code %q is already attached to another resource", code)
+					return
+				}
+				if !errors.Is(err, sql.ErrNoRows) {
+					return
+				}
+			}
 
-			var imageID sql.NullInt64
+			var imageID sql.NullString
 			imageID, err = handleImageUpload(c.blobs, image)
 			if err != nil {
 				return
 			}
 
 			if name == "" {
-				var resources []db.Resource
-				resources, err = txqry.ListResources(ctx, parentID)
+				var idx uint64
+				idx, err = nextUntitled(ctx, txqry, parentID)
 				if err != nil {
 					return
 				}
-				maxIdx := uint64(0)
-				for _, r := range resources {
-					if !strings.HasPrefix(r.Name, "Untitled") {
-						continue
-					}
-					segments := strings.Split(r.Name, " ")
-					if len(segments) != 2 {
-						continue
-					}
-					idx, err := strconv.ParseUint(segments[1], 10, 64)
-					if err != nil {
-						continue
-					}
-					if idx > uint64(maxIdx) {
-						maxIdx = idx
-					}
-				}
-				name = fmt.Sprintf("Untitled %d", maxIdx+1)
+				name = fmt.Sprintf("Untitled %d", idx)
 			}
 
-			_, err = txqry.CreateResource(ctx, db.CreateResourceParams{
+			var id int64
+			id, err = txqry.CreateResource(ctx, db.CreateResourceParams{
 				ParentID: parentID,
 				Name:     name,
 				Type:     resourceType,
@@ -260,9 +459,19 @@ func (c Context) List() (string, func(w http.ResponseWriter, r *http.Request)) {
 				Image:    imageID,
 			})
 			if err != nil {
+				err = nameConflict(err, "a resource named %q already exists in %s", name, trailingPath(p))
 				return
 			}
-			w.Header().Set("Location", r.URL.Path)
+			if code != "" {
+				err = txqry.CreateResourceCode(ctx, db.CreateResourceCodeParams{
+					Code:       code,
+					ResourceID: id,
+				})
+				if err != nil {
+					return
+				}
+			}
+			w.Header().Set("Location", withUndo(r, r.URL.Path))
 			w.WriteHeader(303)
 			return
 		}
@@ -271,6 +480,12 @@ func (c Context) List() (string, func(w http.ResponseWriter, r *http.Request)) {
 		if err != nil {
 			return
 		}
+		sortBy := r.URL.Query().Get("sort")
+		order := r.URL.Query().Get("order")
+		err = sortResources(rows, sortBy, order)
+		if err != nil {
+			return
+		}
 
 		listRows := make([]ListProps_Row, len(rows))
 		for i, r := range rows {
@@ -280,24 +495,42 @@ func (c Context) List() (string, func(w http.ResponseWriter, r *http.Request)) {
 				NameHref:   trailingPath(path.Join(p, r.Name)),
 				IsItem:     r.Type == "item",
 				Comments:   r.Comments,
+				Created:    formatDate(r.CreatedAt),
+				Updated:    formatDate(r.UpdatedAt),
 				EditHref:   path.Join("/_edit", p, r.Name),
 				DeleteHref: path.Join("/_delete_confirm", p, r.Name),
+				Permalink:  permalink(r.ID),
 			}
 			if r.Image.Valid {
-				id := strconv.FormatUint(db.ToUint(r.Image.Int64), 10)
 				listRows[i].ImageSrc = sql.NullString{
-					String: path.Join("/_image", id),
+					// images are displayed at most 80px wide, 160px keeps
+					// them sharp on high density screens
+					String: path.Join("/_image", r.Image.String) + "?w=160",
 					Valid:  true,
 				}
 			}
 		}
 
+		undo, err := listUndo(ctx, txqry, r.URL.Query().Get("undo"), p)
+		if err != nil {
+			return
+		}
+
 		err = tmpl.Execute(w, ListProps{
 			IsNotRoot:    p != "/",
 			Path:         p,
 			PathSegments: makePathSegments(p),
 			Rows:         listRows,
 			MoveHref:     path.Join("/_move_start", p),
+			LabelsHref:   path.Join("/_labels", p),
+			// posting to "/_intake" would be redirected to "/_intake/"
+			IntakeHref: trailingPath(path.Join("/_intake", p)),
+			SortHrefs: ListProps_SortHrefs{
+				Name:    sortHref("name", sortBy, order),
+				Created: sortHref("created", sortBy, order),
+				Updated: sortHref("updated", sortBy, order),
+			},
+			Undo: undo,
 		})
 		return
 	})
@@ -2,7 +2,7 @@ package main
 
 import (
 	"database/sql"
-	"fmt"
+	"errors"
 	"html/template"
 	"item-archive-d/internal/db"
 	"net/http"
@@ -83,15 +83,15 @@ func (c Context) MoveStart() (string, func(w http.ResponseWriter, r *http.Reques
 		p := r.PathValue("path")
 
 		id, err := txqry.Resolve(ctx, p)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = redirectAlias(ctx, txqry, w, r, "/_move_start", p)
+			return
+		}
 		if err != nil {
 			return
 		}
 		var subtree []string
 		if !id.Valid {
-			if p != "" && p != "/" {
-				err = fmt.Errorf("unknown resource: %s", p)
-				return
-			}
 			subtree, err = c.qry.GetFullTree(ctx)
 		} else {
 			subtree, err = c.qry.GetSubtree(ctx, id.Int64)
@@ -151,29 +151,31 @@ func (c Context) MoveFinish() (string, func(w http.ResponseWriter, r *http.Reque
 		Isolation: sql.LevelSerializable,
 	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
 		if r.Method != http.MethodPost {
-			w.WriteHeader(400)
-			w.Write([]byte("unsupported method"))
+			err = methodNotAllowed(r, http.MethodPost)
 			return
 		}
 
 		ctx := r.Context()
 		p := r.PathValue("path")
-		id, err := txqry.Resolve(ctx, p)
-		if err != nil {
+		_, err = txqry.Resolve(ctx, p)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = redirectAlias(ctx, txqry, w, r, "/_move_finish", p)
 			return
 		}
-		if !id.Valid && p != "" && p != "/" {
-			w.WriteHeader(400)
-			w.Write([]byte("Cannot delete non-existant resource."))
+		if err != nil {
 			return
 		}
 
-		err = r.ParseForm()
+		err = invalidForm(r.ParseForm())
 		if err != nil {
 			return
 		}
 		to := r.Form.Get("__to__")
 		toId, err := txqry.Resolve(ctx, to)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = notFoundf("unknown destination: %s", to)
+			return
+		}
 		if err != nil {
 			return
 		}
@@ -188,21 +190,27 @@ func (c Context) MoveFinish() (string, func(w http.ResponseWriter, r *http.Reque
 			// abort if: the destination (to) is a child of any of the
 			// resources being moved
 			if hasAncestor(fullpath, to) {
-				w.WriteHeader(400)
-				fmt.Fprintf(w, "cannot move resource '%s' into its own subtree '%s'", fullpath, to)
+				err = conflictf("cannot move resource '%s' into its own subtree '%s'", fullpath, to)
 				return
 			}
 
 			var resolved sql.NullInt64
 			resolved, err = txqry.Resolve(ctx, fullpath)
-			if err != nil {
+			if errors.Is(err, sql.ErrNoRows) || err == nil && !resolved.Valid {
+				err = notFoundf("unknown resource: %s", fullpath)
 				return
 			}
-			if !resolved.Valid {
-				err = fmt.Errorf("unknown resource: %s", fullpath)
+			if err != nil {
 				return
 			}
 			ids = append(ids, resolved.Int64)
+
+			if path.Join("/", path.Dir(fullpath)) != path.Join("/", to) {
+				err = rememberPath(ctx, txqry, resolved.Int64)
+				if err != nil {
+					return
+				}
+			}
 		}
 
 		changed, err := txqry.MoveResources(ctx, db.MoveResourcesParams{
@@ -210,6 +218,7 @@ func (c Context) MoveFinish() (string, func(w http.ResponseWriter, r *http.Reque
 			NewParent: toId,
 		})
 		if err != nil {
+			err = nameConflict(err, "a resource with the same name as one of the moved resources already exists in %s", trailingPath(path.Join("/", to)))
 			return
 		}
 		if len(changed) != len(ids) {
@@ -220,11 +229,11 @@ func (c Context) MoveFinish() (string, func(w http.ResponseWriter, r *http.Reque
 				}
 				missing = append(missing, id)
 			}
-			err = fmt.Errorf("unknown resources: %v", missing)
+			err = notFoundf("unknown resources: %v", missing)
 			return
 		}
 
-		w.Header().Set("Location", trailingPath(path.Join("/", to)))
+		w.Header().Set("Location", withUndo(r, trailingPath(path.Join("/", to))))
 		w.WriteHeader(303)
 		return
 	})
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"item-archive-d/internal/db"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// permalinkRoutes are the path routes which can be reached through a permalink
+// as "/_id/{id}/{action}"
+var permalinkRoutes = map[string]string{
+	"edit":           "/_edit",
+	"update":         "/_update",
+	"gallery":        "/_gallery",
+	"attachments":    "/_attachments",
+	"delete_confirm": "/_delete_confirm",
+	"delete_shallow": "/_delete_shallow",
+	"delete_deep":    "/_delete_deep",
+	"move_start":     "/_move_start",
+	"move_finish":    "/_move_finish",
+}
+
+// permalink returns the link of a resource which stays the same when the
+// resource is renamed or moved
+func permalink(id int64) string {
+	return path.Join("/_id", strconv.FormatInt(id, 10))
+}
+
+// permalinkID returns the id of the resource a permalink leads to. permalinks
+// printed on labels are absolute, only those of the given host (the host of
+// the request) lead to a resource of this archive.
+func permalinkID(link, host string) (id int64, ok bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Host != "" && !strings.EqualFold(u.Host, host) {
+		return
+	}
+	rest, found := strings.CutPrefix(u.Path, "/_id/")
+	if !found {
+		return
+	}
+	id, err = strconv.ParseInt(strings.TrimSuffix(rest, "/"), 10, 64)
+	ok = err == nil
+	return
+}
+
+func (c Context) Permalink() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "/_id/{id}/{action...}", c.withTx(&sql.TxOptions{
+		// multiple reads
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			err = notFoundf("unknown resource: %s", r.PathValue("id"))
+			return
+		}
+		resource, err := txqry.GetResource(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = notFoundf("unknown resource: %d", id)
+			return
+		}
+		if err != nil {
+			return
+		}
+		segments, err := txqry.GetPath(ctx, id)
+		if err != nil {
+			return
+		}
+		p := path.Join(append([]string{"/"}, segments...)...)
+
+		var location string
+		switch action := r.PathValue("action"); action {
+		case "":
+			// items have no listing of their own, they are shown in the
+			// listing of their container
+			if resource.Type == "item" {
+				p = path.Dir(p)
+			}
+			location = trailingPath(p)
+		case "history":
+			location = path.Join("/_history", strconv.FormatInt(id, 10))
+		default:
+			route, ok := permalinkRoutes[action]
+			if !ok {
+				err = notFoundf("unknown action: %s", action)
+				return
+			}
+			location = path.Join(route, p)
+		}
+		if r.URL.RawQuery != "" {
+			location += "?" + r.URL.RawQuery
+		}
+
+		// the path of a resource changes, so the redirect is never permanent.
+		// 307 keeps the method and body of forms posted to a permalink.
+		w.Header().Set("Location", location)
+		w.WriteHeader(307)
+		return
+	})
+}
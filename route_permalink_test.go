@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermalinkID(t *testing.T) {
+	for _, tc := range []struct {
+		link string
+		id   int64
+		ok   bool
+	}{
+		{"/_id/12", 12, true},
+		{"/_id/12/", 12, true},
+		{"http://archive.local:8080/_id/12", 12, true},
+		{"https://ARCHIVE.local:8080/_id/12", 12, true},
+		// labels of another archive lead to unrelated resources
+		{"http://other.example/_id/12", 0, false},
+		{"http://archive.local/_id/12", 0, false},
+		{"//other.example/_id/12", 0, false},
+		{"/_id/x", 0, false},
+		{"/box/_id/12", 0, false},
+		{"4006381333931", 0, false},
+	} {
+		id, ok := permalinkID(tc.link, "archive.local:8080")
+		require.Equal(t, tc.ok, ok, tc.link)
+		if ok {
+			require.Equal(t, tc.id, id, tc.link)
+		}
+	}
+}
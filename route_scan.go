@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"image"
+	"item-archive-d/internal/db"
+	"item-archive-d/internal/label"
+	"net/http"
+	"path"
+
+	// photos from phones and scanners
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+type ScanProps struct {
+	Code string
+	// Container is where a new item with the code is created
+	Container string
+}
+
+const scan_template = `<!DOCTYPE html>
+<html>
+<head>
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Item Archive: Unknown code</title>
+	<style>
+	h1, h2, h3, h4, h5, h6 {
+		margin: 0.75rem 0rem;
+	}
+	* {
+		box-sizing: border-box;
+	}
+	body {
+		margin: 0;
+		padding: 0.5rem;
+	}
+	@media (max-width: 768px) { /* prevent zoom on text-input for mobile */
+		* {
+			touch-action: manipulation;
+		}
+		input,
+		textarea,
+		select {
+			font-size: 16px;
+		}
+	}
+	</style>
+</head>
+
+<body>
+	<a href="{{.Container}}">&lt;&lt; Back</a>
+
+	<hr>
+
+	<p>Nothing in the archive has the code <code>{{.Code}}</code>.</p>
+
+	<form action="{{.Container}}" method="post" enctype="multipart/form-data">
+		<h4>New item in {{.Container}} with this code</h4>
+		<input type="hidden" name="code" value="{{.Code}}">
+		<div>
+			<label for="name">Name:</label>
+			<input type="text" name="name" id="name" placeholder="Resource name">
+		</div>
+		<div>
+			<label for="comments">Comments:</label>
+			<textarea name="comments" id="comments" placeholder="Comments"></textarea>
+		</div>
+		<div>
+			<label for="image">Image:</label>
+			<input type="file" name="image" id="image">
+		</div>
+		<div>
+			<label for="type">Type:</label>
+			<select name="type" id="type-select">
+				<option value="item" selected>Item</option>
+				<option value="container">Container</option>
+			</select>
+		</div>
+		<input type="submit" value="Submit">
+	</form>
+</body>
+</html>`
+
+func (c Context) Scan() (string, func(w http.ResponseWriter, r *http.Request)) {
+	tmpl, err := template.New("scan").Parse(scan_template)
+	if err != nil {
+		panic(err)
+	}
+	return "/_scan", c.withTx(&sql.TxOptions{
+		// multiple reads
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		if r.Method != http.MethodPost {
+			err = methodNotAllowed(r, http.MethodPost)
+			return
+		}
+		ctx := r.Context()
+		err = invalidForm(r.ParseMultipartForm(10 * 1000 * 1000 * 1000))
+		if err != nil {
+			return
+		}
+		header := first(r.MultipartForm.File, "photo")
+		if header == nil {
+			err = validationf("missing photo")
+			return
+		}
+		f, err := header.Open()
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		img, _, err := image.Decode(f)
+		if err != nil {
+			err = validationf("unsupported photo: %v", err)
+			return
+		}
+		code, err := label.Scan(img)
+		if errors.Is(err, label.ErrNoCode) {
+			err = validationf("no QR code or barcode was found in the photo, try again from closer or with more light")
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		// codes on labels printed by the archive are permalinks, other codes
+		// have to be attached to a resource first
+		id, ok := permalinkID(code, r.Host)
+		if !ok {
+			id, err = txqry.GetResourceByCode(ctx, code)
+		}
+		if err == nil {
+			w.Header().Set("Location", permalink(id))
+			w.WriteHeader(303)
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return
+		}
+
+		// new items are created in the container the photo was uploaded from
+		from := path.Join("/", first(r.MultipartForm.Value, "from"))
+		_, err = txqry.Resolve(ctx, from)
+		if errors.Is(err, sql.ErrNoRows) {
+			from = "/"
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+		err = tmpl.Execute(w, ScanProps{
+			Code:      code,
+			Container: trailingPath(from),
+		})
+		return
+	})
+}
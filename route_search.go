@@ -1,27 +1,58 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"html/template"
 	"item-archive-d/internal/db"
 	"net/http"
+	"net/url"
 	"path"
 	"strconv"
 	"strings"
 )
 
+// SearchProps_Text is a part of a highlighted text, Match is set for the
+// parts which matched the query
+type SearchProps_Text struct {
+	Text  string
+	Match bool
+}
+
 type SearchProps_Row struct {
 	IsItem     bool
 	Name       string
+	NameParts  []SearchProps_Text
 	NameHref   string
 	ParentHref string
-	Comments   string
-	ImageSrc   sql.NullString
+	// the path of the parent relative to the scope of the search
+	ParentName string
+	// the comments or the text of the attachment around the match
+	Snippet []SearchProps_Text
+	// the attachment whose text matched, if the resource itself did not
+	AttachmentName string
+	AttachmentHref string
+	ImageSrc       sql.NullString
+	Created        string
+	Updated        string
+	Permalink      string
 }
 
 type SearchProps struct {
 	Query string
+	// the container the search is restricted to, empty for the whole archive
+	Scope string
 	Rows  []SearchProps_Row
+	Total int64
+	// a corrected query if nothing matched
+	Suggestion     string
+	SuggestionHref string
+	// the range of results on this page, counted from 1
+	First    int64
+	Last     int64
+	PrevHref string
+	NextHref string
 }
 
 const search_template = `<!DOCTYPE html>
@@ -79,15 +110,31 @@ const search_template = `<!DOCTYPE html>
 	<hr>
 
 	<form class="flex-vertical" action="/_search" method="get">
-		<h4>Search results: '{{.Query}}'</h4>
+		<h4>Search results{{if .Scope}} in {{.Scope}}{{end}}: '{{.Query}}'</h4>
 		<div class="flex-horizontal">
-			<input type="text" name="q" id="q" value="{{.Query}}" placeholder="Search query..." required autofocus>
+			<input type="text" name="q" id="q" value="{{.Query}}" placeholder="Search query, ex. drill type:item in:/garage" required autofocus>
 			<input type="submit" value="Submit">
 		</div>
+		{{if .Scope}}
+			<label><input type="checkbox" name="scope" value="{{.Scope}}" checked> Only in {{.Scope}}</label>
+		{{end}}
 	</form>
 
 	<hr>
 
+	<div class="flex-horizontal">
+		{{if .Rows}}
+			<span>Showing {{.First}}–{{.Last}} of {{.Total}} result(s)</span>
+		{{else}}
+			<span>{{.Total}} result(s)</span>
+			{{if .Suggestion}}
+				<span>Did you mean <a href="{{.SuggestionHref}}">{{.Suggestion}}</a>?</span>
+			{{end}}
+		{{end}}
+		{{if .PrevHref}}<a href="{{.PrevHref}}">&lt; Previous</a>{{end}}
+		{{if .NextHref}}<a href="{{.NextHref}}">Next &gt;</a>{{end}}
+	</div>
+
 	<div style="height: 100%; overflow-y: auto;">
 		<table>
 			<thead style="position: sticky; top: 0; background: white;">
@@ -95,22 +142,33 @@ const search_template = `<!DOCTYPE html>
 				<th>Name</th>
 				<th>Comments</th>
 				<th>Image</th>
+				<th>Added</th>
+				<th>Updated</th>
+				<th></th>
 			</thead>
 			<tbody>
 				{{range .Rows}}
 				<tr>
-					<td><a href="{{.ParentHref}}">{{.ParentHref}}</a></td>
+					<td><a href="{{.ParentHref}}">{{.ParentName}}</a></td>
 					{{if .IsItem}}
-						<td>{{.Name}}</td>
+						<td>{{template "highlight" .NameParts}}</td>
 					{{else}}
-						<td><a href="{{.NameHref}}">{{.Name}}/</a></td>
+						<td><a href="{{.NameHref}}">{{template "highlight" .NameParts}}/</a></td>
 					{{end}}
-					<td>{{.Comments}}</td>
+					<td>
+						{{template "highlight" .Snippet}}
+						{{if .AttachmentHref}}
+							<div>Matched in <a href="{{.AttachmentHref}}">{{.AttachmentName}}</a></div>
+						{{end}}
+					</td>
 					<td>
 						{{if .ImageSrc.Valid}}
 							<img src="{{.ImageSrc.String}}" alt="Image of {{.Name}}" loading="lazy">
 						{{end}}
 					</td>
+					<td>{{.Created}}</td>
+					<td>{{.Updated}}</td>
+					<td><a href="{{.Permalink}}">Link</a></td>
 				</tr>
 				{{end}}
 			</tbody>
@@ -118,7 +176,71 @@ const search_template = `<!DOCTYPE html>
 	</div>
 </body>
 </html>
-`
+{{define "highlight"}}{{range .}}{{if .Match}}<mark>{{.Text}}</mark>{{else}}{{.Text}}{{end}}{{end}}{{end}}`
+
+// searchPageSize is the amount of results shown on a page of the search
+const searchPageSize = 50
+
+// highlightText splits a name or snippet highlighted by db.Search into the
+// parts which matched and those which did not
+func highlightText(s string) (parts []SearchProps_Text) {
+	for s != "" {
+		before, rest, found := strings.Cut(s, db.HighlightStart)
+		before = strings.ReplaceAll(before, db.HighlightEnd, "")
+		if before != "" {
+			parts = append(parts, SearchProps_Text{Text: before})
+		}
+		if !found {
+			break
+		}
+		match, after, _ := strings.Cut(rest, db.HighlightEnd)
+		if match != "" {
+			parts = append(parts, SearchProps_Text{Text: match, Match: true})
+		}
+		s = after
+	}
+	return
+}
+
+// queryInt parses an optional non-negative integer from the query of the
+// request
+func queryInt(values url.Values, key string, def int64) (n int64, err error) {
+	s := values.Get(key)
+	if s == "" {
+		return def, nil
+	}
+	n, err = strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		err = validationf("invalid %s: %q", key, s)
+	}
+	return
+}
+
+// searchResources parses and runs a search query, mistakes in the query are
+// reported as invalid requests. the results are restricted to the descendants
+// of scope if it is set. if nothing matches, suggestion is the query with its
+// misspelled words corrected or empty if none were found.
+func searchResources(ctx context.Context, txqry *db.Queries, q string, scope sql.NullInt64, limit, offset int64) (out []db.SearchRow, total int64, suggestion string, err error) {
+	query, err := db.ParseSearch(q)
+	if err != nil {
+		err = validationf("invalid search: %v", err)
+		return
+	}
+	query.Ancestor = scope
+	out, total, err = txqry.Search(ctx, query, limit, offset)
+	if errors.Is(err, db.ErrUnknownContainer) {
+		err = validationf("invalid search: %v", err)
+	}
+	if err != nil || total > 0 {
+		return
+	}
+	corrected, ok, err := txqry.Suggest(ctx, query)
+	if err != nil || !ok {
+		return
+	}
+	suggestion = corrected.String()
+	return
+}
 
 func (c Context) Search() (string, func(w http.ResponseWriter, r *http.Request)) {
 	tmpl, err := template.New("list").Parse(search_template)
@@ -131,44 +253,103 @@ func (c Context) Search() (string, func(w http.ResponseWriter, r *http.Request))
 		Isolation: sql.LevelReadCommitted,
 	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
 		ctx := r.Context()
-		err = r.ParseForm()
+		err = invalidForm(r.ParseForm())
 		if err != nil {
 			return
 		}
 		query := r.Form.Get("q")
-		resources, err := txqry.Search(ctx, query)
+		scope := trailingPath(path.Join("/", r.Form.Get("scope")))
+		scopeID, err := txqry.Resolve(ctx, scope)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = notFoundf("unknown container: %s", scope)
+			return
+		}
+		if err != nil {
+			return
+		}
+		offset, err := queryInt(r.Form, "offset", 0)
+		if err != nil {
+			return
+		}
+		resources, total, suggestion, err := searchResources(ctx, txqry, query, scopeID, searchPageSize, offset)
+		if err != nil {
+			return
+		}
+		ids := make([]int64, len(resources))
+		for i, r := range resources {
+			ids[i] = r.ID
+		}
+		paths, err := txqry.GetPaths(ctx, ids)
 		if err != nil {
 			return
 		}
 		rows := make([]SearchProps_Row, len(resources))
 		for i, r := range resources {
-			var segments []string
-			segments, err = txqry.GetPath(ctx, r.ID)
-			if err != nil {
-				return
-			}
+			segments := paths[r.ID]
 			fullPath := trailingPath(strings.Join(segments, "/"))
 			parent := trailingPath(strings.Join(segments[:len(segments)-1], "/"))
+			parentName := parent
+			if scopeID.Valid {
+				// every result is below the scope
+				parentName = "./" + strings.TrimPrefix(trailingPath(path.Join("/", parent)), scope)
+			}
 
 			rows[i] = SearchProps_Row{
 				IsItem:     r.Type == "item",
 				Name:       r.Name,
+				NameParts:  highlightText(r.NameHighlight),
 				NameHref:   fullPath,
 				ParentHref: parent,
-				Comments:   r.Comments,
+				ParentName: parentName,
+				Snippet:    highlightText(r.Snippet),
+				Created:    formatDate(r.CreatedAt),
+				Updated:    formatDate(r.UpdatedAt),
+				Permalink:  permalink(r.ID),
+			}
+			if r.AttachmentID.Valid {
+				rows[i].AttachmentName = r.AttachmentName.String
+				rows[i].AttachmentHref = path.Join("/_attachment", strconv.FormatInt(r.AttachmentID.Int64, 10))
 			}
 			if r.Image.Valid {
-				id := strconv.FormatUint(db.ToUint(r.Image.Int64), 10)
 				rows[i].ImageSrc = sql.NullString{
-					String: path.Join("/_image", id),
+					// images are displayed at most 80px wide, 160px keeps
+					// them sharp on high density screens
+					String: path.Join("/_image", r.Image.String) + "?w=160",
 					Valid:  true,
 				}
 			}
 		}
-		err = tmpl.Execute(w, SearchProps{
+		pageHref := func(q string, offset int64) string {
+			values := url.Values{"q": {q}}
+			if scopeID.Valid {
+				values.Set("scope", scope)
+			}
+			if offset > 0 {
+				values.Set("offset", strconv.FormatInt(offset, 10))
+			}
+			return "/_search?" + values.Encode()
+		}
+		props := SearchProps{
 			Query: query,
 			Rows:  rows,
-		})
+			Total: total,
+			First: offset + 1,
+			Last:  offset + int64(len(rows)),
+		}
+		if scopeID.Valid {
+			props.Scope = scope
+		}
+		if offset > 0 {
+			props.PrevHref = pageHref(query, max(offset-searchPageSize, 0))
+		}
+		if props.Last < total {
+			props.NextHref = pageHref(query, props.Last)
+		}
+		if suggestion != "" {
+			props.Suggestion = suggestion
+			props.SuggestionHref = pageHref(suggestion, 0)
+		}
+		err = tmpl.Execute(w, props)
 		return
 	})
 }
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"item-archive-d/internal/db"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+// trashResource moves the resource and its descendants into a new trash entry,
+// they are no longer visible anywhere else until they are restored. with
+// keepChildren only the resource is trashed and its children take its place.
+func trashResource(ctx context.Context, txqry *db.Queries, resource db.Resource, keepChildren bool) (err error) {
+	segments, err := txqry.GetPath(ctx, resource.ID)
+	if err != nil {
+		return
+	}
+	trashID, err := txqry.CreateTrash(ctx, db.CreateTrashParams{
+		ResourceID:       resource.ID,
+		OriginalParentID: resource.ParentID,
+		OriginalPath:     path.Join(append([]string{"/"}, segments...)...),
+	})
+	if err != nil {
+		return
+	}
+	_, err = txqry.TrashResource(ctx, db.TrashResourceParams{
+		ID:      resource.ID,
+		TrashID: trashID,
+		Shallow: keepChildren,
+	})
+	if err != nil || !keepChildren {
+		return
+	}
+	// the resource is detached first so that a child with the same name can
+	// take its place
+	err = txqry.ChangeParent(ctx, db.ChangeParentParams{
+		OldParent: sql.NullInt64{Int64: resource.ID, Valid: true},
+		NewParent: resource.ParentID,
+	})
+	return
+}
+
+// purgeTrash permanently deletes all trash entries older than the retention
+// period along with the resources in them
+func purgeTrash(ctx context.Context, c Context, retention time.Duration) (purged []int64, err error) {
+	tx, err := c.driver.BeginTx(ctx, &sql.TxOptions{
+		// no reads happen in this transaction
+		Isolation: sql.LevelReadUncommitted,
+	})
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	txqry := c.qry.WithTx(tx)
+	err = txqry.SetChangeContext(ctx, db.SetChangeContextParams{Actor: "trash"})
+	if err != nil {
+		return
+	}
+	purged, err = txqry.PurgeTrash(ctx, time.Now().Add(-retention).Unix())
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// runTrashPurger periodically purges the trash until the context is cancelled
+func runTrashPurger(ctx context.Context, c Context, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := purgeTrash(ctx, c, retention)
+			if err != nil {
+				log.Println("trash:", err)
+				continue
+			}
+			if len(purged) > 0 {
+				log.Printf("trash: purged %d entries", len(purged))
+			}
+		}
+	}
+}
+
+type TrashProps_Entry struct {
+	OriginalPath string
+	Type         string
+	Size         int64
+	Deleted      string
+	Contents     []string
+	RestoreHref  string
+}
+
+type TrashProps struct {
+	Entries    []TrashProps_Entry
+	Containers []string
+}
+
+const trash_template = `<!DOCTYPE html>
+<html>
+<head>
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Item Archive: Trash</title>
+	<style>
+	h1, h2, h3, h4, h5, h6 {
+		margin: 0.75rem 0rem;
+	}
+	* {
+		box-sizing: border-box;
+	}
+	body {
+		margin: 0;
+		padding: 0.5rem;
+	}
+	ul {
+		margin: 0.25rem 0rem;
+	}
+	</style>
+</head>
+
+<body>
+	<a href="/">&lt;&lt; Home</a>
+
+	<hr>
+
+	<h4>Trash</h4>
+	{{if not .Entries}}
+		<p>The trash is empty.</p>
+	{{end}}
+	{{range .Entries}}
+		<details>
+			<summary>
+				{{.OriginalPath}}{{if eq .Type "container"}}/{{end}}
+				({{.Size}} resource(s), deleted {{.Deleted}})
+			</summary>
+			<ul>
+				{{range .Contents}}
+					<li>{{.}}</li>
+				{{end}}
+			</ul>
+			<form action="{{.RestoreHref}}" method="post">
+				<label>
+					Restore to:
+					<input list="targets" name="__to__" placeholder="Original location">
+				</label>
+				<input type="submit" value="Restore">
+			</form>
+		</details>
+	{{end}}
+	<datalist id="targets">
+		{{range .Containers}}
+			<option value="{{.}}">
+		{{end}}
+	</datalist>
+</body>
+</html>`
+
+func (c Context) Trash() (string, func(w http.ResponseWriter, r *http.Request)) {
+	tmpl, err := template.New("trash").Parse(trash_template)
+	if err != nil {
+		panic(err)
+	}
+	return "/_trash", c.withTx(&sql.TxOptions{
+		// multiple reads
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		ctx := r.Context()
+		rows, err := txqry.ListTrash(ctx)
+		if err != nil {
+			return
+		}
+		entries := make([]TrashProps_Entry, len(rows))
+		for i, row := range rows {
+			var subtree []string
+			subtree, err = txqry.GetSubtree(ctx, row.ResourceID)
+			if err != nil {
+				return
+			}
+			contents := make([]string, len(subtree))
+			for j, p := range subtree {
+				contents[j] = path.Join(row.OriginalPath, p)
+			}
+			entries[i] = TrashProps_Entry{
+				OriginalPath: row.OriginalPath,
+				Type:         row.Type,
+				Size:         row.Size,
+				Deleted:      formatDate(row.DeletedAt),
+				Contents:     contents,
+				RestoreHref:  path.Join("/_restore", strconv.FormatInt(row.ID, 10)),
+			}
+		}
+		containers, err := txqry.GetAllContainers(ctx)
+		if err != nil {
+			return
+		}
+		containers = append([]string{"/"}, containers...)
+
+		err = tmpl.Execute(w, TrashProps{
+			Entries:    entries,
+			Containers: containers,
+		})
+		return
+	})
+}
+
+func (c Context) Restore() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "/_restore/{id}", c.withTx(&sql.TxOptions{
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		if r.Method != http.MethodPost {
+			err = methodNotAllowed(r, http.MethodPost)
+			return
+		}
+		ctx := r.Context()
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			err = notFoundf("unknown trash entry: %s", r.PathValue("id"))
+			return
+		}
+		entry, err := txqry.GetTrash(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = notFoundf("unknown trash entry: %d", id)
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		// without a destination the subtree is restored to where it was
+		// deleted from, as long as that still exists
+		to := r.FormValue("__to__")
+		var parentID sql.NullInt64
+		var parent db.Resource
+		if to == "" {
+			to = path.Dir(entry.OriginalPath)
+			if entry.OriginalParentID.Valid {
+				parent, err = txqry.GetResource(ctx, entry.OriginalParentID.Int64)
+				if errors.Is(err, sql.ErrNoRows) {
+					err = conflictf("the original location %s no longer exists, choose another one", to)
+					return
+				}
+				if err != nil {
+					return
+				}
+				parentID = entry.OriginalParentID
+			}
+		} else {
+			parentID, err = txqry.Resolve(ctx, to)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = notFoundf("unknown destination: %s", to)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if parentID.Valid {
+				parent, err = txqry.GetResource(ctx, parentID.Int64)
+				if err != nil {
+					return
+				}
+			}
+		}
+		// the original parent may have become an item since
+		if parentID.Valid && parent.Type != "container" {
+			err = validationf("%s is not a container, choose another location", to)
+			return
+		}
+
+		// the trash entry is kept so that the restore can be undone. the root
+		// is reattached in the same update that restores it, so it never
+		// collides with the names of the resources at the top level.
+		restored, err := txqry.RestoreTrash(ctx, db.RestoreTrashParams{
+			ResourceID: entry.ResourceID,
+			NewParent:  parentID,
+			TrashID:    sql.NullInt64{Int64: entry.ID, Valid: true},
+		})
+		if err != nil {
+			err = nameConflict(err, "a resource named %q already exists in %s, choose another location", path.Base(entry.OriginalPath), trailingPath(path.Join("/", to)))
+			return
+		}
+		if restored == 0 {
+			err = notFoundf("trash entry %d has already been restored", id)
+			return
+		}
+
+		w.Header().Set("Location", withUndo(r, trailingPath(path.Join("/", to))))
+		w.WriteHeader(303)
+		return
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"item-archive-d/internal/db"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestore(t *testing.T) {
+	ctx := t.Context()
+	router := newTestContext(t)
+	h := router.routes()
+	for _, r := range []db.CreateResourceParams{
+		{Name: "box", Type: "container"},
+		{Name: "drill", Type: "item"},
+		{Name: "bit", Type: "item"},
+	} {
+		_, err := router.qry.CreateResource(ctx, r)
+		require.NoError(t, err)
+	}
+
+	w := serve(h, formRequest(t, "/_delete_deep/bit", nil))
+	require.Equal(t, 303, w.Code, w.Body.String())
+	var entry int64
+	err := router.driver.QueryRowContext(ctx, "select id from trash where original_path = '/bit'").Scan(&entry)
+	require.NoError(t, err)
+	restore := fmt.Sprintf("/_restore/%d", entry)
+
+	// items cannot contain other resources
+	w = serve(h, formRequest(t, restore, map[string]string{"__to__": "/drill"}))
+	require.Equal(t, 400, w.Code, w.Body.String())
+
+	w = serve(h, formRequest(t, restore, map[string]string{"__to__": "/box"}))
+	require.Equal(t, 303, w.Code, w.Body.String())
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, "/box/", location.Path)
+	id, err := router.qry.Resolve(ctx, "/box/bit")
+	require.NoError(t, err)
+	require.True(t, id.Valid)
+
+	// the entry is kept after the restore, purging it leaves the restored
+	// resource alone but the restore can no longer be undone
+	purged, err := purgeTrash(ctx, router, -time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, []int64{entry}, purged)
+	_, err = router.qry.GetResource(ctx, id.Int64)
+	require.NoError(t, err)
+	w = serve(h, formRequest(t, "/_undo/"+location.Query().Get("undo"), nil))
+	require.Equal(t, 409, w.Code, w.Body.String())
+	r, err := router.qry.GetResource(ctx, id.Int64)
+	require.NoError(t, err)
+	require.Equal(t, sql.NullInt64{}, r.TrashID)
+}
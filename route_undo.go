@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"item-archive-d/internal/blob"
+	"item-archive-d/internal/db"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// undoChange restores the resource of a history row to how it was before the
+// change, resources which were created are moved to the trash so that
+// undoing the undo can bring them back
+func undoChange(ctx context.Context, txqry *db.Queries, blobs blob.Store, h db.History) (err error) {
+	before, after, err := h.Snapshots()
+	if err != nil {
+		return
+	}
+	switch {
+	case after == nil:
+		err = conflictf("resource '%s' has been deleted permanently", before.Name)
+		return
+	case before == nil:
+		var hasChildren int64
+		hasChildren, err = txqry.HasChildren(ctx, sql.NullInt64{Int64: h.ResourceID, Valid: true})
+		if err != nil {
+			return
+		}
+		if hasChildren != 0 {
+			err = conflictf("resource '%s' has gained contents since it was created", after.Name)
+			return
+		}
+		var resource db.Resource
+		resource, err = txqry.GetResource(ctx, h.ResourceID)
+		if errors.Is(err, sql.ErrNoRows) {
+			// already in the trash
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		err = trashResource(ctx, txqry, resource, false)
+		return
+	}
+
+	var parentID, trashID sql.NullInt64
+	if before.ParentID != nil {
+		parentID = sql.NullInt64{Int64: *before.ParentID, Valid: true}
+	}
+	if before.TrashID != nil {
+		trashID = sql.NullInt64{Int64: *before.TrashID, Valid: true}
+	}
+	var image sql.NullString
+	if before.Image != nil {
+		image = sql.NullString{String: *before.Image, Valid: true}
+	}
+	// an image which is restored must still exist, an unchanged one is left
+	// alone even if it is missing
+	if image.Valid && !equalPtr(before.Image, after.Image) {
+		var f *os.File
+		f, err = blobs.Open(image.String)
+		if os.IsNotExist(err) {
+			err = conflictf("the former image of resource '%s' has been garbage collected, the operation can no longer be undone", before.Name)
+			return
+		}
+		if err != nil {
+			return
+		}
+		f.Close()
+	}
+	// resources which are put back into the trash need their entry, it is
+	// gone once the trash has been purged
+	if trashID.Valid && !equalPtr(before.TrashID, after.TrashID) {
+		_, err = txqry.GetTrash(ctx, trashID.Int64)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = conflictf("the trash entry of resource '%s' has been purged, the operation can no longer be undone", before.Name)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+	if after.TrashID == nil && before.TrashID == nil &&
+		(before.Name != after.Name || !equalPtr(before.ParentID, after.ParentID)) {
+		err = rememberPath(ctx, txqry, h.ResourceID)
+		if err != nil {
+			return
+		}
+	}
+	err = txqry.UndoResource(ctx, db.UndoResourceParams{
+		ID:       h.ResourceID,
+		ParentID: parentID,
+		Name:     before.Name,
+		Type:     before.Type,
+		Comments: before.Comments,
+		Image:    image,
+		TrashID:  trashID,
+	})
+	return
+}
+
+func (c Context) Undo() (string, func(w http.ResponseWriter, r *http.Request)) {
+	return "/_undo/{op}", c.withTx(&sql.TxOptions{
+		// multiple reads and writes interleaved
+		Isolation: sql.LevelSerializable,
+	}, func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) (err error) {
+		if r.Method != http.MethodPost {
+			err = methodNotAllowed(r, http.MethodPost)
+			return
+		}
+		ctx := r.Context()
+		id, err := strconv.ParseInt(r.PathValue("op"), 10, 64)
+		if err != nil {
+			err = notFoundf("unknown operation: %s", r.PathValue("op"))
+			return
+		}
+		operation, err := txqry.GetOperation(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = notFoundf("unknown operation: %d", id)
+			return
+		}
+		if err != nil {
+			return
+		}
+		if operation.UndoneAt.Valid {
+			err = conflictf("the operation has already been undone")
+			return
+		}
+
+		opID := sql.NullInt64{Int64: operation.ID, Valid: true}
+		changed, err := txqry.CountChangesAfterOperation(ctx, opID)
+		if err != nil {
+			return
+		}
+		if changed > 0 {
+			err = conflictf("the resources changed by the operation have been changed since, it can no longer be undone")
+			return
+		}
+
+		// newest first, so each resource ends up as it was before its first
+		// change in the operation
+		rows, err := txqry.ListOperationHistory(ctx, opID)
+		if err != nil {
+			return
+		}
+		for _, h := range rows {
+			err = undoChange(ctx, txqry, c.blobs, h)
+			if err != nil {
+				return
+			}
+		}
+		// moving resources back may close a loop if their former parents
+		// have been moved into them since
+		for _, h := range rows {
+			_, err = txqry.GetPath(ctx, h.ResourceID)
+			if errors.Is(err, db.ErrCycle) {
+				err = conflictf("undoing the operation would move a resource into its own subtree")
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+		err = txqry.MarkOperationUndone(ctx, operation.ID)
+		if err != nil {
+			return
+		}
+
+		// only paths of this site are followed, browsers treat "/\host" like
+		// "//host" and go to another site
+		location := "/"
+		redirect, parseErr := url.Parse(r.FormValue("redirect"))
+		if parseErr == nil && redirect.Scheme == "" && redirect.Host == "" &&
+			strings.HasPrefix(redirect.Path, "/") && !strings.HasPrefix(redirect.Path, "//") &&
+			!strings.Contains(redirect.Path, `\`) {
+			location = redirect.Path
+		}
+		w.Header().Set("Location", withUndo(r, location))
+		w.WriteHeader(303)
+		return
+	})
+}
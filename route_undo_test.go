@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"item-archive-d/internal/db"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndo(t *testing.T) {
+	ctx := t.Context()
+	router := newTestContext(t)
+	h := router.routes()
+	box, err := router.qry.CreateResource(ctx, db.CreateResourceParams{Name: "box", Type: "container"})
+	require.NoError(t, err)
+	_, err = router.qry.CreateResource(ctx, db.CreateResourceParams{
+		ParentID: sql.NullInt64{Int64: box, Valid: true},
+		Name:     "a",
+		Type:     "item",
+	})
+	require.NoError(t, err)
+
+	// location returns the path and the undo operation of the redirect
+	location := func(t *testing.T, w *httptest.ResponseRecorder) (string, string) {
+		t.Helper()
+		require.Equal(t, 303, w.Code, w.Body.String())
+		u, err := url.Parse(w.Header().Get("Location"))
+		require.NoError(t, err)
+		require.Empty(t, u.Scheme)
+		require.Empty(t, u.Host)
+		return u.Path, u.Query().Get("undo")
+	}
+	exists := func(p string) bool {
+		id, err := router.qry.Resolve(ctx, p)
+		return err == nil && id.Valid
+	}
+	deleteA := func(t *testing.T) string {
+		t.Helper()
+		p, op := location(t, serve(h, formRequest(t, "/_delete_deep/box/a", nil)))
+		require.Equal(t, "/box/", p)
+		require.NotEmpty(t, op)
+		require.False(t, exists("/box/a"))
+		return op
+	}
+
+	t.Run("redirect", func(t *testing.T) {
+		for redirect, want := range map[string]string{
+			"":                      "/",
+			"/box/":                 "/box/",
+			"/box/?sort=name#x":     "/box/",
+			"//evil.example/":       "/",
+			`/\evil.example/`:       "/",
+			"https://evil.example/": "/",
+			"javascript:alert(1)":   "/",
+			"box/":                  "/",
+		} {
+			op := deleteA(t)
+			r := formRequest(t, "/_undo/"+op, map[string]string{"redirect": redirect})
+			p, undo := location(t, serve(h, r))
+			require.Equal(t, want, p, redirect)
+			// the undo can be undone again
+			require.NotEmpty(t, undo)
+			require.NotEqual(t, op, undo)
+			require.True(t, exists("/box/a"))
+		}
+	})
+
+	t.Run("twice", func(t *testing.T) {
+		op := deleteA(t)
+		_, _ = location(t, serve(h, formRequest(t, "/_undo/"+op, nil)))
+		w := serve(h, formRequest(t, "/_undo/"+op, nil))
+		require.Equal(t, 409, w.Code)
+		require.True(t, exists("/box/a"))
+	})
+
+	t.Run("change context", func(t *testing.T) {
+		// the operations of requests which changed nothing are deleted
+		p, op := location(t, serve(h, formRequest(t, "/_update/box", map[string]string{"name": "box", "type": "container"})))
+		require.Equal(t, "/", p)
+		id, err := strconv.ParseInt(op, 10, 64)
+		require.NoError(t, err)
+		_, err = router.qry.GetOperation(ctx, id)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		// changes made outside of requests are not part of an operation
+		id, err = router.qry.CreateResource(ctx, db.CreateResourceParams{Name: "c", Type: "item"})
+		require.NoError(t, err)
+		var operation sql.NullInt64
+		err = router.driver.QueryRowContext(ctx, "select operation_id from history where resource_id = ?", id).Scan(&operation)
+		require.NoError(t, err)
+		require.False(t, operation.Valid)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		op := deleteA(t)
+		w := serve(h, httptest.NewRequest("GET", "/_undo/"+op, nil))
+		require.Equal(t, 405, w.Code)
+		require.False(t, exists("/box/a"))
+
+		w = serve(h, formRequest(t, "/_undo/x", nil))
+		require.Equal(t, 404, w.Code)
+	})
+}
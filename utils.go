@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"item-archive-d/internal/blob"
 	"item-archive-d/internal/db"
-	"log"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 )
 
 func (c Context) withTx(options *sql.TxOptions, fn func(txqry *db.Queries, w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) {
@@ -14,25 +17,57 @@ func (c Context) withTx(options *sql.TxOptions, fn func(txqry *db.Queries, w htt
 		ctx := r.Context()
 		tx, err := c.driver.BeginTx(ctx, options)
 		if err != nil {
-			w.WriteHeader(500)
-			w.Write([]byte(err.Error()))
-			log.Println(err)
+			writeError(w, r, err)
 			return
 		}
 		defer tx.Rollback()
 		txqry := c.qry.WithTx(tx)
+		// changes to resources are attributed to the actor in the history and
+		// grouped into an operation so that they can be undone together
+		var operation int64
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			actor := "web"
+			if isApiRequest(r) {
+				actor = "api"
+			}
+			operation, err = txqry.CreateOperation(ctx, r.Method+" "+r.URL.Path)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			err = txqry.SetChangeContext(ctx, db.SetChangeContextParams{
+				Actor:       actor,
+				OperationID: sql.NullInt64{Int64: operation, Valid: true},
+			})
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			r = r.WithContext(context.WithValue(ctx, operationKey{}, operation))
+		}
 		err = fn(txqry, w, r)
 		if err != nil {
-			w.WriteHeader(500)
-			w.Write([]byte(err.Error()))
-			log.Println(err)
+			writeError(w, r, err)
 			return
 		}
+		if operation != 0 {
+			// later writers which do not set the change context must not
+			// add their changes to this operation
+			err = txqry.ClearChangeOperation(ctx)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			// requests which did not change anything have nothing to undo
+			err = txqry.DeleteUnusedOperation(ctx, operation)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+		}
 		err = tx.Commit()
 		if err != nil {
-			w.WriteHeader(500)
-			w.Write([]byte(err.Error()))
-			log.Println(err)
+			writeError(w, r, err)
 			return
 		}
 	}
@@ -42,13 +77,25 @@ func (c Context) withError(fn func(w http.ResponseWriter, r *http.Request) error
 	return func(w http.ResponseWriter, r *http.Request) {
 		err := fn(w, r)
 		if err != nil {
-			w.Write([]byte(err.Error()))
-			log.Println(err)
+			writeError(w, r, err)
 			return
 		}
 	}
 }
 
+type operationKey struct{}
+
+// withUndo returns the location of the given path with the operation of the
+// request added to its query, so that the page redirected to can offer to
+// undo it
+func withUndo(r *http.Request, p string) string {
+	location := url.URL{Path: p}
+	if operation, ok := r.Context().Value(operationKey{}).(int64); ok {
+		location.RawQuery = url.Values{"undo": {strconv.FormatInt(operation, 10)}}.Encode()
+	}
+	return location.String()
+}
+
 // trailingPath adds a trailing '/' to the given path if it does not already
 // exist
 func trailingPath(p string) string {
@@ -61,7 +108,7 @@ func trailingPath(p string) string {
 	return p + "/"
 }
 
-func handleImageUpload(blobs blob.Store, img *multipart.FileHeader) (id sql.NullInt64, err error) {
+func handleImageUpload(blobs blob.Store, img *multipart.FileHeader) (id sql.NullString, err error) {
 	if img == nil {
 		return
 	}
@@ -70,15 +117,25 @@ func handleImageUpload(blobs blob.Store, img *multipart.FileHeader) (id sql.Null
 	if err != nil {
 		return
 	}
-	var imageId uint64
+	defer file.Close()
+	var imageId string
 	imageId, err = blobs.Store(file)
 	if err != nil {
 		return
 	}
-	id = sql.NullInt64{Int64: db.ToInt(imageId), Valid: true}
+	id = sql.NullString{String: imageId, Valid: true}
 	return
 }
 
+// formatDate formats a unix timestamp for display, 0 is an unknown time and
+// is displayed as "-"
+func formatDate(unix int64) string {
+	if unix == 0 {
+		return "-"
+	}
+	return time.Unix(unix, 0).Format("2006-01-02")
+}
+
 func first[T any](m map[string][]T, key string) T {
 	list, ok := m[key]
 	if !ok {
@@ -91,3 +148,17 @@ func first[T any](m map[string][]T, key string) T {
 	}
 	return list[0]
 }
+
+// formatSize formats a number of bytes for display
+func formatSize(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return strconv.FormatFloat(float64(n)/float64(div), 'f', 1, 64) + " " + string("kMGTPE"[exp]) + "B"
+}